@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// The control API lets other programs of the user, eg. a launcher or a hotkey
+// daemon, drive the running client over a unix socket. Requests and responses are
+// JSON-RPC 2.0 objects, one per line, eg.
+//
+//	{"jsonrpc": "2.0", "id": 1, "method": "send_keys", "params": {"text": "hello\n"}}
+//	{"jsonrpc":"2.0","id":1,"result":{"sent":12}}
+//
+// The methods are status, connect, switch, disconnect, pause, resume and send_keys.
+
+// Returns where the control API is served by default: the user's runtime
+// directory, which only the user can write to. It's empty without one, as a
+// known path in a shared directory like /tmp could be taken by another user first.
+func defaultControlSocket() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "virt-kbd-client.sock")
+	}
+	return ""
+}
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"` // absent for notifications, which get no response
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      json.RawMessage  `json:"id"`
+	Result  *json.RawMessage `json:"result,omitempty"` // null rather than absent on success
+	Error   *rpcError        `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+// error codes from the JSON-RPC 2.0 spec
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcFailed         = -32000 // the method failed, eg. the target couldn't be reached
+)
+
+// Where connect and switch connect to: a host and a port, a unix socket or a
+// server found on the local network, the same as the flags of the client
+type connectParams struct {
+	Addr   string `json:"addr"`   // host:port
+	UDP    bool   `json:"udp"`    // over the datagram transport
+	Unix   string `json:"unix"`   // path of a unix socket
+	Target string `json:"target"` // name or fingerprint of a server advertised on the network
+}
+
+// Keys send_keys sends: events the way the headless mode reads them, eg. "30 down",
+// followed by the keys typing text takes on a US keyboard
+type sendKeysParams struct {
+	Keys []string `json:"keys"`
+	Text string   `json:"text"`
+}
+
+// Serves the control API of a client forwarding keys to link
+type controlServer struct {
+	ln    *net.UnixListener
+	link  *targetLink
+	name  string          // the name the client introduces itself with
+	where *discoveryFlags // where to look for servers given by name, nil if nowhere
+}
+
+// Listens on a unix socket at path only the user running the client can use.
+// A socket left at path by a client that's gone is replaced.
+func serveControl(path string, link *targetLink, name string, where *discoveryFlags) (*controlServer, error) {
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, errors.New("another client serves the control API at " + path)
+	}
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, errors.New("couldn't listen for control requests: " + err.Error())
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return nil, errors.New("couldn't restrict the control socket: " + err.Error())
+	}
+	s := &controlServer{ln: ln, link: link, name: name, where: where}
+	slog.Info("serving the control API", "path", path)
+	go s.accept()
+	return s, nil
+}
+
+func (s *controlServer) accept() {
+	for {
+		conn, err := s.ln.AcceptUnix()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				slog.Error("couldn't accept a control connection", errAttr(err))
+			}
+			return
+		}
+		go func() {
+			defer conn.Close()
+			s.serve(conn)
+		}()
+	}
+}
+
+// checks that the peer runs as the same user as the client
+func checkControlPeer(conn *net.UnixConn) error {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var cred *unix.Ucred
+	var credErr error
+	if err := rc.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); err != nil {
+		return err
+	}
+	if credErr != nil {
+		return credErr
+	}
+	if int(cred.Uid) != os.Getuid() {
+		return fmt.Errorf("uid %d isn't the one running the client", cred.Uid)
+	}
+	return nil
+}
+
+// Answers the requests coming over conn until it's closed
+func (s *controlServer) serve(conn *net.UnixConn) {
+	if err := checkControlPeer(conn); err != nil {
+		slog.Warn("refused a control connection", errAttr(err))
+		return
+	}
+	scanner := bufio.NewScanner(conn)
+	enc := json.NewEncoder(conn)
+	for scanner.Scan() {
+		var req rpcRequest
+		resp := rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null")}
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			resp.Error = &rpcError{Code: rpcParseError, Message: err.Error()}
+		} else if req.JSONRPC != "2.0" || req.Method == "" {
+			resp.Error = &rpcError{Code: rpcInvalidRequest, Message: "expected a JSON-RPC 2.0 request"}
+		} else {
+			if req.ID != nil {
+				resp.ID = req.ID
+			}
+			result, err := s.call(req.Method, req.Params)
+			if req.ID == nil {
+				continue
+			}
+			if err == nil {
+				var data []byte
+				if data, err = json.Marshal(result); err == nil {
+					resp.Result = (*json.RawMessage)(&data)
+				}
+			}
+			if err != nil {
+				var rpcErr *rpcError
+				if !errors.As(err, &rpcErr) {
+					rpcErr = &rpcError{Code: rpcFailed, Message: err.Error()}
+				}
+				resp.Error = rpcErr
+			}
+		}
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+// Runs a method. The result is null for methods that don't return anything.
+func (s *controlServer) call(method string, params json.RawMessage) (any, error) {
+	slog.Debug("control request", "method", method)
+	switch method {
+	case "status":
+		return s.link.status(), nil
+	case "connect", "switch":
+		var p connectParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		if method == "connect" && s.link.status().Connected {
+			// checked again once connected, another request may have connected meanwhile
+			return nil, errAlreadyConnected
+		}
+		return s.connect(p, method == "switch")
+	case "disconnect":
+		return nil, s.link.detach()
+	case "pause", "resume":
+		s.link.setPaused(method == "pause")
+		return s.link.status(), nil
+	case "send_keys":
+		var p sendKeysParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		events, err := p.events()
+		if err != nil {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+		}
+		if err := s.link.sendKeys(events); err != nil {
+			return nil, err
+		}
+		return map[string]int{"sent": len(events)}, nil
+	}
+	return nil, &rpcError{Code: rpcMethodNotFound, Message: "unknown method " + method}
+}
+
+func decodeParams(params json.RawMessage, v any) error {
+	if len(params) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+// Connects to the target p tells and makes it the one the keys go to, in place
+// of the one they went to if replace is set
+func (s *controlServer) connect(p connectParams, replace bool) (linkStatus, error) {
+	network, addr := "tcp", p.Addr
+	switch {
+	case p.Target != "":
+		if s.where == nil {
+			return linkStatus{}, errors.New("can't look for servers")
+		}
+		targets, err := s.where.discover(discoveryTimeout)
+		if err != nil {
+			return linkStatus{}, errors.New("couldn't look for servers: " + err.Error())
+		}
+		t, err := selectTarget(targets, p.Target)
+		if err != nil {
+			return linkStatus{}, err
+		}
+		addr = t.addr()
+	case p.Unix != "":
+		network, addr = "unix", p.Unix
+	case p.Addr == "":
+		return linkStatus{}, &rpcError{Code: rpcInvalidParams, Message: "expected addr, unix or target"}
+	}
+	if p.UDP && network == "tcp" {
+		network = "udp"
+	}
+	remote, err := connectToRemote(network, addr, s.name)
+	if err != nil {
+		return linkStatus{}, errors.New("couldn't connect to the target machine: " + err.Error())
+	}
+	if err := s.link.attach(remote, network, addr, replace); err != nil {
+		remote.Close()
+		return linkStatus{}, err
+	}
+	slog.Info("switched to another target machine", "network", network, "remote", addr)
+	return s.link.status(), nil
+}
+
+func (p *sendKeysParams) events() ([]KeyEvent, error) {
+	events := make([]KeyEvent, 0, len(p.Keys))
+	for _, key := range p.Keys {
+		ke, err := ParseKeyEvent(key)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %v", key, err)
+		}
+		events = append(events, ke)
+	}
+	typed, err := textKeyEvents(p.Text)
+	if err != nil {
+		return nil, err
+	}
+	return append(events, typed...), nil
+}
+
+// Stops accepting control connections and removes the socket
+func (s *controlServer) Close() error {
+	return s.ln.Close()
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A server accepting a single client, which it hands over once it said hello
+func startTarget(t *testing.T) (string, chan net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		t.Cleanup(func() { conn.Close() })
+		hello := make([]byte, 6)
+		io.ReadFull(conn, hello)
+		io.ReadFull(conn, make([]byte, hello[5]))
+		accepted <- conn
+	}()
+	return ln.Addr().String(), accepted
+}
+
+// closes the server's end of a connection once the client is done with it
+func closeWhenDone(conn net.Conn) {
+	go func() {
+		io.Copy(io.Discard, conn)
+		conn.Close()
+	}()
+}
+
+func expectKeys(t *testing.T, conn net.Conn, events ...KeyEvent) {
+	t.Helper()
+	var expected []byte
+	for _, ke := range events {
+		msg, err := encodeKeyMsg(ke)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected = append(expected, msg...)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if got := readSent(t, conn, len(expected)); !bytes.Equal(got, expected) {
+		t.Errorf("received %v, expected %v", got, expected)
+	}
+}
+
+func status(t *testing.T, path string) linkStatus {
+	t.Helper()
+	result, err := callControl(path, "status", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s linkStatus
+	if err := json.Unmarshal(result, &s); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestControl(t *testing.T) {
+	remote, first := connectRemote(t)
+	link := &targetLink{}
+	link.attach(remote, "tcp", "first", false)
+	lost := make(chan struct{}, 1)
+	link.watch(func(ledState) {}, func() { lost <- struct{}{} })
+	path := filepath.Join(t.TempDir(), "control.sock")
+	ctl, err := serveControl(path, link, "test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctl.Close()
+	if _, err := serveControl(path, link, "test", nil); err == nil {
+		t.Error("a second client serves the control API on the same socket")
+	}
+
+	if s := status(t, path); !s.Connected || s.Addr != "first" || s.Network != "tcp" || s.Paused {
+		t.Errorf("unexpected status %+v", s)
+	}
+	if _, err := callControl(path, "pause", nil); err != nil {
+		t.Fatal(err)
+	}
+	link.sendKey(KeyEvent{scanCode: 30, state: true}) // dropped
+	if s := status(t, path); !s.Paused || link.title() != "virt-kbd: first (paused)" {
+		t.Errorf("unexpected status %+v, title %q", s, link.title())
+	}
+	// keys sent through the API go out while the window's are paused
+	result, err := callControl(path, "send_keys", sendKeysParams{Keys: []string{"28 down", "28 up"}, Text: "Hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result) != `{"sent":8}` {
+		t.Errorf("unexpected result %s", result)
+	}
+	callControl(path, "resume", nil)
+	link.sendKey(KeyEvent{scanCode: 30, state: true})
+	expectKeys(t, first,
+		KeyEvent{28, true}, KeyEvent{28, false},
+		KeyEvent{keyLeftShift, true}, KeyEvent{35, true}, KeyEvent{35, false}, KeyEvent{keyLeftShift, false},
+		KeyEvent{23, true}, KeyEvent{23, false},
+		KeyEvent{30, true})
+
+	if _, err := callControl(path, "connect", connectParams{Addr: "127.0.0.1:1"}); err == nil || !strings.Contains(err.Error(), "already connected") {
+		t.Errorf("connecting while connected: %v", err)
+	}
+	addr, accepted := startTarget(t)
+	closeWhenDone(first)
+	if _, err := callControl(path, "switch", connectParams{Addr: addr}); err != nil {
+		t.Fatal(err)
+	}
+	second := <-accepted
+	if s := status(t, path); !s.Connected || s.Addr != addr {
+		t.Errorf("unexpected status after switching %+v", s)
+	}
+	link.sendKey(KeyEvent{scanCode: 31, state: true})
+	expectKeys(t, second, KeyEvent{31, true})
+
+	closeWhenDone(second)
+	if _, err := callControl(path, "disconnect", nil); err != nil {
+		t.Fatal(err)
+	}
+	if s := status(t, path); s.Connected || link.title() != "virt-kbd: disconnected" {
+		t.Errorf("unexpected status after disconnecting %+v", s)
+	}
+	if _, err := callControl(path, "send_keys", sendKeysParams{Text: "a"}); err == nil {
+		t.Error("keys are sent while disconnected")
+	}
+	select {
+	case <-lost:
+		t.Error("the connection is lost after the client dropped it")
+	default:
+	}
+}
+
+func TestPauseWhileHeld(t *testing.T) {
+	remote, server := connectRemote(t)
+	link := &targetLink{}
+	link.attach(remote, "tcp", "test", false)
+	link.sendKey(KeyEvent{scanCode: keyLeftShift, state: true})
+	link.setPaused(true)
+	link.sendKey(KeyEvent{scanCode: 30, state: true}) // dropped
+	// shift was pressed before the pause, so it isn't left held on the target
+	link.sendKey(KeyEvent{scanCode: keyLeftShift, state: false})
+	link.sendKey(KeyEvent{scanCode: 30, state: false}) // dropped, its press was
+	link.setPaused(false)
+	link.sendKey(KeyEvent{scanCode: 31, state: true})
+	expectKeys(t, server, KeyEvent{keyLeftShift, true}, KeyEvent{keyLeftShift, false}, KeyEvent{31, true})
+}
+
+func TestDefaultControlSocket(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", "/run/user/1000")
+	if path := defaultControlSocket(); path != "/run/user/1000/virt-kbd-client.sock" {
+		t.Errorf("unexpected socket %q", path)
+	}
+	// rather than a path in a directory other users can write to
+	t.Setenv("XDG_RUNTIME_DIR", "")
+	if path := defaultControlSocket(); path != "" {
+		t.Errorf("got socket %q without a runtime directory", path)
+	}
+}
+
+func TestControlErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control.sock")
+	ctl, err := serveControl(path, &targetLink{}, "test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctl.Close()
+	for _, test := range []struct {
+		method string
+		params any
+		code   int
+	}{
+		{"reboot", nil, rpcMethodNotFound},
+		{"send_keys", sendKeysParams{Text: "żółw"}, rpcInvalidParams},
+		{"send_keys", sendKeysParams{Keys: []string{"30 sideways"}}, rpcInvalidParams},
+		{"connect", map[string]any{"addr": 3001}, rpcInvalidParams},
+		{"connect", connectParams{}, rpcInvalidParams},
+		{"connect", connectParams{Addr: "127.0.0.1:1"}, rpcFailed},
+	} {
+		_, err := callControl(path, test.method, test.params)
+		var rpcErr *rpcError
+		if !errors.As(err, &rpcErr) || rpcErr.Code != test.code {
+			t.Errorf("%s %+v: got %v, expected code %d", test.method, test.params, err, test.code)
+		}
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// a notification gets no response, the request after it does
+	conn.Write([]byte("{\"jsonrpc\": \"2.0\", \"method\": \"pause\"}\nnot json\n{\"jsonrpc\": \"2.0\", \"id\": \"x\", \"method\": \"status\"}\n"))
+	r := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for _, expected := range []string{
+		`{"jsonrpc":"2.0","id":null,"error":{"code":-32700,`,
+		`{"jsonrpc":"2.0","id":"x","result":{"connected":false,"paused":true,`,
+	} {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(line, expected) {
+			t.Errorf("response %q, expected %q...", line, expected)
+		}
+	}
+}
+
+func TestConcurrentAttach(t *testing.T) {
+	link := &targetLink{}
+	errs := make(chan error, 2)
+	for range 2 {
+		remote, server := connectRemote(t)
+		closeWhenDone(server)
+		go func() {
+			err := link.attach(remote, "tcp", "test", false)
+			if err != nil {
+				remote.Close()
+			}
+			errs <- err
+		}()
+	}
+	failed := 0
+	for range 2 {
+		if err := <-errs; err != nil {
+			failed++
+			if !errors.Is(err, errAlreadyConnected) {
+				t.Errorf("unexpected error %v", err)
+			}
+		}
+	}
+	if failed != 1 {
+		t.Errorf("%d of the connections failed to attach, expected one", failed)
+	}
+	link.detach()
+}
+
+func TestCtlRequest(t *testing.T) {
+	for _, test := range []struct {
+		args   string
+		method string
+		params any
+	}{
+		{"status", "status", nil},
+		{"connect 192.168.1.2 3001", "connect", connectParams{Addr: "192.168.1.2:3001"}},
+		{"switch -udp 192.168.1.2 3001", "switch", connectParams{Addr: "192.168.1.2:3001", UDP: true}},
+		{"switch -unix /tmp/virt-kbd.sock", "switch", connectParams{Unix: "/tmp/virt-kbd.sock"}},
+		{"connect -target pi-kitchen", "connect", connectParams{Target: "pi-kitchen"}},
+		{"type hello", "send_keys", sendKeysParams{Text: "hello"}},
+	} {
+		method, params, err := ctlRequest(strings.Fields(test.args))
+		expected, _ := json.Marshal(test.params)
+		got, _ := json.Marshal(params)
+		if err != nil || method != test.method || !bytes.Equal(got, expected) {
+			t.Errorf("%q: %s %s (%v), expected %s %s", test.args, method, got, err, test.method, expected)
+		}
+	}
+	if method, params, _ := ctlRequest([]string{"send-keys", "30 down", "30 up"}); method != "send_keys" || len(params.(sendKeysParams).Keys) != 2 {
+		t.Errorf("send-keys: %s %+v", method, params)
+	}
+	for _, args := range []string{"", "status now", "connect", "connect 192.168.1.2", "switch -unix", "type", "reboot"} {
+		if _, _, err := ctlRequest(strings.Fields(args)); err == nil {
+			t.Errorf("%q is accepted", args)
+		}
+	}
+}
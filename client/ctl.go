@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// how long ctl waits for a response, long enough for a server to be looked for
+// and the previous one to close the connection
+const ctlTimeout = 30 * time.Second
+
+// Sends a request to the control API at path and returns its result
+func callControl(path string, method string, params any) (json.RawMessage, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, errors.New("couldn't reach the client, is it running? " + err.Error())
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(ctlTimeout))
+	req := map[string]any{"jsonrpc": "2.0", "id": 1, "method": method}
+	if params != nil {
+		req["params"] = params
+	}
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, err
+	}
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		return nil, errors.New("no response from the client: " + err.Error())
+	}
+	var resp rpcResponse
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return nil, errors.New("invalid response from the client: " + err.Error())
+	}
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+	if resp.Result == nil {
+		return json.RawMessage("null"), nil
+	}
+	return *resp.Result, nil
+}
+
+// Returns the method and the params of a ctl command
+func ctlRequest(args []string) (string, any, error) {
+	if len(args) == 0 {
+		return "", nil, errors.New("no command given")
+	}
+	switch cmd := args[0]; cmd {
+	case "status", "disconnect", "pause", "resume":
+		if len(args) > 1 {
+			return "", nil, fmt.Errorf("%s takes no arguments", cmd)
+		}
+		return cmd, nil, nil
+	case "connect", "switch":
+		fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		var p connectParams
+		fs.BoolVar(&p.UDP, "udp", false, "")
+		fs.StringVar(&p.Unix, "unix", "", "")
+		fs.StringVar(&p.Target, "target", "", "")
+		if err := fs.Parse(args[1:]); err != nil {
+			return "", nil, err
+		}
+		switch {
+		case fs.NArg() == 2 && p.Unix == "" && p.Target == "":
+			p.Addr = net.JoinHostPort(fs.Arg(0), fs.Arg(1))
+		case fs.NArg() != 0 || (p.Unix == "" && p.Target == ""):
+			return "", nil, fmt.Errorf("%s takes a host and a port, -unix path or -target name", cmd)
+		}
+		return cmd, p, nil
+	case "send-keys":
+		if len(args) == 1 {
+			return "", nil, errors.New("send-keys takes key events, eg. \"30 down\" \"30 up\"")
+		}
+		return "send_keys", sendKeysParams{Keys: args[1:]}, nil
+	case "type":
+		if len(args) != 2 {
+			return "", nil, errors.New("type takes the text to type")
+		}
+		return "send_keys", sendKeysParams{Text: args[1]}, nil
+	}
+	return "", nil, fmt.Errorf("unknown command %q", args[0])
+}
+
+// The ctl command: drives a running client through its control API
+func runCtl(args []string) int {
+	fs := flag.NewFlagSet("ctl", flag.ExitOnError)
+	socket := fs.String("socket", defaultControlSocket(), "control socket of the client")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `usage: %s ctl [-socket path] command [args]
+
+commands:
+  status                                     show the target and whether forwarding is paused
+  connect [-udp] host port | -unix path | -target name
+                                             connect to a target after disconnect
+  switch [-udp] host port | -unix path | -target name
+                                             connect to another target, dropping the one there is
+  disconnect                                 drop the connection to the target
+  pause, resume                              stop and start forwarding the keys pressed in the window
+  send-keys event...                         send key events, eg. "30 down" "30 up"
+  type text                                  type text as on a US keyboard
+
+flags:
+`, os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	method, params, err := ctlRequest(fs.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		fs.Usage()
+		return 2
+	}
+	if *socket == "" {
+		fmt.Fprintln(os.Stderr, "XDG_RUNTIME_DIR isn't set, -socket tells where the client serves the control API")
+		return 2
+	}
+	result, err := callControl(*socket, method, params)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	var pretty bytes.Buffer
+	if string(result) != "null" && json.Indent(&pretty, result, "", "  ") == nil {
+		fmt.Println(pretty.String())
+	}
+	return 0
+}
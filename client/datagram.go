@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/rand/v2"
+	"net"
+
+	"virt-kbd/dgram"
+)
+
+// Starts a session of the datagram transport with a server at addr, a host and
+// a port. Nothing is sent until the first write.
+func dialDatagram(addr string) (*dgram.Conn, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, udpAddr)
+	if err != nil {
+		return nil, err
+	}
+	c := dgram.NewConn(rand.Uint32(), conn.LocalAddr(), udpAddr, func(d []byte) error {
+		_, err := conn.Write(d)
+		return err
+	}, func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 2*dgram.MaxSize)
+		for {
+			n, err := conn.Read(buf)
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if err != nil {
+				// eg. nothing listens on the port of the server
+				c.Fail(err)
+				return
+			}
+			if n < dgram.HeaderSize || binary.LittleEndian.Uint32(buf[1:]) != c.Session() {
+				continue
+			}
+			c.HandleDatagram(buf[0], buf[dgram.HeaderSize:n])
+		}
+	}()
+	return c, nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDatagramRefused(t *testing.T) {
+	// a port nothing listens on
+	ln, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.LocalAddr().String()
+	ln.Close()
+	c, err := dialDatagram(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	writeHello(c, "test")
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = c.Read(make([]byte, 1))
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Fatalf("got %v, expected the connection to be refused", err)
+	}
+}
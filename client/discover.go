@@ -0,0 +1,250 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"virt-kbd/dnsmsg"
+)
+
+// Finding servers on the local network, advertised by them with multicast DNS
+
+const (
+	mdnsService = "_virt-kbd._tcp.local."
+	// how long responses are waited for by default
+	discoveryTimeout = time.Second
+)
+
+// A server found on the local network
+type target struct {
+	instance    string // the name it's advertised with, its hostname
+	host        string // eg. raspberrypi.local.
+	port        int
+	addrs       []net.IP
+	fingerprint string
+	udp         bool // whether it takes clients over the datagram transport
+}
+
+func (t *target) addr() string {
+	return net.JoinHostPort(t.addrs[0].String(), strconv.Itoa(t.port))
+}
+
+// Tells if selector picks the server: it's its name, its host or at least the
+// first 4 characters of its fingerprint
+func (t *target) matches(selector string) bool {
+	host := strings.TrimSuffix(t.host, ".")
+	for _, name := range []string{t.instance, host, strings.TrimSuffix(host, ".local")} {
+		if strings.EqualFold(strings.TrimSuffix(selector, "."), name) {
+			return true
+		}
+	}
+	return len(selector) >= 4 && t.fingerprint != "" && strings.HasPrefix(t.fingerprint, strings.ToLower(selector))
+}
+
+// Flags telling where to look for servers
+type discoveryFlags struct {
+	iface *string
+	group *string
+}
+
+func addDiscoveryFlags(fs *flag.FlagSet) *discoveryFlags {
+	return &discoveryFlags{
+		iface: fs.String("mdns-interface", "", "network interface to look for servers on, the system's default one if empty"),
+		group: fs.String("mdns-group", "224.0.0.251:5353", "multicast DNS group to ask"),
+	}
+}
+
+func (f *discoveryFlags) discover(timeout time.Duration) ([]*target, error) {
+	var ifi *net.Interface
+	if *f.iface != "" {
+		var err error
+		if ifi, err = net.InterfaceByName(*f.iface); err != nil {
+			return nil, err
+		}
+	}
+	group, err := net.ResolveUDPAddr("udp4", *f.group)
+	if err != nil {
+		return nil, err
+	}
+	return discover(ifi, group, timeout)
+}
+
+// Asks for servers on the multicast group through ifi (the system's default
+// interface if it's nil) and collects the responses for timeout. The query is
+// sent from a port of its own, so the servers respond straight to it.
+func discover(ifi *net.Interface, group *net.UDPAddr, timeout time.Duration) ([]*target, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if ifi != nil {
+		if err := setMulticastInterface(conn, ifi); err != nil {
+			return nil, err
+		}
+	}
+	query := &dnsmsg.Message{ID: uint16(rand.Uint32()), Questions: []dnsmsg.Question{{Name: mdnsService, Type: dnsmsg.TypePTR}}}
+	data, err := query.Encode()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.WriteToUDP(data, group); err != nil {
+		return nil, errors.New("couldn't ask for servers: " + err.Error())
+	}
+	deadline := time.Now().Add(timeout)
+	// the query is sent again halfway, in case it got lost
+	resendAt := time.Now().Add(timeout / 2)
+	records := make([]dnsmsg.Record, 0)
+	buf := make([]byte, 9000)
+	for time.Now().Before(deadline) {
+		wait := deadline
+		if !resendAt.IsZero() {
+			wait = resendAt
+		}
+		conn.SetReadDeadline(wait)
+		n, err := conn.Read(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			if !resendAt.IsZero() {
+				resendAt = time.Time{}
+				conn.WriteToUDP(data, group)
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		resp, err := dnsmsg.Parse(buf[:n])
+		if err != nil || !resp.Response || resp.ID != query.ID {
+			continue
+		}
+		records = append(append(records, resp.Answers...), resp.Additional...)
+	}
+	return collectTargets(records), nil
+}
+
+// makes multicast datagrams leave through ifi
+func setMulticastInterface(conn *net.UDPConn, ifi *net.Interface) error {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var setErr error
+	if err := rc.Control(func(fd uintptr) {
+		setErr = unix.SetsockoptIPMreqn(int(fd), unix.IPPROTO_IP, unix.IP_MULTICAST_IF, &unix.IPMreqn{Ifindex: int32(ifi.Index)})
+	}); err != nil {
+		return err
+	}
+	if setErr != nil {
+		return errors.New("couldn't send multicast through " + ifi.Name + ": " + setErr.Error())
+	}
+	return nil
+}
+
+// Puts the records from the responses together into servers, sorted by name.
+// The ones without a port or an address are left out.
+func collectTargets(records []dnsmsg.Record) []*target {
+	targets := make([]*target, 0)
+	for _, ptr := range records {
+		if ptr.Type != dnsmsg.TypePTR || !strings.EqualFold(ptr.Name, mdnsService) || ptr.TTL == 0 {
+			continue
+		}
+		name := ptr.Target
+		instance, ok := strings.CutSuffix(strings.ToLower(name), "."+mdnsService)
+		if !ok || slices.ContainsFunc(targets, func(t *target) bool { return strings.EqualFold(t.instance, instance) }) {
+			continue
+		}
+		t := &target{instance: name[:len(instance)]}
+		for _, r := range records {
+			if !strings.EqualFold(r.Name, name) {
+				continue
+			}
+			switch r.Type {
+			case dnsmsg.TypeSRV:
+				t.host, t.port = r.Target, int(r.Port)
+			case dnsmsg.TypeTXT:
+				for _, s := range r.TXT {
+					key, value, _ := strings.Cut(s, "=")
+					switch key {
+					case "fingerprint":
+						t.fingerprint = strings.ToLower(value)
+					case "udp":
+						t.udp = value == "1"
+					}
+				}
+			}
+		}
+		for _, r := range records {
+			if r.Type == dnsmsg.TypeA && t.host != "" && strings.EqualFold(r.Name, t.host) && !slices.ContainsFunc(t.addrs, r.IP.Equal) {
+				t.addrs = append(t.addrs, r.IP)
+			}
+		}
+		if t.port != 0 && len(t.addrs) > 0 {
+			targets = append(targets, t)
+		}
+	}
+	slices.SortFunc(targets, func(a, b *target) int { return strings.Compare(a.instance, b.instance) })
+	return targets
+}
+
+// Picks the one server selector matches
+func selectTarget(targets []*target, selector string) (*target, error) {
+	matching := make([]*target, 0)
+	for _, t := range targets {
+		if t.matches(selector) {
+			matching = append(matching, t)
+		}
+	}
+	switch len(matching) {
+	case 0:
+		return nil, fmt.Errorf("no server matching %q found on the local network", selector)
+	case 1:
+		return matching[0], nil
+	}
+	names := make([]string, 0, len(matching))
+	for _, t := range matching {
+		names = append(names, t.instance+" ("+t.fingerprint+")")
+	}
+	return nil, fmt.Errorf("%q matches several servers: %s", selector, strings.Join(names, ", "))
+}
+
+// The discover command: lists the servers found on the local network
+func runDiscover(args []string) int {
+	fs := flag.NewFlagSet("discover", flag.ExitOnError)
+	timeout := fs.Duration("timeout", discoveryTimeout, "how long to wait for the servers to respond")
+	where := addDiscoveryFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s discover [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	targets, err := where.discover(*timeout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "couldn't look for servers: "+err.Error())
+		return 1
+	}
+	if len(targets) == 0 {
+		fmt.Fprintln(os.Stderr, "no servers found")
+		return 1
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tADDRESS\tFINGERPRINT\tUDP")
+	for _, t := range targets {
+		udp := "no"
+		if t.udp {
+			udp = "yes"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", t.instance, t.addr(), t.fingerprint, udp)
+	}
+	w.Flush()
+	return 0
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"virt-kbd/dnsmsg"
+)
+
+// records of a server as it advertises itself
+func advertised(instance string, ip net.IP, port uint16, txt ...string) []dnsmsg.Record {
+	name := instance + "." + mdnsService
+	return []dnsmsg.Record{
+		{Name: mdnsService, Type: dnsmsg.TypePTR, TTL: 10, Target: name},
+		{Name: name, Type: dnsmsg.TypeSRV, TTL: 10, Port: port, Target: instance + ".local."},
+		{Name: name, Type: dnsmsg.TypeTXT, TTL: 10, TXT: txt},
+		{Name: instance + ".local.", Type: dnsmsg.TypeA, TTL: 10, IP: ip},
+	}
+}
+
+func TestCollectTargets(t *testing.T) {
+	records := advertised("pi-kitchen", net.IPv4(192, 168, 1, 20), 3001, "v=3", "fingerprint=0123456789ABCDEF", "udp=1")
+	records = append(records, advertised("pi-desk", net.IPv4(192, 168, 1, 30), 3002, "fingerprint=0123aaaaaaaaaaaa")...)
+	// the same server responding twice
+	records = append(records, advertised("pi-desk", net.IPv4(192, 168, 1, 30), 3002, "fingerprint=0123aaaaaaaaaaaa")...)
+	// a server without an address
+	records = append(records, advertised("pi-broken", nil, 3001)[:3]...)
+	targets := collectTargets(records)
+	if len(targets) != 2 {
+		t.Fatalf("got %d servers, expected 2", len(targets))
+	}
+	desk, kitchen := targets[0], targets[1]
+	if desk.instance != "pi-desk" || desk.addr() != "192.168.1.30:3002" || desk.udp || len(desk.addrs) != 1 {
+		t.Errorf("unexpected server %+v", desk)
+	}
+	if kitchen.instance != "pi-kitchen" || kitchen.addr() != "192.168.1.20:3001" || !kitchen.udp || kitchen.fingerprint != "0123456789abcdef" {
+		t.Errorf("unexpected server %+v", kitchen)
+	}
+
+	for selector, expected := range map[string]string{
+		"pi-desk":           "pi-desk",
+		"PI-KITCHEN":        "pi-kitchen",
+		"pi-kitchen.local":  "pi-kitchen",
+		"pi-kitchen.local.": "pi-kitchen",
+		"01234567":          "pi-kitchen",
+	} {
+		target, err := selectTarget(targets, selector)
+		if err != nil || target.instance != expected {
+			t.Errorf("%q selected %v, %v, expected %s", selector, target, err, expected)
+		}
+	}
+	for _, selector := range []string{"0123", "pi", "012"} {
+		if _, err := selectTarget(targets, selector); err == nil {
+			t.Errorf("%q selected a server, expected it to be ambiguous or not to match", selector)
+		}
+	}
+	if _, err := selectTarget(targets, "0123"); err == nil || !strings.Contains(err.Error(), "several servers") {
+		t.Errorf("expected %q to match several servers, got %v", "0123", err)
+	}
+}
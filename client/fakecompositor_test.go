@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// A global interface advertised by the fake compositor
+type fakeGlobal struct {
+	iface   string
+	version uint32
+}
+
+// A request the fake compositor received from a client
+type fakeRequest struct {
+	objectId uint32
+	iface    string
+	name     string
+	body     []byte
+	fds      []int
+}
+
+// A fakeCompositor is a display server good enough to test the client with.
+//
+// It listens on a socket in a temporary XDG_RUNTIME_DIR, so DisplayConnect
+// connects to it. It advertises the configured globals, keeps track of the
+// objects the client creates and collects every request it receives, so
+// tests can wait for them and check their arguments. Events are sent only
+// when a test asks for them.
+type fakeCompositor struct {
+	t        *testing.T
+	ln       *net.UnixListener
+	conn     *net.UnixConn
+	globals  []fakeGlobal
+	mu       sync.Mutex
+	objects  map[uint32]string // objects created by the client, by their ids
+	requests chan fakeRequest
+	accepted chan struct{}
+}
+
+func newFakeCompositor(t *testing.T, globals ...fakeGlobal) *fakeCompositor {
+	t.Helper()
+	runtimeDir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+	t.Setenv("WAYLAND_DISPLAY", "wayland-test")
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: filepath.Join(runtimeDir, "wayland-test"), Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc := &fakeCompositor{
+		t:        t,
+		ln:       ln,
+		globals:  globals,
+		objects:  map[uint32]string{waylandDisplayObjectId: "wl_display"},
+		requests: make(chan fakeRequest, 256),
+		accepted: make(chan struct{}),
+	}
+	go fc.serve()
+	t.Cleanup(fc.close)
+	return fc
+}
+
+func (fc *fakeCompositor) close() {
+	fc.ln.Close()
+	select {
+	case <-fc.accepted:
+		fc.conn.Close()
+	default:
+	}
+}
+
+// accepts a single client and reads its requests until it disconnects
+func (fc *fakeCompositor) serve() {
+	conn, err := fc.ln.AcceptUnix()
+	if err != nil {
+		return
+	}
+	fc.conn = conn
+	close(fc.accepted)
+	defer close(fc.requests)
+	in := make([]byte, 0)
+	fds := make([]int, 0)
+	buf := make([]byte, 4096)
+	oob := make([]byte, syscall.CmsgSpace(waylandMaxFdsInMsg*4))
+	for {
+		n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+		if err != nil {
+			return
+		}
+		if oobn > 0 {
+			received, err := parseFds(oob[:oobn])
+			if err != nil {
+				fc.t.Errorf("fake compositor: %v", err)
+				return
+			}
+			fds = append(fds, received...)
+		}
+		in = append(in, buf[:n]...)
+		for len(in) >= int(waylandHeaderSize) {
+			header, err := getMsgHeader(in)
+			if err != nil {
+				fc.t.Errorf("fake compositor: %v", err)
+				return
+			}
+			if len(in) < int(header.msgSize) {
+				break
+			}
+			req, err := fc.handleRequest(header, in[waylandHeaderSize:header.msgSize], &fds)
+			if err != nil {
+				fc.t.Errorf("fake compositor: %v", err)
+				return
+			}
+			fc.requests <- req
+			in = in[header.msgSize:]
+		}
+	}
+}
+
+// decodes a request with the generated interface descriptions, registers the objects
+// it creates and answers wl_display.get_registry with the globals
+func (fc *fakeCompositor) handleRequest(header WaylandHeader, body []byte, fds *[]int) (fakeRequest, error) {
+	fc.mu.Lock()
+	iface, ok := fc.objects[header.objectId]
+	fc.mu.Unlock()
+	if !ok {
+		return fakeRequest{}, fmt.Errorf("request to an unknown object %d", header.objectId)
+	}
+	info, ok := waylandInterfaces[iface]
+	if !ok {
+		return fakeRequest{}, fmt.Errorf("request to %d, an object of %s which has no description", header.objectId, iface)
+	}
+	if int(header.opcode) >= len(info.requests) {
+		return fakeRequest{}, fmt.Errorf("unknown request %d of %s", header.opcode, iface)
+	}
+	msg := info.requests[header.opcode]
+	req := fakeRequest{objectId: header.objectId, iface: iface, name: msg.name, body: append([]byte(nil), body...)}
+	r := waylandReader{data: body}
+	for _, a := range msg.args {
+		switch a.typ {
+		case waylandArgString, waylandArgArray:
+			if _, err := r.bytes(); err != nil {
+				return req, err
+			}
+		case waylandArgFd:
+			if len(*fds) == 0 {
+				return req, fmt.Errorf("%s.%s came without a file descriptor", iface, msg.name)
+			}
+			req.fds = append(req.fds, (*fds)[0])
+			*fds = (*fds)[1:]
+		case waylandArgNewId:
+			newIface := a.iface
+			if newIface == "" {
+				name, err := r.string()
+				if err != nil {
+					return req, err
+				}
+				newIface = name
+				r.uint() // version
+				if _, ok := waylandInterfaces[newIface]; !ok {
+					return req, fmt.Errorf("%s.%s binds %s, an interface without a description", iface, msg.name, newIface)
+				}
+			}
+			id, err := r.uint()
+			if err != nil {
+				return req, err
+			}
+			fc.mu.Lock()
+			fc.objects[id] = newIface
+			fc.mu.Unlock()
+		default:
+			if _, err := r.uint(); err != nil {
+				return req, err
+			}
+		}
+	}
+	if iface == "wl_display" && msg.name == "get_registry" {
+		registry := binary.LittleEndian.Uint32(body)
+		for i, g := range fc.globals {
+			w := waylandWriter{}
+			w.putUint(uint32(i + 1))
+			w.putString(g.iface, false)
+			w.putUint(g.version)
+			fc.send(registry, waylandWlRegistryEventGlobal, w)
+		}
+	}
+	return req, nil
+}
+
+// sends an event to an object of the client
+func (fc *fakeCompositor) send(objectId uint32, opcode uint16, args waylandWriter) {
+	msg := appendHeader(nil, WaylandHeader{objectId: objectId, opcode: opcode, msgSize: uint16(int(waylandHeaderSize) + len(args.data))})
+	msg = append(msg, args.data...)
+	var oob []byte
+	if len(args.fds) > 0 {
+		oob = syscall.UnixRights(args.fds...)
+	}
+	if _, _, err := fc.conn.WriteMsgUnix(msg, oob, nil); err != nil {
+		fc.t.Errorf("fake compositor: sending an event: %v", err)
+	}
+}
+
+// returns an id of the most recently created object implementing iface
+func (fc *fakeCompositor) object(iface string) uint32 {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	var found uint32
+	for id, i := range fc.objects {
+		if i == iface && id > found {
+			found = id
+		}
+	}
+	if found == 0 {
+		fc.t.Fatalf("client hasn't created %s", iface)
+	}
+	return found
+}
+
+// waits for a request named iface.name, skipping all the other requests
+func (fc *fakeCompositor) expect(iface string, name string) fakeRequest {
+	fc.t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case req, ok := <-fc.requests:
+			if !ok {
+				fc.t.Fatalf("client disconnected while waiting for %s.%s", iface, name)
+			}
+			if req.iface == iface && req.name == name {
+				return req
+			}
+		case <-timeout:
+			fc.t.Fatalf("timed out waiting for %s.%s", iface, name)
+		}
+	}
+}
+
+// collects all the requests that come until the client goes quiet for a while
+func (fc *fakeCompositor) drain() []fakeRequest {
+	reqs := make([]fakeRequest, 0)
+	for {
+		select {
+		case req, ok := <-fc.requests:
+			if !ok {
+				return reqs
+			}
+			reqs = append(reqs, req)
+		case <-time.After(200 * time.Millisecond):
+			return reqs
+		}
+	}
+}
+
+func (fc *fakeCompositor) sendConfigure(serial uint32) {
+	w := waylandWriter{}
+	w.putUint(serial)
+	fc.send(fc.object("xdg_surface"), waylandXdgSurfaceEventConfigure, w)
+}
+
+func (fc *fakeCompositor) sendPing(serial uint32) {
+	w := waylandWriter{}
+	w.putUint(serial)
+	fc.send(fc.object("xdg_wm_base"), waylandXdgWmBaseEventPing, w)
+}
+
+func (fc *fakeCompositor) sendKeymap(fd int, size uint32) {
+	w := waylandWriter{}
+	w.putUint(waylandWlKeyboardKeymapFormatXkbV1)
+	w.putFd(fd)
+	w.putUint(size)
+	fc.send(fc.object("wl_keyboard"), waylandWlKeyboardEventKeymap, w)
+}
+
+func (fc *fakeCompositor) sendKey(key uint32, state uint32) {
+	w := waylandWriter{}
+	w.putUint(1)  // serial
+	w.putUint(42) // time
+	w.putUint(key)
+	w.putUint(state)
+	fc.send(fc.object("wl_keyboard"), waylandWlKeyboardEventKey, w)
+}
+
+func (fc *fakeCompositor) sendClose() {
+	fc.send(fc.object("xdg_toplevel"), waylandXdgToplevelEventClose, waylandWriter{})
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Returns the events of a short session: the ones built by builtSessionEvents,
+// followed by the ones the display server sent in the sessions captured in
+// testdata, in the order they came.
+func sessionEvents(f *testing.F) [][]byte {
+	f.Helper()
+	paths, err := filepath.Glob("testdata/*.trace")
+	if err != nil {
+		f.Fatal(err)
+	}
+	events := builtSessionEvents()
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		entries, err := ReadTrace(file)
+		file.Close()
+		if err != nil {
+			f.Fatal(path + ": " + err.Error())
+		}
+		for _, e := range entries {
+			if !e.outgoing {
+				events = append(events, e.msg)
+			}
+		}
+	}
+	return events
+}
+
+// Builds the events of a short session the way a display server sends them:
+// the globals, a configure, the seat, a few keys and a close. The ids are the
+// ones the client gives its objects. They're made up here, not captured, see
+// testdata/README.md.
+func builtSessionEvents() [][]byte {
+	event := func(objectId uint32, opcode uint16, args ...any) []byte {
+		w := waylandWriter{}
+		for _, arg := range args {
+			switch v := arg.(type) {
+			case uint32:
+				w.putUint(v)
+			case string:
+				w.putString(v, false)
+			case []byte:
+				w.putArray(v)
+			}
+		}
+		msg := appendHeader(nil, WaylandHeader{objectId: objectId, opcode: opcode, msgSize: uint16(int(waylandHeaderSize) + len(w.data))})
+		return append(msg, w.data...)
+	}
+	const (
+		registry   = 2
+		shm        = 4
+		seat       = 6
+		surface    = 8
+		xdgSurface = 9
+		toplevel   = 10
+		keyboard   = 11
+	)
+	events := make([][]byte, 0)
+	for i, global := range []fakeGlobal{{"wl_compositor", 6}, {"wl_shm", 1}, {"wl_output", 4}, {"xdg_wm_base", 6}, {"wl_seat", 9}} {
+		events = append(events, event(registry, waylandWlRegistryEventGlobal, uint32(i+1), global.iface, global.version))
+	}
+	events = append(events,
+		event(xdgSurface, waylandXdgSurfaceEventConfigure, uint32(1)),
+		event(shm, waylandWlShmEventFormat, uint32(0)),
+		event(seat, waylandWlSeatEventCapabilities, uint32(3)),
+		event(keyboard, waylandWlKeyboardEventRepeatInfo, uint32(33), uint32(500)),
+		event(keyboard, waylandWlKeyboardEventEnter, uint32(2), uint32(surface), []byte{0x1e, 0, 0, 0}),
+		event(keyboard, waylandWlKeyboardEventModifiers, uint32(3), uint32(1), uint32(0), uint32(0), uint32(0)),
+		event(keyboard, waylandWlKeyboardEventKey, uint32(4), uint32(1000), uint32(42), uint32(1)),
+		event(keyboard, waylandWlKeyboardEventKey, uint32(5), uint32(1080), uint32(42), uint32(0)),
+		event(toplevel, waylandXdgToplevelEventClose),
+	)
+	return events
+}
+
+// seeds a fuzz target with the bodies of all the recorded events
+func addEventBodies(f *testing.F) {
+	for _, msg := range sessionEvents(f) {
+		f.Add(msg[waylandHeaderSize:])
+	}
+}
+
+func FuzzGetMsgHeader(f *testing.F) {
+	for _, msg := range sessionEvents(f) {
+		f.Add(msg)
+	}
+	f.Add([]byte{})
+	f.Add([]byte{1, 0, 0, 0, 0, 0, 4, 0})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		header, err := getMsgHeader(data)
+		if err != nil {
+			return
+		}
+		if len(data) < int(waylandHeaderSize) || uint32(header.msgSize) < waylandHeaderSize || header.msgSize%4 != 0 {
+			t.Errorf("accepted a header %+v of %d bytes", header, len(data))
+		}
+	})
+}
+
+func FuzzDecodeKeyEvent(f *testing.F) {
+	addEventBodies(f)
+	f.Fuzz(func(t *testing.T, body []byte) {
+		ke, err := DecodeKeyEvent(body)
+		if err == nil && len(body) < 16 {
+			t.Errorf("decoded %+v from %d bytes", ke, len(body))
+		}
+	})
+}
+
+func FuzzDecodeKeyboardModifiersEvent(f *testing.F) {
+	addEventBodies(f)
+	f.Fuzz(func(t *testing.T, body []byte) {
+		e, err := DecodeWlKeyboardModifiersEvent(body)
+		if err == nil && len(body) < 20 {
+			t.Errorf("decoded %+v from %d bytes", e, len(body))
+		}
+	})
+}
+
+func FuzzDecodeRegistryGlobal(f *testing.F) {
+	addEventBodies(f)
+	// an interface name longer than the message
+	f.Add([]byte{1, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 'w', 'l', 0, 0})
+	f.Fuzz(func(t *testing.T, body []byte) {
+		e, err := DecodeWlRegistryGlobalEvent(body)
+		if err != nil {
+			return
+		}
+		if len(e.interfaceArg) > len(body) || strings.ContainsRune(e.interfaceArg, 0) {
+			t.Errorf("decoded interface %q from %d bytes", e.interfaceArg, len(body))
+		}
+	})
+}
+
+// Feeds whatever comes as events to a connection set up the way the client sets
+// it up. The first part is dispatched right after the registry is created, the
+// second one after the client creates the objects it needs.
+func FuzzDispatch(f *testing.F) {
+	events := sessionEvents(f)
+	globals := make([]byte, 0)
+	rest := make([]byte, 0)
+	for _, msg := range events {
+		if header, _ := getMsgHeader(msg); header.objectId == 2 { // wl_registry
+			globals = append(globals, msg...)
+		} else {
+			rest = append(rest, msg...)
+		}
+	}
+	f.Add(globals, rest)
+	f.Add(globals, []byte{})
+	// the handlers log every malformed event
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	f.Fuzz(func(t *testing.T, globals []byte, rest []byte) {
+		conn := NewWaylandConn(-1)
+		conn.SetTrace(io.Discard)
+		data := make([]byte, 64)
+		state := &State{title: "virt-kbd", w: 4, h: 4, stride: 16, shmPoolSize: 64, shmPoolData: &data, shmFd: -1}
+		state.wlRegistry = conn.WlDisplayGetRegistry(waylandDisplayObjectId)
+		conn.SetHandler(state.wlRegistry, registryHandler(conn, state))
+		keyboardEvents := make(chan KeyEvent)
+		defer close(keyboardEvents)
+		go func() {
+			for range keyboardEvents {
+			}
+		}()
+		done := make(chan bool, 1)
+		for _, part := range [][]byte{globals, rest} {
+			conn.in = append(conn.in, part...)
+			if err := conn.dispatchBuffered(); err != nil {
+				return
+			}
+			setupObjects(conn, state, keyboardEvents, done)
+			conn.out = conn.out[:0]
+			conn.outFds = conn.outFds[:0]
+		}
+	})
+}
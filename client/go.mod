@@ -2,4 +2,12 @@ module remote-kbd-client
 
 go 1.23.6
 
-require golang.org/x/sys v0.30.0 // indirect
+require (
+	golang.org/x/sys v0.30.0
+	virt-kbd/dgram v0.0.0
+	virt-kbd/dnsmsg v0.0.0
+)
+
+replace virt-kbd/dgram => ../dgram
+
+replace virt-kbd/dnsmsg => ../dnsmsg
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Reads key events from r and forwards them to targetConn the same way the keys
+// pressed in a window are forwarded, handling the frames the server sends meanwhile. It lets the client run without a display
+// server, eg. in tests or when the keys come from a script.
+//
+// Each line holds a scancode followed by "down" or "up", eg. "30 down". Empty lines
+// and lines starting with # are skipped. Returns once all the events from r are sent.
+func runHeadless(r io.Reader, targetConn *remoteConn) error {
+	targetConn.start()
+	done := make(chan bool, 1)
+	keyboardEvents := keyboardEventsForward(targetConn, done)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		ke, err := ParseKeyEvent(text)
+		if err != nil {
+			close(keyboardEvents)
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		select {
+		case keyboardEvents <- ke:
+		case <-done:
+			return errors.New("connection with the target machine broke")
+		}
+	}
+	close(keyboardEvents)
+	<-done
+	return scanner.Err()
+}
+
+// Parses a key event written as a scancode and "down" or "up", eg. "30 down"
+func ParseKeyEvent(s string) (KeyEvent, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return KeyEvent{}, errors.New("expected a scancode and a state, eg. \"30 down\"")
+	}
+	scanCode, err := strconv.ParseUint(fields[0], 10, 32)
+	if err != nil {
+		return KeyEvent{}, errors.New("invalid scancode " + fields[0])
+	}
+	switch fields[1] {
+	case "down":
+		return KeyEvent{scanCode: uint32(scanCode), state: true}, nil
+	case "up":
+		return KeyEvent{scanCode: uint32(scanCode), state: false}, nil
+	}
+	return KeyEvent{}, errors.New("invalid key state " + fields[1] + ", expected down or up")
+}
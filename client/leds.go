@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// LEDs of the target's keyboard, bit n set when the LED with code n from
+// linux/input-event-codes.h is lit
+type ledState byte
+
+const (
+	ledNumLock ledState = 1 << iota
+	ledCapsLock
+	ledScrollLock
+	ledCompose
+	ledKana
+)
+
+var ledNames = []string{"num lock", "caps lock", "scroll lock", "compose", "kana"}
+
+func (l ledState) String() string {
+	lit := make([]string, 0)
+	for i, name := range ledNames {
+		if l&(1<<i) != 0 {
+			lit = append(lit, name)
+		}
+	}
+	if len(lit) == 0 {
+		return "none"
+	}
+	return strings.Join(lit, ", ")
+}
+
+// The LEDs shown in the window. They're set by the goroutine reading from the
+// target machine and drawn by the one talking to the display server, which is
+// woken up through a pipe.
+type ledIndicator struct {
+	mu      sync.Mutex
+	leds    ledState
+	changed bool
+	stopped bool // whether wait returns errIndicatorStopped
+	closed  bool
+	r, w    int
+}
+
+var errIndicatorStopped = errors.New("the LED indicator was stopped")
+
+func newLedIndicator() (*ledIndicator, error) {
+	fds := make([]int, 2)
+	if err := unix.Pipe2(fds, unix.O_NONBLOCK|unix.O_CLOEXEC); err != nil {
+		return nil, errors.New("couldn't create a pipe: " + err.Error())
+	}
+	return &ledIndicator{r: fds[0], w: fds[1]}, nil
+}
+
+func (i *ledIndicator) set(leds ledState) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.closed {
+		return
+	}
+	i.leds = leds
+	i.changed = true
+	// the pipe being full means the other side has been woken up already
+	unix.Write(i.w, []byte{0})
+}
+
+// Returns the LEDs if they changed since the last call
+func (i *ledIndicator) take() (ledState, bool) {
+	buf := make([]byte, 64)
+	for {
+		if n, _ := unix.Read(i.r, buf); n < len(buf) {
+			break
+		}
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	changed := i.changed
+	i.changed = false
+	return i.leds, changed
+}
+
+// Blocks until there's something to read from fd or the LEDs change. Returns
+// true in the first case and errIndicatorStopped once stop was called.
+func (i *ledIndicator) wait(fd int) (bool, error) {
+	fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}, {Fd: int32(i.r), Events: unix.POLLIN}}
+	for {
+		if i.isStopped() {
+			return false, errIndicatorStopped
+		}
+		_, err := unix.Poll(fds, -1)
+		if errors.Is(err, unix.EINTR) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if i.isStopped() {
+			return false, errIndicatorStopped
+		}
+		return fds[0].Revents != 0, nil
+	}
+}
+
+// Wakes up whoever waits and makes wait return errIndicatorStopped from now on
+func (i *ledIndicator) stop() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.closed {
+		return
+	}
+	i.stopped = true
+	unix.Write(i.w, []byte{0})
+}
+
+func (i *ledIndicator) isStopped() bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.stopped
+}
+
+// Closes the pipe. The LEDs set afterwards are ignored. Whoever waits has to be
+// stopped first, the pipe is polled until then.
+func (i *ledIndicator) Close() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.closed = true
+	unix.Close(i.r)
+	unix.Close(i.w)
+}
+
+// LED event constants from linux/input-event-codes.h
+const (
+	evSyn     = 0x00
+	evLed     = 0x11
+	synReport = 0
+)
+
+// size of struct input_event: a timeval followed by type, code and value
+var inputEventSize = int(unsafe.Sizeof(unix.Timeval{})) + 8
+
+// A keyboard of the local machine whose LEDs mirror the ones of the target
+type ledDevice struct {
+	f *os.File
+}
+
+// Opens an evdev device, eg. /dev/input/by-path/...-event-kbd. Setting its LEDs
+// needs a write permission for it.
+func openLedDevice(path string) (*ledDevice, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, errors.New("couldn't open a keyboard for its LEDs: " + err.Error())
+	}
+	return &ledDevice{f: f}, nil
+}
+
+// Sets all the LEDs at once, followed by a report
+func (d *ledDevice) set(leds ledState) error {
+	events := make([]byte, (len(ledNames)+1)*inputEventSize)
+	for i := range ledNames {
+		putInputEvent(events[i*inputEventSize:], evLed, uint16(i), int32(leds>>i&1))
+	}
+	putInputEvent(events[len(ledNames)*inputEventSize:], evSyn, synReport, 0)
+	_, err := d.f.Write(events)
+	return err
+}
+
+func (d *ledDevice) Close() error {
+	return d.f.Close()
+}
+
+// encodes struct input_event, leaving the time to the kernel
+func putInputEvent(b []byte, typ uint16, code uint16, value int32) {
+	off := inputEventSize - 8
+	binary.NativeEndian.PutUint16(b[off:], typ)
+	binary.NativeEndian.PutUint16(b[off+2:], code)
+	binary.NativeEndian.PutUint32(b[off+4:], uint32(value))
+}
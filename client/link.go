@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+)
+
+// The target the keys pressed in the window go to. Forwarding can be paused
+// and the connection dropped or switched to another target while the window
+// stays open, eg. through the control API.
+type targetLink struct {
+	mu       sync.Mutex
+	remote   *remoteConn // nil while disconnected
+	network  string
+	addr     string
+	paused   bool
+	held     map[uint32]bool  // keys of the window sent to the target and not released yet
+	control  bool             // whether the client has control over the target
+	leds     ledState         // the LEDs of the target
+	recorder *sessionRecorder // passed on to the connections, may be nil
+	// called when the LEDs or the target change, so the window can show them
+	changed func(leds ledState)
+	// called when the server closes the connection, rather than the client
+	lost    func()
+	wasLost bool // whether the connection was lost before lost was set
+}
+
+// Sets what's called when the LEDs or the target change and when the server
+// closes the connection. They're called right away if that happened already.
+func (l *targetLink) watch(changed func(leds ledState), lost func()) {
+	l.mu.Lock()
+	l.changed, l.lost = changed, lost
+	leds, wasLost := l.leds, l.wasLost
+	l.mu.Unlock()
+	changed(leds)
+	if wasLost {
+		lost()
+	}
+}
+
+func (l *targetLink) notify(leds ledState) {
+	l.mu.Lock()
+	changed := l.changed
+	l.mu.Unlock()
+	if changed != nil {
+		changed(leds)
+	}
+}
+
+// The state of the link, as the control API reports it
+type linkStatus struct {
+	Connected bool   `json:"connected"`
+	Network   string `json:"network,omitempty"`
+	Addr      string `json:"addr,omitempty"`
+	Paused    bool   `json:"paused"`
+	Control   bool   `json:"control"` // whether the client has control over the target
+	Leds      string `json:"leds"`
+}
+
+func (l *targetLink) status() linkStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := linkStatus{Connected: l.remote != nil, Paused: l.paused, Control: l.control, Leds: l.leds.String()}
+	if l.remote != nil {
+		s.Network, s.Addr = l.network, l.addr
+	}
+	return s
+}
+
+// The title of the window
+func (l *targetLink) title() string {
+	s := l.status()
+	switch {
+	case !s.Connected:
+		return "virt-kbd: disconnected"
+	case s.Paused:
+		return truncateTitle("virt-kbd: " + s.Addr + " (paused)")
+	}
+	return truncateTitle("virt-kbd: " + s.Addr)
+}
+
+var errAlreadyConnected = errors.New("already connected, switch connects to another target")
+
+// Makes remote the target, starting to read from it. The connection to the
+// previous target, if there was one, is shut down; unless replace is set,
+// errAlreadyConnected is returned instead and remote is left alone.
+func (l *targetLink) attach(remote *remoteConn, network string, addr string, replace bool) error {
+	remote.recorder = l.recorder
+	remote.onLeds(func(leds ledState) {
+		if l.update(remote, func() { l.leds = leds }) {
+			l.notify(leds)
+		}
+	})
+	remote.onControl(func(control bool) {
+		remote.logControl(control)
+		l.update(remote, func() { l.control = control })
+	})
+	l.mu.Lock()
+	previous := l.remote
+	if previous != nil && !replace {
+		l.mu.Unlock()
+		return errAlreadyConnected
+	}
+	l.remote, l.network, l.addr = remote, network, addr
+	l.control, l.leds, l.held = false, 0, nil
+	l.mu.Unlock()
+	l.notify(0)
+	remote.start()
+	go func() {
+		<-remote.done
+		var lost func()
+		if l.update(remote, func() { l.remote, l.leds, l.held, lost, l.wasLost = nil, 0, nil, l.lost, l.lost == nil }) {
+			remote.log.Info("the target machine closed the connection")
+			l.notify(0)
+			if lost != nil {
+				lost()
+			}
+		}
+	}()
+	if previous != nil {
+		previous.shutdown(shutdownTimeout)
+	}
+	return nil
+}
+
+// Calls fn with the lock held if remote is still the target. Returns whether it was.
+func (l *targetLink) update(remote *remoteConn, fn func()) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.remote != remote {
+		return false
+	}
+	fn()
+	return true
+}
+
+// Shuts the connection to the target down
+func (l *targetLink) detach() error {
+	l.mu.Lock()
+	remote := l.remote
+	l.remote, l.control, l.leds, l.held = nil, false, 0, nil
+	l.mu.Unlock()
+	if remote == nil {
+		return errors.New("not connected")
+	}
+	l.notify(0)
+	return remote.shutdown(shutdownTimeout)
+}
+
+func (l *targetLink) setPaused(paused bool) {
+	l.mu.Lock()
+	l.paused = paused
+	leds := l.leds
+	l.mu.Unlock()
+	l.notify(leds)
+}
+
+// Sends a key pressed in the window to the target. Keys are dropped while
+// forwarding is paused or there's no target, except for the releases of keys
+// pressed before forwarding was paused, so they aren't left held on the target.
+func (l *targetLink) sendKey(ke KeyEvent) error {
+	l.mu.Lock()
+	remote, paused := l.remote, l.paused
+	if remote == nil || paused && (ke.state || !l.held[ke.scanCode]) {
+		l.mu.Unlock()
+		slog.Debug("dropping a key", keyAttr(ke.scanCode), "paused", paused)
+		return nil
+	}
+	if l.held == nil {
+		l.held = make(map[uint32]bool)
+	}
+	if ke.state {
+		l.held[ke.scanCode] = true
+	} else {
+		delete(l.held, ke.scanCode)
+	}
+	l.mu.Unlock()
+	return remote.sendKey(ke)
+}
+
+// Sends keys to the target whether forwarding is paused or not
+func (l *targetLink) sendKeys(events []KeyEvent) error {
+	l.mu.Lock()
+	remote := l.remote
+	l.mu.Unlock()
+	if remote == nil {
+		return errors.New("not connected")
+	}
+	for _, ke := range events {
+		if err := remote.sendKey(ke); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// whether scancodes are logged rather than redacted
+var logKeys atomic.Bool
+
+// A key in the logs, redacted unless logKeys is set
+type loggedKey uint32
+
+func (k loggedKey) LogValue() slog.Value {
+	if !logKeys.Load() {
+		return slog.StringValue("redacted")
+	}
+	return slog.Uint64Value(uint64(k))
+}
+
+// the attribute a key is logged with
+func keyAttr(scanCode uint32) slog.Attr {
+	return slog.Any("key", loggedKey(scanCode))
+}
+
+func errAttr(err error) slog.Attr {
+	return slog.String("error", err.Error())
+}
+
+// Flags telling how the client logs
+type logFlags struct {
+	level  *string
+	format *string
+	keys   *bool
+}
+
+func addLogFlags(fs *flag.FlagSet) *logFlags {
+	return &logFlags{
+		level:  fs.String("log-level", "info", "debug, info, warn or error (DEBUG=1 is the same as debug)"),
+		format: fs.String("log-format", "text", "text or json"),
+		keys:   fs.Bool("log-keys", false, "log the scancodes of the keys sent, for debugging. Keys can make up passwords, so they're redacted otherwise"),
+	}
+}
+
+// Returns a handler writing to w as the flags say
+func (f *logFlags) handler(w io.Writer) (slog.Handler, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*f.level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q, expected debug, info, warn or error", *f.level)
+	}
+	if os.Getenv("DEBUG") == "1" {
+		level = slog.LevelDebug
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	switch strings.ToLower(*f.format) {
+	case "text":
+		return slog.NewTextHandler(w, opts), nil
+	case "json":
+		return slog.NewJSONHandler(w, opts), nil
+	}
+	return nil, fmt.Errorf("invalid log format %q, expected text or json", *f.format)
+}
+
+// Makes the default logger log to w as the flags say
+func (f *logFlags) setup(w io.Writer) error {
+	handler, err := f.handler(w)
+	if err != nil {
+		return err
+	}
+	logKeys.Store(*f.keys)
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
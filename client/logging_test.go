@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// logs to a buffer as args say for the rest of the test
+func captureLogs(t *testing.T, args ...string) *bytes.Buffer {
+	t.Helper()
+	t.Setenv("DEBUG", "")
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	logging := addLogFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	previous := slog.Default()
+	var buf bytes.Buffer
+	if err := logging.setup(&buf); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		slog.SetDefault(previous)
+		logKeys.Store(false)
+	})
+	return &buf
+}
+
+func TestLoggingKeys(t *testing.T) {
+	for _, logKeys := range []bool{false, true} {
+		args := []string{"-log-level", "debug", "-log-format", "json"}
+		if logKeys {
+			args = append(args, "-log-keys")
+		}
+		buf := captureLogs(t, args...)
+		remote, _ := connectRemote(t)
+		if err := runHeadless(strings.NewReader("30 down\n"), remote); err != nil {
+			t.Fatal(err)
+		}
+		var record map[string]any
+		if err := json.Unmarshal([]byte(strings.Split(buf.String(), "\n")[0]), &record); err != nil {
+			t.Fatalf("invalid json log %q: %v", buf.String(), err)
+		}
+		var expectedKey any = "redacted"
+		if logKeys {
+			expectedKey = 30.0
+		}
+		if record["msg"] != "sending a key" || record["key"] != expectedKey || record["remote"] != remote.RemoteAddr().String() {
+			t.Errorf("unexpected record %v, expected the key to be %v", record, expectedKey)
+		}
+	}
+}
+
+func TestLogFlags(t *testing.T) {
+	for _, args := range [][]string{{"-log-level", "verbose"}, {"-log-format", "xml"}} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		logging := addLogFlags(fs)
+		fs.Parse(args)
+		if _, err := logging.handler(&bytes.Buffer{}); err == nil {
+			t.Errorf("%v is accepted", args)
+		}
+	}
+	buf := captureLogs(t)
+	slog.Debug("not logged")
+	slog.Info("logged", keyAttr(30))
+	if out := buf.String(); strings.Contains(out, "not logged") || !strings.Contains(out, "msg=logged key=redacted") {
+		t.Errorf("unexpected logs %q", out)
+	}
+}
@@ -1,160 +1,252 @@
 package main
 
 import (
+	"encoding/binary"
 	"errors"
+	"flag"
 	"fmt"
 	"log/slog"
 	"math/rand/v2"
 	"net"
 	"os"
 	"syscall"
+	"time"
 
 	"golang.org/x/sys/unix"
 )
 
+// Something key events are sent to: a connection to a target machine or the
+// target the window forwards to
+type keySender interface {
+	sendKey(ke KeyEvent) error
+}
+
 // a function that gets keyboard events from keyboardEventsChan and forwards these
 // events to a remote machine.
 //
 // returns a channel the events are supposed to be sent to
-func keyboardEventsForward(targetConn *net.TCPConn, done chan bool) chan []byte {
-	keyboardEventsChan := make(chan []byte, 0)
+func keyboardEventsForward(target keySender, done chan bool) chan KeyEvent {
+	keyboardEventsChan := make(chan KeyEvent, 0)
 	go func() {
-		for event := range keyboardEventsChan {
-			slog.Debug(fmt.Sprintf("received data: %v", event))
-			header := getMsgHeader(event)
-			if header.opcode == waylandWlKeyboardKeyEventOpcode {
-				ke, err := DecodeKeyEvent(event[8:])
-				if err != nil {
-					slog.Error("while decoding keyboard data: " + err.Error())
-					continue
-				}
-				keyMsg := make([]byte, 2)
-				keyMsg[0] = byte(ke.scanCode)
-				if ke.state {
-					keyMsg[1] = byte(1)
-				} else {
-					keyMsg[1] = byte(0)
-				}
-				slog.Info(fmt.Sprintf("sending %v", keyMsg))
-				_, err = targetConn.Write(keyMsg)
-				if err != nil {
-					slog.Error(err.Error())
-					if errors.Is(err, syscall.EPIPE) {
-						done <- true
-					}
-				}
+		for ke := range keyboardEventsChan {
+			if err := target.sendKey(ke); errors.Is(err, syscall.EPIPE) {
+				stop(done)
 			}
 		}
+		// nothing more to send
+		stop(done)
 	}()
 	return keyboardEventsChan
 }
 
-// Reads all the data coming from a displays server socket
-func receiveFromWayland(fd int, state *State, keyboardEvents chan []byte, done chan bool) {
+// Reads all the data coming from a displays server socket and sends
+// the requests queued while handling it. The LEDs are redrawn whenever
+// the indicator tells they changed.
+func receiveFromWayland(conn *WaylandConn, state *State, indicator *ledIndicator, link *targetLink, keyboardEvents chan KeyEvent, done chan bool) {
 	for {
-		waylandData := make([]byte, 4096)
-		n, err := syscall.Read(fd, waylandData)
+		readable, err := indicator.wait(conn.fd)
+		if errors.Is(err, errIndicatorStopped) {
+			return
+		}
 		if err != nil {
-			slog.Error("while reading from a socket: " + err.Error())
-			if errors.Is(err, syscall.EPIPE) {
-				done <- true
+			slog.Error("while waiting for a display server", errAttr(err))
+			stop(done)
+			return
+		}
+		if leds, changed := indicator.take(); changed {
+			state.leds = leds
+			if state.stateState == stateSurfaceAttached {
+				redrawLeds(conn, state)
 			}
-			if n == 0 {
-				continue
+			// the target changes along with its LEDs
+			if title := link.title(); title != state.title {
+				state.title = title
+				if state.xdgToplevel != 0 {
+					conn.XdgToplevelSetTitle(state.xdgToplevel, title)
+				}
+			}
+		}
+		if !readable {
+			if err := conn.Flush(); err != nil {
+				slog.Error("while writing to a display server", errAttr(err))
+				stop(done)
+				return
+			}
+			continue
+		}
+		if err := conn.Dispatch(); err != nil {
+			var protoErr *WaylandProtocolError
+			if errors.As(err, &protoErr) {
+				slog.Error(protoErr.Error())
+			} else {
+				slog.Error("while reading from a display server", errAttr(err))
 			}
+			slog.Debug("the display server connection broke", "state", fmt.Sprintf("%+v", *state))
+			stop(done)
+			return
+		}
+		setupObjects(conn, state, keyboardEvents, done)
+		if err := conn.Flush(); err != nil {
+			slog.Error("while writing to a display server", errAttr(err))
+			stop(done)
+			return
 		}
-		handleWaylandData(fd, state, keyboardEvents, waylandData, done)
 	}
 }
 
-// Processes the data from a display server.
+// Creates the objects that can be created with the interfaces bound so far.
 //
-// Responsible for: binding to interfaces, sending a value to a done channel signaling that the application
-// should stop, setting up surfaces, answering to pong messages from a display server, sending keyboard events
-// through keyboardEvents channel.
-func handleWaylandData(fd int, state *State, keyboardEvents chan []byte, data []byte, done chan bool) {
-	for len(data) > 0 {
-		header := getMsgHeader(data)
-		if header.msgSize == 0 {
-			break
-		}
-		if header.objectId == state.wlRegistry && header.opcode == waylandWlRegistryEventGlobal {
-			bindInterface(fd, state, data)
-		} else if header.objectId == waylandDisplayObjectId && header.opcode == waylandWlDisplayErrorEvent {
-			slog.Error("display server sent an error. closing")
-			slog.Debug(fmt.Sprintf("state: %+v\n", state))
-			done <- true
-		} else if header.objectId == state.xdgWmBase && header.opcode == waylandXdgWmBaseEventPing {
-			SendWmBasePong(data, fd, state)
-		} else if header.objectId == state.xdgSurface && header.opcode == waylandXdgSurfaceEventConfigure {
-			SendSurfaceAckConfigure(data, fd, state)
-		} else if header.objectId == state.wlKeyboard {
-			keyboardEvents <- data[:header.msgSize]
-		} else if header.objectId == state.xdgToplevel && header.opcode == waylandXdgToplevelEventClose {
-			slog.Info("top level event close received. exiting")
-			done <- true
-		}
-		data = data[header.msgSize:]
-	}
+// Responsible for: setting up surfaces, getting a keyboard object whose events are sent
+// through keyboardEvents channel, inhibiting global shortcuts.
+func setupObjects(conn *WaylandConn, state *State, keyboardEvents chan KeyEvent, done chan bool) {
 	if state.wlCompositor != 0 && state.wlShm != 0 && state.xdgWmBase != 0 && state.wlSurface == 0 {
-		wlSurfaceSetup(fd, state)
+		wlSurfaceSetup(conn, state, done)
+	}
+	// a decoration object has to be created before a buffer is attached to the surface,
+	// otherwise the display server treats it as a protocol error
+	if state.zxdgDecorationMngr != 0 && state.xdgToplevel != 0 && state.zxdgToplevelDecoration == 0 && state.stateState == stateNone {
+		toplevelDecorationSetup(conn, state)
 	}
 	if state.wlSeat != 0 && state.wlKeyboard == 0 {
-		state.wlKeyboard = CreateKeyboard(fd, state)
+		state.wlKeyboard = conn.WlSeatGetKeyboard(state.wlSeat)
+		conn.SetHandler(state.wlKeyboard, keyboardHandler(conn, keyboardEvents))
 	}
-	if state.wlSeat != 0 && state.zwpShortcutsInhibitor == 0 {
-		state.zwpShortcutsInhibitor = InhibitGlobalShortcuts(fd, state)
+	if state.wlSeat != 0 && state.zwpShortcutsInhibitMngr != 0 && state.wlSurface != 0 && state.zwpShortcutsInhibitor == 0 {
+		state.zwpShortcutsInhibitor = conn.ZwpKeyboardShortcutsInhibitManagerV1InhibitShortcuts(state.zwpShortcutsInhibitMngr, state.wlSurface, state.wlSeat)
 	}
 	if state.stateState == stateSurfaceAckedConfigure {
-		configureSurface(fd, state)
+		configureSurface(conn, state)
+	}
+}
+
+func registryHandler(conn *WaylandConn, state *State) EventHandler {
+	return func(opcode uint16, body []byte) {
+		if opcode == waylandWlRegistryEventGlobal {
+			e, err := DecodeWlRegistryGlobalEvent(body)
+			if err != nil {
+				slog.Error(err.Error())
+				return
+			}
+			bindInterface(conn, state, e)
+		}
 	}
 }
 
-func bindInterface(fd int, state *State, data []byte) {
-	waylandIface := getMsgInterface(data)
-	var err error
-	switch string(waylandIface.iface[:waylandIface.len-1]) { // interface name given by wayland includes a string terminator
+func bindInterface(conn *WaylandConn, state *State, global WlRegistryGlobalEvent) {
+	switch global.interfaceArg {
 	case "wl_compositor":
-		state.wlCompositor, err = RegistryBind(fd, state.wlRegistry, waylandIface)
+		state.wlCompositor = registryBind(conn, state, global)
 	case "wl_shm":
-		state.wlShm, err = RegistryBind(fd, state.wlRegistry, waylandIface)
+		state.wlShm = registryBind(conn, state, global)
 	case "xdg_wm_base":
-		state.xdgWmBase, err = RegistryBind(fd, state.wlRegistry, waylandIface)
+		state.xdgWmBase = registryBind(conn, state, global)
+		conn.SetHandler(state.xdgWmBase, func(opcode uint16, body []byte) {
+			if opcode != waylandXdgWmBaseEventPing {
+				return
+			}
+			if e, err := DecodeXdgWmBasePingEvent(body); err == nil {
+				conn.XdgWmBasePong(state.xdgWmBase, e.serial)
+			}
+		})
 	case "wl_seat":
-		state.wlSeat, err = RegistryBind(fd, state.wlRegistry, waylandIface)
+		state.wlSeat = registryBind(conn, state, global)
 	case "zwp_keyboard_shortcuts_inhibit_manager_v1":
-		state.zwpShortcutsInhibitMngr, err = RegistryBind(fd, state.wlRegistry, waylandIface)
+		state.zwpShortcutsInhibitMngr = registryBind(conn, state, global)
+	case "zxdg_decoration_manager_v1":
+		state.zxdgDecorationMngr = registryBind(conn, state, global)
 	}
-	if err != nil {
-		slog.Error(err.Error())
+}
+
+// binds to a global interface. The version is capped at the one from the protocol
+// files the bindings were generated from, newer versions may come with events or
+// requests this client doesn't know about.
+func registryBind(conn *WaylandConn, state *State, global WlRegistryGlobalEvent) uint32 {
+	version := global.version
+	if info, ok := waylandInterfaces[global.interfaceArg]; ok && info.version < version {
+		version = info.version
 	}
+	return conn.WlRegistryBind(state.wlRegistry, global.name, global.interfaceArg, version)
 }
 
-func wlSurfaceSetup(fd int, state *State) {
+// decodes key events and passes them to keyboardEvents channel
+func keyboardHandler(conn *WaylandConn, keyboardEvents chan KeyEvent) EventHandler {
+	return func(opcode uint16, body []byte) {
+		switch opcode {
+		case waylandWlKeyboardEventKeymap:
+			// the keymap isn't used, the keys are sent as they are
+			if e, err := DecodeWlKeyboardKeymapEvent(body, conn); err == nil {
+				syscall.Close(e.fd)
+			}
+		case waylandWlKeyboardEventKey:
+			ke, err := DecodeKeyEvent(body)
+			if err != nil {
+				slog.Error("while decoding keyboard data", errAttr(err))
+				return
+			}
+			slog.Debug("received a key", keyAttr(ke.scanCode), "down", ke.state)
+			keyboardEvents <- ke
+		}
+	}
+}
+
+func wlSurfaceSetup(conn *WaylandConn, state *State, done chan bool) {
 	slog.Debug("setting up wl_surface")
-	state.wlSurface = CreateSurface(fd, state)
-	state.xdgSurface = GetXdgSurface(fd, state)
-	state.xdgToplevel = GetXdgSurfaceTopLevel(fd, state)
-	SurfaceCommit(fd, state)
+	state.wlSurface = conn.WlCompositorCreateSurface(state.wlCompositor)
+	state.xdgSurface = conn.XdgWmBaseGetXdgSurface(state.xdgWmBase, state.wlSurface)
+	conn.SetHandler(state.xdgSurface, func(opcode uint16, body []byte) {
+		if opcode != waylandXdgSurfaceEventConfigure {
+			return
+		}
+		if e, err := DecodeXdgSurfaceConfigureEvent(body); err == nil {
+			conn.XdgSurfaceAckConfigure(state.xdgSurface, e.serial)
+			state.stateState = stateSurfaceAckedConfigure
+		}
+	})
+	state.xdgToplevel = conn.XdgSurfaceGetToplevel(state.xdgSurface)
+	conn.SetHandler(state.xdgToplevel, func(opcode uint16, body []byte) {
+		if opcode == waylandXdgToplevelEventClose {
+			slog.Info("top level event close received. exiting")
+			stop(done)
+		}
+	})
+	conn.XdgToplevelSetTitle(state.xdgToplevel, state.title)
+	conn.XdgToplevelSetAppId(state.xdgToplevel, waylandAppId)
+	if state.zxdgDecorationMngr != 0 {
+		toplevelDecorationSetup(conn, state)
+	}
+	conn.WlSurfaceCommit(state.wlSurface)
 }
 
-func configureSurface(fd int, state *State) {
-	slog.Debug("configuring surface")
-	if state.wlShmPool == 0 {
-		nextId, err := CreateShmPool(fd, state)
-		if err != nil {
+// display servers that support zxdg_decoration_manager_v1 draw a title bar and borders
+// for the window, which makes it possible to move it around. Others (like gnome) don't
+// advertise the interface and the window stays undecorated.
+func toplevelDecorationSetup(conn *WaylandConn, state *State) {
+	slog.Debug("setting up top level decoration")
+	state.zxdgToplevelDecoration = conn.ZxdgDecorationManagerV1GetToplevelDecoration(state.zxdgDecorationMngr, state.xdgToplevel)
+	conn.SetHandler(state.zxdgToplevelDecoration, func(opcode uint16, body []byte) {
+		if opcode != waylandZxdgToplevelDecorationV1EventConfigure {
 			return
 		}
-		state.wlShmPool = nextId
+		if e, err := DecodeZxdgToplevelDecorationV1ConfigureEvent(body); err == nil {
+			slog.Debug("decoration mode configured", "mode", e.mode)
+		}
+	})
+	conn.ZxdgToplevelDecorationV1SetMode(state.zxdgToplevelDecoration, waylandZxdgToplevelDecorationV1ModeServerSide)
+}
+
+func configureSurface(conn *WaylandConn, state *State) {
+	slog.Debug("configuring surface")
+	if state.wlShmPool == 0 {
+		state.wlShmPool = conn.WlShmCreatePool(state.wlShm, state.shmFd, int32(state.shmPoolSize))
 	}
 	if state.wlBuffer == 0 {
-		state.wlBuffer = CreateShmPoolBuffer(fd, state)
+		state.wlBuffer = conn.WlShmPoolCreateBuffer(state.wlShmPool, 0, int32(state.w), int32(state.h), int32(state.stride), waylandWlShmFormatXrgb8888)
 	}
-	render(fd, state)
+	render(conn, state)
 	state.stateState = stateSurfaceAttached
 }
 
-func render(fd int, state *State) {
+func render(conn *WaylandConn, state *State) {
 	start := rand.IntN(int(state.shmPoolSize))
 	for j := 0; j < start; j++ {
 		(*state.shmPoolData)[j] = byte(0)
@@ -162,15 +254,56 @@ func render(fd int, state *State) {
 	for i := start; i < int(state.shmPoolSize); i++ {
 		(*state.shmPoolData)[i] = byte(rand.IntN(100))
 	}
-	SurfaceAttach(fd, state)
-	SurfaceCommit(fd, state)
+	drawLeds(state)
+	conn.WlSurfaceAttach(state.wlSurface, state.wlBuffer, 0, 0)
+	conn.WlSurfaceCommit(state.wlSurface)
+}
+
+// where the LEDs are drawn: num lock, caps lock and scroll lock next to each
+// other in the top left corner
+const (
+	ledMargin = 10
+	ledWidth  = 40
+	ledHeight = 16
+	ledShown  = 3
+)
+
+// colors of the LEDs in XRGB8888
+const (
+	ledColorOn  uint32 = 0x0000ff00
+	ledColorOff uint32 = 0x00303030
+)
+
+func drawLeds(state *State) {
+	data := *state.shmPoolData
+	for i := range ledShown {
+		color := ledColorOff
+		if state.leds&(1<<i) != 0 {
+			color = ledColorOn
+		}
+		x0 := uint32(ledMargin + i*(ledWidth+ledMargin))
+		for y := uint32(ledMargin); y < ledMargin+ledHeight && y < state.h; y++ {
+			for x := x0; x < x0+ledWidth && x < state.w; x++ {
+				binary.LittleEndian.PutUint32(data[y*state.stride+x*colorChannels:], color)
+			}
+		}
+	}
+}
+
+// draws the LEDs over the content of the window and tells the display server
+// about the part that changed
+func redrawLeds(conn *WaylandConn, state *State) {
+	drawLeds(state)
+	conn.WlSurfaceAttach(state.wlSurface, state.wlBuffer, 0, 0)
+	conn.WlSurfaceDamage(state.wlSurface, 0, 0, ledMargin+ledShown*(ledWidth+ledMargin), ledMargin+ledHeight)
+	conn.WlSurfaceCommit(state.wlSurface)
 }
 
-func createState(currentId uint32) *State {
+func createState(title string) *State {
 	state := State{
-		wlRegistry: currentId,
-		w:          700,
-		h:          700,
+		title: title,
+		w:     700,
+		h:     700,
 	}
 	state.stride = state.w * colorChannels
 	state.shmPoolSize = state.h * state.stride
@@ -190,42 +323,241 @@ func createState(currentId uint32) *State {
 	return &state
 }
 
-func connectToRemote() (*net.TCPConn, error) {
-	connType := "tcp"
-	host := os.Args[1]
-	port := os.Args[2]
-	serv := fmt.Sprintf("%s:%s", host, port)
-	tcpServer, err := net.ResolveTCPAddr(connType, serv)
-	if err != nil {
+// Connects to the server on a target machine, introducing the client as name.
+// network is tcp or udp, with addr made of a host and a port, or unix, with addr
+// being the path of a socket.
+func connectToRemote(network string, addr string, name string) (*remoteConn, error) {
+	var conn streamConn
+	if network == "udp" {
+		c, err := dialDatagram(addr)
+		if err != nil {
+			return nil, err
+		}
+		conn = c
+	} else {
+		c, err := net.Dial(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		conn = c.(streamConn)
+	}
+	if err := writeHello(conn, name); err != nil {
+		conn.Close()
 		return nil, err
 	}
-	return net.DialTCP(connType, nil, tcpServer)
+	return newRemoteConn(conn), nil
 }
 
 func main() {
-	if len(os.Args) != 3 {
-		fmt.Println("provide target machine ip and port, eg. 192.168.124.3 3001")
+	if len(os.Args) > 1 && os.Args[1] == "discover" {
+		os.Exit(runDiscover(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "ctl" {
+		os.Exit(runCtl(os.Args[2:]))
+	}
+	trace := flag.Bool("trace", false, "print all the wayland requests and events (the same as WAYLAND_DEBUG=1)")
+	traceFile := flag.String("trace-file", "", "write the wayland trace to a file instead of stderr")
+	name := flag.String("name", "", "name the client introduces itself with to the server (the hostname by default)")
+	headless := flag.Bool("headless", false, "don't open a window, read key events from stdin instead (one per line, eg. \"30 down\")")
+	unixPath := flag.String("unix", "", "connect to the server's unix socket at this path instead of a host and a port, eg. one forwarded with ssh -L")
+	udp := flag.Bool("udp", false, "connect over the datagram transport, for lossy links where tcp lags (the server needs \"udp\": true)")
+	selector := flag.String("target", "", "connect to a server found on the local network, given its name or the beginning of its fingerprint")
+	where := addDiscoveryFlags(flag.CommandLine)
+	record := flag.String("record", "", "record the keys sent, with their timing, to a session file that -replay can send again")
+	replay := flag.String("replay", "", "send the keys recorded in a session file instead of opening a window")
+	speed := flag.Float64("speed", 1, "how many times faster than recorded -replay sends the keys, 0 for no waiting")
+	controlPath := flag.String("control", defaultControlSocket(), "unix socket to serve the control API on (see client ctl), none if empty")
+	logging := addLogFlags(flag.CommandLine)
+	ledsPath := flag.String("leds", "", "evdev device of a local keyboard whose LEDs mirror the ones of the target (eg. /dev/input/by-path/...-event-kbd)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] host port\n       %s [flags] -unix path\n       %s [flags] -target name\n       %s discover [flags]\n       %s ctl [flags] command [args]\n", os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if err := logging.setup(os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	network, addr := "unix", *unixPath
+	if *selector != "" {
+		targets, err := where.discover(discoveryTimeout)
+		if err != nil {
+			slog.Error("couldn't look for servers", errAttr(err))
+			return
+		}
+		t, err := selectTarget(targets, *selector)
+		if err != nil {
+			slog.Error(err.Error())
+			return
+		}
+		slog.Info("found the server", "instance", t.instance, "addr", t.addr())
+		network, addr = "tcp", t.addr()
+		if *udp {
+			network = "udp"
+		}
+	} else if *unixPath == "" {
+		if flag.NArg() != 2 {
+			fmt.Println("provide target machine ip and port, eg. 192.168.124.3 3001")
+			return
+		}
+		network, addr = "tcp", net.JoinHostPort(flag.Arg(0), flag.Arg(1))
+		if *udp {
+			network = "udp"
+		}
+	}
+	if *name == "" {
+		*name, _ = os.Hostname()
+	}
+	remote, err := connectToRemote(network, addr, *name)
+	if err != nil {
+		slog.Error("couldn't connect to the target machine", "remote", addr, errAttr(err))
+		return
+	}
+	defer remote.shutdown(shutdownTimeout)
+	var localLeds *ledDevice
+	if *ledsPath != "" {
+		localLeds, err = openLedDevice(*ledsPath)
+		if err != nil {
+			slog.Error(err.Error())
+			return
+		}
+		defer localLeds.Close()
+	}
+	if *record != "" {
+		f, err := os.OpenFile(*record, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			slog.Error("couldn't create a session file", errAttr(err))
+			return
+		}
+		defer f.Close()
+		if remote.recorder, err = newSessionRecorder(f, time.Now()); err != nil {
+			slog.Error(err.Error())
+			return
+		}
+		defer func() {
+			if err := remote.recorder.Err(); err != nil {
+				slog.Error("couldn't record the session", errAttr(err))
+			}
+		}()
+	}
+	if *replay != "" {
+		f, err := os.Open(*replay)
+		if err != nil {
+			slog.Error("couldn't open a session file", errAttr(err))
+			return
+		}
+		defer f.Close()
+		player, err := newSessionPlayer(f, *speed)
+		if err != nil {
+			slog.Error("couldn't replay "+*replay, errAttr(err))
+			return
+		}
+		remote.onLeds(func(leds ledState) { setLocalLeds(localLeds, leds) })
+		if err := runHeadless(player, remote); err != nil {
+			slog.Error("couldn't replay "+*replay, errAttr(err))
+			os.Exit(1)
+		}
 		return
 	}
-	if os.Getenv("DEBUG") == "1" {
-		slog.SetLogLoggerLevel(slog.LevelDebug)
+	if *headless {
+		remote.onLeds(func(leds ledState) { setLocalLeds(localLeds, leds) })
+		if err := runHeadless(os.Stdin, remote); err != nil {
+			slog.Error(err.Error())
+			os.Exit(1)
+		}
+		return
 	}
-	conn, err := connectToRemote()
+	waylandConn, err := DisplayConnect()
 	if err != nil {
-		slog.Error("couldn't connect to the target machine: " + err.Error())
+		slog.Error(err.Error())
 		return
 	}
-	defer conn.Close()
-	fd, err := DisplayConnect()
+	defer waylandConn.Close()
+	if *traceFile != "" {
+		f, err := os.Create(*traceFile)
+		if err != nil {
+			slog.Error("couldn't create a trace file", errAttr(err))
+			return
+		}
+		defer f.Close()
+		waylandConn.SetTrace(f)
+	} else if *trace || os.Getenv("WAYLAND_DEBUG") == "1" {
+		waylandConn.SetTrace(os.Stderr)
+	}
+	link := &targetLink{recorder: remote.recorder}
+	link.attach(remote, network, addr, false)
+	defer link.detach()
+	if *controlPath == "" && !flagSet(flag.CommandLine, "control") {
+		slog.Warn("not serving the control API, XDG_RUNTIME_DIR isn't set and -control doesn't give a socket")
+	} else if *controlPath != "" {
+		ctl, err := serveControl(*controlPath, link, *name, where)
+		if err != nil {
+			slog.Warn("couldn't serve the control API", errAttr(err))
+		} else {
+			defer ctl.Close()
+		}
+	}
+	runWindow(waylandConn, link, localLeds)
+}
+
+// whether a flag was given on the command line rather than left at its default
+func flagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		set = set || f.Name == name
+	})
+	return set
+}
+
+// Mirrors the LEDs of the target on a local keyboard, if there's one
+func setLocalLeds(localLeds *ledDevice, leds ledState) {
+	if localLeds == nil {
+		return
+	}
+	if err := localLeds.set(leds); err != nil {
+		slog.Error("couldn't set LEDs of the local keyboard", errAttr(err))
+	}
+}
+
+// Shows a window titled after the target of link and forwards all the keys pressed
+// while it's focused to it. The LEDs of the target are shown in the window and on
+// localLeds if it isn't nil. Returns when the window gets closed, the server closes
+// the connection or the one with the display server breaks.
+func runWindow(waylandConn *WaylandConn, link *targetLink, localLeds *ledDevice) {
+	indicator, err := newLedIndicator()
 	if err != nil {
 		slog.Error(err.Error())
 		return
 	}
-	defer syscall.Close(fd)
-	currentId := GetRegistry(fd)
-	state := createState(currentId)
-	done := make(chan bool)
-	keyboardEventsChan := keyboardEventsForward(conn, done)
-	go receiveFromWayland(fd, state, keyboardEventsChan, done)
+	defer indicator.Close()
+	done := make(chan bool, 1)
+	link.watch(func(leds ledState) {
+		indicator.set(leds)
+		setLocalLeds(localLeds, leds)
+	}, func() { stop(done) })
+	state := createState(link.title())
+	state.wlRegistry = waylandConn.WlDisplayGetRegistry(waylandDisplayObjectId)
+	waylandConn.SetHandler(state.wlRegistry, registryHandler(waylandConn, state))
+	if err := waylandConn.Flush(); err != nil {
+		slog.Error("couldn't request a registry", errAttr(err))
+		return
+	}
+	keyboardEventsChan := keyboardEventsForward(link, done)
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		receiveFromWayland(waylandConn, state, indicator, link, keyboardEventsChan, done)
+	}()
 	<-done
+	// the pipe of the indicator is polled until the loop returns, it's closed after that
+	indicator.stop()
+	<-finished
+}
+
+// tells the application to stop. Doesn't block if it's been told already.
+func stop(done chan bool) {
+	select {
+	case done <- true:
+	default:
+	}
 }
@@ -0,0 +1,2761 @@
+// Code generated by wlgen from protocols/wayland.xml, protocols/xdg-shell.xml, protocols/xdg-decoration-unstable-v1.xml, protocols/keyboard-shortcuts-inhibit-unstable-v1.xml; DO NOT EDIT.
+
+package main
+
+import "log/slog"
+
+// wl_display version 1
+
+const (
+	waylandWlDisplaySyncOpcode        uint16 = 0
+	waylandWlDisplayGetRegistryOpcode uint16 = 1
+	waylandWlDisplayEventError        uint16 = 0
+	waylandWlDisplayEventDeleteId     uint16 = 1
+)
+
+// wl_display.error
+const (
+	waylandWlDisplayErrorInvalidObject  uint32 = 0
+	waylandWlDisplayErrorInvalidMethod  uint32 = 1
+	waylandWlDisplayErrorNoMemory       uint32 = 2
+	waylandWlDisplayErrorImplementation uint32 = 3
+)
+
+// WlDisplaySync sends wl_display.sync and returns an id of the created object
+func (c *WaylandConn) WlDisplaySync(wlDisplay uint32) uint32 {
+	slog.Debug("request wl_display.sync")
+	callback := c.newId("wl_callback")
+	w := waylandWriter{}
+	w.putUint(callback)
+	c.request(wlDisplay, waylandWlDisplaySyncOpcode, w.data, w.fds...)
+	return callback
+}
+
+// WlDisplayGetRegistry sends wl_display.get_registry and returns an id of the created object
+func (c *WaylandConn) WlDisplayGetRegistry(wlDisplay uint32) uint32 {
+	slog.Debug("request wl_display.get_registry")
+	registry := c.newId("wl_registry")
+	w := waylandWriter{}
+	w.putUint(registry)
+	c.request(wlDisplay, waylandWlDisplayGetRegistryOpcode, w.data, w.fds...)
+	return registry
+}
+
+// wl_display.error
+type WlDisplayErrorEvent struct {
+	objectId uint32
+	code     uint32
+	message  string
+}
+
+// DecodeWlDisplayErrorEvent decodes the body of wl_display.error
+func DecodeWlDisplayErrorEvent(body []byte) (WlDisplayErrorEvent, error) {
+	e := WlDisplayErrorEvent{}
+	r := waylandReader{data: body}
+	var err error
+	if e.objectId, err = r.uint(); err != nil {
+		return e, decodeError("wl_display.error", "object_id", err)
+	}
+	if e.code, err = r.uint(); err != nil {
+		return e, decodeError("wl_display.error", "code", err)
+	}
+	if e.message, err = r.string(); err != nil {
+		return e, decodeError("wl_display.error", "message", err)
+	}
+	return e, nil
+}
+
+// wl_display.delete_id
+type WlDisplayDeleteIdEvent struct {
+	id uint32
+}
+
+// DecodeWlDisplayDeleteIdEvent decodes the body of wl_display.delete_id
+func DecodeWlDisplayDeleteIdEvent(body []byte) (WlDisplayDeleteIdEvent, error) {
+	e := WlDisplayDeleteIdEvent{}
+	r := waylandReader{data: body}
+	var err error
+	if e.id, err = r.uint(); err != nil {
+		return e, decodeError("wl_display.delete_id", "id", err)
+	}
+	return e, nil
+}
+
+var wlDisplayInterface = waylandInterfaceInfo{
+	name:    "wl_display",
+	version: 1,
+	requests: []waylandMessageInfo{
+		{name: "sync", since: 1, args: []waylandArgInfo{
+			{name: "callback", typ: waylandArgNewId, iface: "wl_callback"},
+		}},
+		{name: "get_registry", since: 1, args: []waylandArgInfo{
+			{name: "registry", typ: waylandArgNewId, iface: "wl_registry"},
+		}},
+	},
+	events: []waylandMessageInfo{
+		{name: "error", since: 1, args: []waylandArgInfo{
+			{name: "object_id", typ: waylandArgObject},
+			{name: "code", typ: waylandArgUint},
+			{name: "message", typ: waylandArgString},
+		}},
+		{name: "delete_id", since: 1, args: []waylandArgInfo{
+			{name: "id", typ: waylandArgUint},
+		}},
+	},
+	errors: map[uint32]string{
+		0: "invalid_object",
+		1: "invalid_method",
+		2: "no_memory",
+		3: "implementation",
+	},
+}
+
+// wl_registry version 1
+
+const (
+	waylandWlRegistryBindOpcode        uint16 = 0
+	waylandWlRegistryEventGlobal       uint16 = 0
+	waylandWlRegistryEventGlobalRemove uint16 = 1
+)
+
+// WlRegistryBind sends wl_registry.bind and returns an id of the created object
+func (c *WaylandConn) WlRegistryBind(wlRegistry uint32, name uint32, iface string, version uint32) uint32 {
+	slog.Debug("request wl_registry.bind")
+	id := c.newId(iface)
+	w := waylandWriter{}
+	w.putUint(name)
+	w.putString(iface, false)
+	w.putUint(version)
+	w.putUint(id)
+	c.request(wlRegistry, waylandWlRegistryBindOpcode, w.data, w.fds...)
+	return id
+}
+
+// wl_registry.global
+type WlRegistryGlobalEvent struct {
+	name         uint32
+	interfaceArg string
+	version      uint32
+}
+
+// DecodeWlRegistryGlobalEvent decodes the body of wl_registry.global
+func DecodeWlRegistryGlobalEvent(body []byte) (WlRegistryGlobalEvent, error) {
+	e := WlRegistryGlobalEvent{}
+	r := waylandReader{data: body}
+	var err error
+	if e.name, err = r.uint(); err != nil {
+		return e, decodeError("wl_registry.global", "name", err)
+	}
+	if e.interfaceArg, err = r.string(); err != nil {
+		return e, decodeError("wl_registry.global", "interface", err)
+	}
+	if e.version, err = r.uint(); err != nil {
+		return e, decodeError("wl_registry.global", "version", err)
+	}
+	return e, nil
+}
+
+// wl_registry.global_remove
+type WlRegistryGlobalRemoveEvent struct {
+	name uint32
+}
+
+// DecodeWlRegistryGlobalRemoveEvent decodes the body of wl_registry.global_remove
+func DecodeWlRegistryGlobalRemoveEvent(body []byte) (WlRegistryGlobalRemoveEvent, error) {
+	e := WlRegistryGlobalRemoveEvent{}
+	r := waylandReader{data: body}
+	var err error
+	if e.name, err = r.uint(); err != nil {
+		return e, decodeError("wl_registry.global_remove", "name", err)
+	}
+	return e, nil
+}
+
+var wlRegistryInterface = waylandInterfaceInfo{
+	name:    "wl_registry",
+	version: 1,
+	requests: []waylandMessageInfo{
+		{name: "bind", since: 1, args: []waylandArgInfo{
+			{name: "name", typ: waylandArgUint},
+			{name: "id", typ: waylandArgNewId},
+		}},
+	},
+	events: []waylandMessageInfo{
+		{name: "global", since: 1, args: []waylandArgInfo{
+			{name: "name", typ: waylandArgUint},
+			{name: "interface", typ: waylandArgString},
+			{name: "version", typ: waylandArgUint},
+		}},
+		{name: "global_remove", since: 1, args: []waylandArgInfo{
+			{name: "name", typ: waylandArgUint},
+		}},
+	},
+}
+
+// wl_callback version 1
+
+const (
+	waylandWlCallbackEventDone uint16 = 0
+)
+
+// wl_callback.done
+type WlCallbackDoneEvent struct {
+	callbackData uint32
+}
+
+// DecodeWlCallbackDoneEvent decodes the body of wl_callback.done
+func DecodeWlCallbackDoneEvent(body []byte) (WlCallbackDoneEvent, error) {
+	e := WlCallbackDoneEvent{}
+	r := waylandReader{data: body}
+	var err error
+	if e.callbackData, err = r.uint(); err != nil {
+		return e, decodeError("wl_callback.done", "callback_data", err)
+	}
+	return e, nil
+}
+
+var wlCallbackInterface = waylandInterfaceInfo{
+	name:    "wl_callback",
+	version: 1,
+	events: []waylandMessageInfo{
+		{name: "done", since: 1, args: []waylandArgInfo{
+			{name: "callback_data", typ: waylandArgUint},
+		}},
+	},
+}
+
+// wl_compositor version 6
+
+const (
+	waylandWlCompositorCreateSurfaceOpcode uint16 = 0
+	waylandWlCompositorCreateRegionOpcode  uint16 = 1
+)
+
+// WlCompositorCreateSurface sends wl_compositor.create_surface and returns an id of the created object
+func (c *WaylandConn) WlCompositorCreateSurface(wlCompositor uint32) uint32 {
+	slog.Debug("request wl_compositor.create_surface")
+	id := c.newId("wl_surface")
+	w := waylandWriter{}
+	w.putUint(id)
+	c.request(wlCompositor, waylandWlCompositorCreateSurfaceOpcode, w.data, w.fds...)
+	return id
+}
+
+// WlCompositorCreateRegion sends wl_compositor.create_region and returns an id of the created object
+func (c *WaylandConn) WlCompositorCreateRegion(wlCompositor uint32) uint32 {
+	slog.Debug("request wl_compositor.create_region")
+	id := c.newId("wl_region")
+	w := waylandWriter{}
+	w.putUint(id)
+	c.request(wlCompositor, waylandWlCompositorCreateRegionOpcode, w.data, w.fds...)
+	return id
+}
+
+var wlCompositorInterface = waylandInterfaceInfo{
+	name:    "wl_compositor",
+	version: 6,
+	requests: []waylandMessageInfo{
+		{name: "create_surface", since: 1, args: []waylandArgInfo{
+			{name: "id", typ: waylandArgNewId, iface: "wl_surface"},
+		}},
+		{name: "create_region", since: 1, args: []waylandArgInfo{
+			{name: "id", typ: waylandArgNewId, iface: "wl_region"},
+		}},
+	},
+}
+
+// wl_shm_pool version 2
+
+const (
+	waylandWlShmPoolCreateBufferOpcode uint16 = 0
+	waylandWlShmPoolDestroyOpcode      uint16 = 1
+	waylandWlShmPoolResizeOpcode       uint16 = 2
+)
+
+// WlShmPoolCreateBuffer sends wl_shm_pool.create_buffer and returns an id of the created object
+func (c *WaylandConn) WlShmPoolCreateBuffer(wlShmPool uint32, offset int32, width int32, height int32, stride int32, format uint32) uint32 {
+	slog.Debug("request wl_shm_pool.create_buffer")
+	id := c.newId("wl_buffer")
+	w := waylandWriter{}
+	w.putUint(id)
+	w.putInt(offset)
+	w.putInt(width)
+	w.putInt(height)
+	w.putInt(stride)
+	w.putUint(format)
+	c.request(wlShmPool, waylandWlShmPoolCreateBufferOpcode, w.data, w.fds...)
+	return id
+}
+
+// WlShmPoolDestroy sends wl_shm_pool.destroy
+func (c *WaylandConn) WlShmPoolDestroy(wlShmPool uint32) {
+	slog.Debug("request wl_shm_pool.destroy")
+	w := waylandWriter{}
+	c.request(wlShmPool, waylandWlShmPoolDestroyOpcode, w.data, w.fds...)
+}
+
+// WlShmPoolResize sends wl_shm_pool.resize
+func (c *WaylandConn) WlShmPoolResize(wlShmPool uint32, size int32) {
+	slog.Debug("request wl_shm_pool.resize")
+	w := waylandWriter{}
+	w.putInt(size)
+	c.request(wlShmPool, waylandWlShmPoolResizeOpcode, w.data, w.fds...)
+}
+
+var wlShmPoolInterface = waylandInterfaceInfo{
+	name:    "wl_shm_pool",
+	version: 2,
+	requests: []waylandMessageInfo{
+		{name: "create_buffer", since: 1, args: []waylandArgInfo{
+			{name: "id", typ: waylandArgNewId, iface: "wl_buffer"},
+			{name: "offset", typ: waylandArgInt},
+			{name: "width", typ: waylandArgInt},
+			{name: "height", typ: waylandArgInt},
+			{name: "stride", typ: waylandArgInt},
+			{name: "format", typ: waylandArgUint},
+		}},
+		{name: "destroy", since: 1},
+		{name: "resize", since: 1, args: []waylandArgInfo{
+			{name: "size", typ: waylandArgInt},
+		}},
+	},
+}
+
+// wl_shm version 2
+
+const (
+	waylandWlShmCreatePoolOpcode uint16 = 0
+	waylandWlShmReleaseOpcode    uint16 = 1
+	waylandWlShmEventFormat      uint16 = 0
+)
+
+// wl_shm.error
+const (
+	waylandWlShmErrorInvalidFormat uint32 = 0
+	waylandWlShmErrorInvalidStride uint32 = 1
+	waylandWlShmErrorInvalidFd     uint32 = 2
+)
+
+// wl_shm.format
+const (
+	waylandWlShmFormatArgb8888 uint32 = 0
+	waylandWlShmFormatXrgb8888 uint32 = 1
+	waylandWlShmFormatRgb565   uint32 = 0x36314752
+	waylandWlShmFormatXbgr8888 uint32 = 0x34324258
+	waylandWlShmFormatAbgr8888 uint32 = 0x34324241
+)
+
+// WlShmCreatePool sends wl_shm.create_pool and returns an id of the created object
+func (c *WaylandConn) WlShmCreatePool(wlShm uint32, fd int, size int32) uint32 {
+	slog.Debug("request wl_shm.create_pool")
+	id := c.newId("wl_shm_pool")
+	w := waylandWriter{}
+	w.putUint(id)
+	w.putFd(fd)
+	w.putInt(size)
+	c.request(wlShm, waylandWlShmCreatePoolOpcode, w.data, w.fds...)
+	return id
+}
+
+// WlShmRelease sends wl_shm.release
+func (c *WaylandConn) WlShmRelease(wlShm uint32) {
+	slog.Debug("request wl_shm.release")
+	w := waylandWriter{}
+	c.request(wlShm, waylandWlShmReleaseOpcode, w.data, w.fds...)
+}
+
+// wl_shm.format
+type WlShmFormatEvent struct {
+	format uint32
+}
+
+// DecodeWlShmFormatEvent decodes the body of wl_shm.format
+func DecodeWlShmFormatEvent(body []byte) (WlShmFormatEvent, error) {
+	e := WlShmFormatEvent{}
+	r := waylandReader{data: body}
+	var err error
+	if e.format, err = r.uint(); err != nil {
+		return e, decodeError("wl_shm.format", "format", err)
+	}
+	return e, nil
+}
+
+var wlShmInterface = waylandInterfaceInfo{
+	name:    "wl_shm",
+	version: 2,
+	requests: []waylandMessageInfo{
+		{name: "create_pool", since: 1, args: []waylandArgInfo{
+			{name: "id", typ: waylandArgNewId, iface: "wl_shm_pool"},
+			{name: "fd", typ: waylandArgFd},
+			{name: "size", typ: waylandArgInt},
+		}},
+		{name: "release", since: 2},
+	},
+	events: []waylandMessageInfo{
+		{name: "format", since: 1, args: []waylandArgInfo{
+			{name: "format", typ: waylandArgUint},
+		}},
+	},
+	errors: map[uint32]string{
+		0: "invalid_format",
+		1: "invalid_stride",
+		2: "invalid_fd",
+	},
+}
+
+// wl_buffer version 1
+
+const (
+	waylandWlBufferDestroyOpcode uint16 = 0
+	waylandWlBufferEventRelease  uint16 = 0
+)
+
+// WlBufferDestroy sends wl_buffer.destroy
+func (c *WaylandConn) WlBufferDestroy(wlBuffer uint32) {
+	slog.Debug("request wl_buffer.destroy")
+	w := waylandWriter{}
+	c.request(wlBuffer, waylandWlBufferDestroyOpcode, w.data, w.fds...)
+}
+
+// wl_buffer.release
+type WlBufferReleaseEvent struct {
+}
+
+// DecodeWlBufferReleaseEvent decodes the body of wl_buffer.release
+func DecodeWlBufferReleaseEvent(body []byte) (WlBufferReleaseEvent, error) {
+	e := WlBufferReleaseEvent{}
+	return e, nil
+}
+
+var wlBufferInterface = waylandInterfaceInfo{
+	name:    "wl_buffer",
+	version: 1,
+	requests: []waylandMessageInfo{
+		{name: "destroy", since: 1},
+	},
+	events: []waylandMessageInfo{
+		{name: "release", since: 1},
+	},
+}
+
+// wl_surface version 6
+
+const (
+	waylandWlSurfaceDestroyOpcode                 uint16 = 0
+	waylandWlSurfaceAttachOpcode                  uint16 = 1
+	waylandWlSurfaceDamageOpcode                  uint16 = 2
+	waylandWlSurfaceFrameOpcode                   uint16 = 3
+	waylandWlSurfaceSetOpaqueRegionOpcode         uint16 = 4
+	waylandWlSurfaceSetInputRegionOpcode          uint16 = 5
+	waylandWlSurfaceCommitOpcode                  uint16 = 6
+	waylandWlSurfaceSetBufferTransformOpcode      uint16 = 7
+	waylandWlSurfaceSetBufferScaleOpcode          uint16 = 8
+	waylandWlSurfaceDamageBufferOpcode            uint16 = 9
+	waylandWlSurfaceOffsetOpcode                  uint16 = 10
+	waylandWlSurfaceEventEnter                    uint16 = 0
+	waylandWlSurfaceEventLeave                    uint16 = 1
+	waylandWlSurfaceEventPreferredBufferScale     uint16 = 2
+	waylandWlSurfaceEventPreferredBufferTransform uint16 = 3
+)
+
+// wl_surface.error
+const (
+	waylandWlSurfaceErrorInvalidScale      uint32 = 0
+	waylandWlSurfaceErrorInvalidTransform  uint32 = 1
+	waylandWlSurfaceErrorInvalidSize       uint32 = 2
+	waylandWlSurfaceErrorInvalidOffset     uint32 = 3
+	waylandWlSurfaceErrorDefunctRoleObject uint32 = 4
+)
+
+// WlSurfaceDestroy sends wl_surface.destroy
+func (c *WaylandConn) WlSurfaceDestroy(wlSurface uint32) {
+	slog.Debug("request wl_surface.destroy")
+	w := waylandWriter{}
+	c.request(wlSurface, waylandWlSurfaceDestroyOpcode, w.data, w.fds...)
+}
+
+// WlSurfaceAttach sends wl_surface.attach
+func (c *WaylandConn) WlSurfaceAttach(wlSurface uint32, buffer uint32, x int32, y int32) {
+	slog.Debug("request wl_surface.attach")
+	w := waylandWriter{}
+	w.putUint(buffer)
+	w.putInt(x)
+	w.putInt(y)
+	c.request(wlSurface, waylandWlSurfaceAttachOpcode, w.data, w.fds...)
+}
+
+// WlSurfaceDamage sends wl_surface.damage
+func (c *WaylandConn) WlSurfaceDamage(wlSurface uint32, x int32, y int32, width int32, height int32) {
+	slog.Debug("request wl_surface.damage")
+	w := waylandWriter{}
+	w.putInt(x)
+	w.putInt(y)
+	w.putInt(width)
+	w.putInt(height)
+	c.request(wlSurface, waylandWlSurfaceDamageOpcode, w.data, w.fds...)
+}
+
+// WlSurfaceFrame sends wl_surface.frame and returns an id of the created object
+func (c *WaylandConn) WlSurfaceFrame(wlSurface uint32) uint32 {
+	slog.Debug("request wl_surface.frame")
+	callback := c.newId("wl_callback")
+	w := waylandWriter{}
+	w.putUint(callback)
+	c.request(wlSurface, waylandWlSurfaceFrameOpcode, w.data, w.fds...)
+	return callback
+}
+
+// WlSurfaceSetOpaqueRegion sends wl_surface.set_opaque_region
+func (c *WaylandConn) WlSurfaceSetOpaqueRegion(wlSurface uint32, region uint32) {
+	slog.Debug("request wl_surface.set_opaque_region")
+	w := waylandWriter{}
+	w.putUint(region)
+	c.request(wlSurface, waylandWlSurfaceSetOpaqueRegionOpcode, w.data, w.fds...)
+}
+
+// WlSurfaceSetInputRegion sends wl_surface.set_input_region
+func (c *WaylandConn) WlSurfaceSetInputRegion(wlSurface uint32, region uint32) {
+	slog.Debug("request wl_surface.set_input_region")
+	w := waylandWriter{}
+	w.putUint(region)
+	c.request(wlSurface, waylandWlSurfaceSetInputRegionOpcode, w.data, w.fds...)
+}
+
+// WlSurfaceCommit sends wl_surface.commit
+func (c *WaylandConn) WlSurfaceCommit(wlSurface uint32) {
+	slog.Debug("request wl_surface.commit")
+	w := waylandWriter{}
+	c.request(wlSurface, waylandWlSurfaceCommitOpcode, w.data, w.fds...)
+}
+
+// WlSurfaceSetBufferTransform sends wl_surface.set_buffer_transform
+func (c *WaylandConn) WlSurfaceSetBufferTransform(wlSurface uint32, transform int32) {
+	slog.Debug("request wl_surface.set_buffer_transform")
+	w := waylandWriter{}
+	w.putInt(transform)
+	c.request(wlSurface, waylandWlSurfaceSetBufferTransformOpcode, w.data, w.fds...)
+}
+
+// WlSurfaceSetBufferScale sends wl_surface.set_buffer_scale
+func (c *WaylandConn) WlSurfaceSetBufferScale(wlSurface uint32, scale int32) {
+	slog.Debug("request wl_surface.set_buffer_scale")
+	w := waylandWriter{}
+	w.putInt(scale)
+	c.request(wlSurface, waylandWlSurfaceSetBufferScaleOpcode, w.data, w.fds...)
+}
+
+// WlSurfaceDamageBuffer sends wl_surface.damage_buffer
+func (c *WaylandConn) WlSurfaceDamageBuffer(wlSurface uint32, x int32, y int32, width int32, height int32) {
+	slog.Debug("request wl_surface.damage_buffer")
+	w := waylandWriter{}
+	w.putInt(x)
+	w.putInt(y)
+	w.putInt(width)
+	w.putInt(height)
+	c.request(wlSurface, waylandWlSurfaceDamageBufferOpcode, w.data, w.fds...)
+}
+
+// WlSurfaceOffset sends wl_surface.offset
+func (c *WaylandConn) WlSurfaceOffset(wlSurface uint32, x int32, y int32) {
+	slog.Debug("request wl_surface.offset")
+	w := waylandWriter{}
+	w.putInt(x)
+	w.putInt(y)
+	c.request(wlSurface, waylandWlSurfaceOffsetOpcode, w.data, w.fds...)
+}
+
+// wl_surface.enter
+type WlSurfaceEnterEvent struct {
+	output uint32
+}
+
+// DecodeWlSurfaceEnterEvent decodes the body of wl_surface.enter
+func DecodeWlSurfaceEnterEvent(body []byte) (WlSurfaceEnterEvent, error) {
+	e := WlSurfaceEnterEvent{}
+	r := waylandReader{data: body}
+	var err error
+	if e.output, err = r.uint(); err != nil {
+		return e, decodeError("wl_surface.enter", "output", err)
+	}
+	return e, nil
+}
+
+// wl_surface.leave
+type WlSurfaceLeaveEvent struct {
+	output uint32
+}
+
+// DecodeWlSurfaceLeaveEvent decodes the body of wl_surface.leave
+func DecodeWlSurfaceLeaveEvent(body []byte) (WlSurfaceLeaveEvent, error) {
+	e := WlSurfaceLeaveEvent{}
+	r := waylandReader{data: body}
+	var err error
+	if e.output, err = r.uint(); err != nil {
+		return e, decodeError("wl_surface.leave", "output", err)
+	}
+	return e, nil
+}
+
+// wl_surface.preferred_buffer_scale
+type WlSurfacePreferredBufferScaleEvent struct {
+	factor int32
+}
+
+// DecodeWlSurfacePreferredBufferScaleEvent decodes the body of wl_surface.preferred_buffer_scale
+func DecodeWlSurfacePreferredBufferScaleEvent(body []byte) (WlSurfacePreferredBufferScaleEvent, error) {
+	e := WlSurfacePreferredBufferScaleEvent{}
+	r := waylandReader{data: body}
+	var err error
+	if e.factor, err = r.int(); err != nil {
+		return e, decodeError("wl_surface.preferred_buffer_scale", "factor", err)
+	}
+	return e, nil
+}
+
+// wl_surface.preferred_buffer_transform
+type WlSurfacePreferredBufferTransformEvent struct {
+	transform uint32
+}
+
+// DecodeWlSurfacePreferredBufferTransformEvent decodes the body of wl_surface.preferred_buffer_transform
+func DecodeWlSurfacePreferredBufferTransformEvent(body []byte) (WlSurfacePreferredBufferTransformEvent, error) {
+	e := WlSurfacePreferredBufferTransformEvent{}
+	r := waylandReader{data: body}
+	var err error
+	if e.transform, err = r.uint(); err != nil {
+		return e, decodeError("wl_surface.preferred_buffer_transform", "transform", err)
+	}
+	return e, nil
+}
+
+var wlSurfaceInterface = waylandInterfaceInfo{
+	name:    "wl_surface",
+	version: 6,
+	requests: []waylandMessageInfo{
+		{name: "destroy", since: 1},
+		{name: "attach", since: 1, args: []waylandArgInfo{
+			{name: "buffer", typ: waylandArgObject, iface: "wl_buffer", allowNull: true},
+			{name: "x", typ: waylandArgInt},
+			{name: "y", typ: waylandArgInt},
+		}},
+		{name: "damage", since: 1, args: []waylandArgInfo{
+			{name: "x", typ: waylandArgInt},
+			{name: "y", typ: waylandArgInt},
+			{name: "width", typ: waylandArgInt},
+			{name: "height", typ: waylandArgInt},
+		}},
+		{name: "frame", since: 1, args: []waylandArgInfo{
+			{name: "callback", typ: waylandArgNewId, iface: "wl_callback"},
+		}},
+		{name: "set_opaque_region", since: 1, args: []waylandArgInfo{
+			{name: "region", typ: waylandArgObject, iface: "wl_region", allowNull: true},
+		}},
+		{name: "set_input_region", since: 1, args: []waylandArgInfo{
+			{name: "region", typ: waylandArgObject, iface: "wl_region", allowNull: true},
+		}},
+		{name: "commit", since: 1},
+		{name: "set_buffer_transform", since: 2, args: []waylandArgInfo{
+			{name: "transform", typ: waylandArgInt},
+		}},
+		{name: "set_buffer_scale", since: 3, args: []waylandArgInfo{
+			{name: "scale", typ: waylandArgInt},
+		}},
+		{name: "damage_buffer", since: 4, args: []waylandArgInfo{
+			{name: "x", typ: waylandArgInt},
+			{name: "y", typ: waylandArgInt},
+			{name: "width", typ: waylandArgInt},
+			{name: "height", typ: waylandArgInt},
+		}},
+		{name: "offset", since: 5, args: []waylandArgInfo{
+			{name: "x", typ: waylandArgInt},
+			{name: "y", typ: waylandArgInt},
+		}},
+	},
+	events: []waylandMessageInfo{
+		{name: "enter", since: 1, args: []waylandArgInfo{
+			{name: "output", typ: waylandArgObject, iface: "wl_output"},
+		}},
+		{name: "leave", since: 1, args: []waylandArgInfo{
+			{name: "output", typ: waylandArgObject, iface: "wl_output"},
+		}},
+		{name: "preferred_buffer_scale", since: 6, args: []waylandArgInfo{
+			{name: "factor", typ: waylandArgInt},
+		}},
+		{name: "preferred_buffer_transform", since: 6, args: []waylandArgInfo{
+			{name: "transform", typ: waylandArgUint},
+		}},
+	},
+	errors: map[uint32]string{
+		0: "invalid_scale",
+		1: "invalid_transform",
+		2: "invalid_size",
+		3: "invalid_offset",
+		4: "defunct_role_object",
+	},
+}
+
+// wl_seat version 9
+
+const (
+	waylandWlSeatGetPointerOpcode  uint16 = 0
+	waylandWlSeatGetKeyboardOpcode uint16 = 1
+	waylandWlSeatGetTouchOpcode    uint16 = 2
+	waylandWlSeatReleaseOpcode     uint16 = 3
+	waylandWlSeatEventCapabilities uint16 = 0
+	waylandWlSeatEventName         uint16 = 1
+)
+
+// wl_seat.capability
+const (
+	waylandWlSeatCapabilityPointer  uint32 = 1
+	waylandWlSeatCapabilityKeyboard uint32 = 2
+	waylandWlSeatCapabilityTouch    uint32 = 4
+)
+
+// wl_seat.error
+const (
+	waylandWlSeatErrorMissingCapability uint32 = 0
+)
+
+// WlSeatGetPointer sends wl_seat.get_pointer and returns an id of the created object
+func (c *WaylandConn) WlSeatGetPointer(wlSeat uint32) uint32 {
+	slog.Debug("request wl_seat.get_pointer")
+	id := c.newId("wl_pointer")
+	w := waylandWriter{}
+	w.putUint(id)
+	c.request(wlSeat, waylandWlSeatGetPointerOpcode, w.data, w.fds...)
+	return id
+}
+
+// WlSeatGetKeyboard sends wl_seat.get_keyboard and returns an id of the created object
+func (c *WaylandConn) WlSeatGetKeyboard(wlSeat uint32) uint32 {
+	slog.Debug("request wl_seat.get_keyboard")
+	id := c.newId("wl_keyboard")
+	w := waylandWriter{}
+	w.putUint(id)
+	c.request(wlSeat, waylandWlSeatGetKeyboardOpcode, w.data, w.fds...)
+	return id
+}
+
+// WlSeatGetTouch sends wl_seat.get_touch and returns an id of the created object
+func (c *WaylandConn) WlSeatGetTouch(wlSeat uint32) uint32 {
+	slog.Debug("request wl_seat.get_touch")
+	id := c.newId("wl_touch")
+	w := waylandWriter{}
+	w.putUint(id)
+	c.request(wlSeat, waylandWlSeatGetTouchOpcode, w.data, w.fds...)
+	return id
+}
+
+// WlSeatRelease sends wl_seat.release
+func (c *WaylandConn) WlSeatRelease(wlSeat uint32) {
+	slog.Debug("request wl_seat.release")
+	w := waylandWriter{}
+	c.request(wlSeat, waylandWlSeatReleaseOpcode, w.data, w.fds...)
+}
+
+// wl_seat.capabilities
+type WlSeatCapabilitiesEvent struct {
+	capabilities uint32
+}
+
+// DecodeWlSeatCapabilitiesEvent decodes the body of wl_seat.capabilities
+func DecodeWlSeatCapabilitiesEvent(body []byte) (WlSeatCapabilitiesEvent, error) {
+	e := WlSeatCapabilitiesEvent{}
+	r := waylandReader{data: body}
+	var err error
+	if e.capabilities, err = r.uint(); err != nil {
+		return e, decodeError("wl_seat.capabilities", "capabilities", err)
+	}
+	return e, nil
+}
+
+// wl_seat.name
+type WlSeatNameEvent struct {
+	name string
+}
+
+// DecodeWlSeatNameEvent decodes the body of wl_seat.name
+func DecodeWlSeatNameEvent(body []byte) (WlSeatNameEvent, error) {
+	e := WlSeatNameEvent{}
+	r := waylandReader{data: body}
+	var err error
+	if e.name, err = r.string(); err != nil {
+		return e, decodeError("wl_seat.name", "name", err)
+	}
+	return e, nil
+}
+
+var wlSeatInterface = waylandInterfaceInfo{
+	name:    "wl_seat",
+	version: 9,
+	requests: []waylandMessageInfo{
+		{name: "get_pointer", since: 1, args: []waylandArgInfo{
+			{name: "id", typ: waylandArgNewId, iface: "wl_pointer"},
+		}},
+		{name: "get_keyboard", since: 1, args: []waylandArgInfo{
+			{name: "id", typ: waylandArgNewId, iface: "wl_keyboard"},
+		}},
+		{name: "get_touch", since: 1, args: []waylandArgInfo{
+			{name: "id", typ: waylandArgNewId, iface: "wl_touch"},
+		}},
+		{name: "release", since: 5},
+	},
+	events: []waylandMessageInfo{
+		{name: "capabilities", since: 1, args: []waylandArgInfo{
+			{name: "capabilities", typ: waylandArgUint},
+		}},
+		{name: "name", since: 2, args: []waylandArgInfo{
+			{name: "name", typ: waylandArgString},
+		}},
+	},
+	errors: map[uint32]string{
+		0: "missing_capability",
+	},
+}
+
+// wl_pointer version 9
+
+const (
+	waylandWlPointerSetCursorOpcode            uint16 = 0
+	waylandWlPointerReleaseOpcode              uint16 = 1
+	waylandWlPointerEventEnter                 uint16 = 0
+	waylandWlPointerEventLeave                 uint16 = 1
+	waylandWlPointerEventMotion                uint16 = 2
+	waylandWlPointerEventButton                uint16 = 3
+	waylandWlPointerEventAxis                  uint16 = 4
+	waylandWlPointerEventFrame                 uint16 = 5
+	waylandWlPointerEventAxisSource            uint16 = 6
+	waylandWlPointerEventAxisStop              uint16 = 7
+	waylandWlPointerEventAxisDiscrete          uint16 = 8
+	waylandWlPointerEventAxisValue120          uint16 = 9
+	waylandWlPointerEventAxisRelativeDirection uint16 = 10
+)
+
+// wl_pointer.error
+const (
+	waylandWlPointerErrorRole uint32 = 0
+)
+
+// wl_pointer.button_state
+const (
+	waylandWlPointerButtonStateReleased uint32 = 0
+	waylandWlPointerButtonStatePressed  uint32 = 1
+)
+
+// wl_pointer.axis
+const (
+	waylandWlPointerAxisVerticalScroll   uint32 = 0
+	waylandWlPointerAxisHorizontalScroll uint32 = 1
+)
+
+// wl_pointer.axis_source
+const (
+	waylandWlPointerAxisSourceWheel      uint32 = 0
+	waylandWlPointerAxisSourceFinger     uint32 = 1
+	waylandWlPointerAxisSourceContinuous uint32 = 2
+	waylandWlPointerAxisSourceWheelTilt  uint32 = 3
+)
+
+// wl_pointer.axis_relative_direction
+const (
+	waylandWlPointerAxisRelativeDirectionIdentical uint32 = 0
+	waylandWlPointerAxisRelativeDirectionInverted  uint32 = 1
+)
+
+// WlPointerSetCursor sends wl_pointer.set_cursor
+func (c *WaylandConn) WlPointerSetCursor(wlPointer uint32, serial uint32, surface uint32, hotspotX int32, hotspotY int32) {
+	slog.Debug("request wl_pointer.set_cursor")
+	w := waylandWriter{}
+	w.putUint(serial)
+	w.putUint(surface)
+	w.putInt(hotspotX)
+	w.putInt(hotspotY)
+	c.request(wlPointer, waylandWlPointerSetCursorOpcode, w.data, w.fds...)
+}
+
+// WlPointerRelease sends wl_pointer.release
+func (c *WaylandConn) WlPointerRelease(wlPointer uint32) {
+	slog.Debug("request wl_pointer.release")
+	w := waylandWriter{}
+	c.request(wlPointer, waylandWlPointerReleaseOpcode, w.data, w.fds...)
+}
+
+// wl_pointer.enter
+type WlPointerEnterEvent struct {
+	serial   uint32
+	surface  uint32
+	surfaceX waylandFixed
+	surfaceY waylandFixed
+}
+
+// DecodeWlPointerEnterEvent decodes the body of wl_pointer.enter
+func DecodeWlPointerEnterEvent(body []byte) (WlPointerEnterEvent, error) {
+	e := WlPointerEnterEvent{}
+	r := waylandReader{data: body}
+	var err error
+	if e.serial, err = r.uint(); err != nil {
+		return e, decodeError("wl_pointer.enter", "serial", err)
+	}
+	if e.surface, err = r.uint(); err != nil {
+		return e, decodeError("wl_pointer.enter", "surface", err)
+	}
+	if e.surfaceX, err = r.fixed(); err != nil {
+		return e, decodeError("wl_pointer.enter", "surface_x", err)
+	}
+	if e.surfaceY, err = r.fixed(); err != nil {
+		return e, decodeError("wl_pointer.enter", "surface_y", err)
+	}
+	return e, nil
+}
+
+// wl_pointer.leave
+type WlPointerLeaveEvent struct {
+	serial  uint32
+	surface uint32
+}
+
+// DecodeWlPointerLeaveEvent decodes the body of wl_pointer.leave
+func DecodeWlPointerLeaveEvent(body []byte) (WlPointerLeaveEvent, error) {
+	e := WlPointerLeaveEvent{}
+	r := waylandReader{data: body}
+	var err error
+	if e.serial, err = r.uint(); err != nil {
+		return e, decodeError("wl_pointer.leave", "serial", err)
+	}
+	if e.surface, err = r.uint(); err != nil {
+		return e, decodeError("wl_pointer.leave", "surface", err)
+	}
+	return e, nil
+}
+
+// wl_pointer.motion
+type WlPointerMotionEvent struct {
+	time     uint32
+	surfaceX waylandFixed
+	surfaceY waylandFixed
+}
+
+// DecodeWlPointerMotionEvent decodes the body of wl_pointer.motion
+func DecodeWlPointerMotionEvent(body []byte) (WlPointerMotionEvent, error) {
+	e := WlPointerMotionEvent{}
+	r := waylandReader{data: body}
+	var err error
+	if e.time, err = r.uint(); err != nil {
+		return e, decodeError("wl_pointer.motion", "time", err)
+	}
+	if e.surfaceX, err = r.fixed(); err != nil {
+		return e, decodeError("wl_pointer.motion", "surface_x", err)
+	}
+	if e.surfaceY, err = r.fixed(); err != nil {
+		return e, decodeError("wl_pointer.motion", "surface_y", err)
+	}
+	return e, nil
+}
+
+// wl_pointer.button
+type WlPointerButtonEvent struct {
+	serial uint32
+	time   uint32
+	button uint32
+	state  uint32
+}
+
+// DecodeWlPointerButtonEvent decodes the body of wl_pointer.button
+func DecodeWlPointerButtonEvent(body []byte) (WlPointerButtonEvent, error) {
+	e := WlPointerButtonEvent{}
+	r := waylandReader{data: body}
+	var err error
+	if e.serial, err = r.uint(); err != nil {
+		return e, decodeError("wl_pointer.button", "serial", err)
+	}
+	if e.time, err = r.uint(); err != nil {
+		return e, decodeError("wl_pointer.button", "time", err)
+	}
+	if e.button, err = r.uint(); err != nil {
+		return e, decodeError("wl_pointer.button", "button", err)
+	}
+	if e.state, err = r.uint(); err != nil {
+		return e, decodeError("wl_pointer.button", "state", err)
+	}
+	return e, nil
+}
+
+// wl_pointer.axis
+type WlPointerAxisEvent struct {
+	time  uint32
+	axis  uint32
+	value waylandFixed
+}
+
+// DecodeWlPointerAxisEvent decodes the body of wl_pointer.axis
+func DecodeWlPointerAxisEvent(body []byte) (WlPointerAxisEvent, error) {
+	e := WlPointerAxisEvent{}
+	r := waylandReader{data: body}
+	var err error
+	if e.time, err = r.uint(); err != nil {
+		return e, decodeError("wl_pointer.axis", "time", err)
+	}
+	if e.axis, err = r.uint(); err != nil {
+		return e, decodeError("wl_pointer.axis", "axis", err)
+	}
+	if e.value, err = r.fixed(); err != nil {
+		return e, decodeError("wl_pointer.axis", "value", err)
+	}
+	return e, nil
+}
+
+// wl_pointer.frame
+type WlPointerFrameEvent struct {
+}
+
+// DecodeWlPointerFrameEvent decodes the body of wl_pointer.frame
+func DecodeWlPointerFrameEvent(body []byte) (WlPointerFrameEvent, error) {
+	e := WlPointerFrameEvent{}
+	return e, nil
+}
+
+// wl_pointer.axis_source
+type WlPointerAxisSourceEvent struct {
+	axisSource uint32
+}
+
+// DecodeWlPointerAxisSourceEvent decodes the body of wl_pointer.axis_source
+func DecodeWlPointerAxisSourceEvent(body []byte) (WlPointerAxisSourceEvent, error) {
+	e := WlPointerAxisSourceEvent{}
+	r := waylandReader{data: body}
+	var err error
+	if e.axisSource, err = r.uint(); err != nil {
+		return e, decodeError("wl_pointer.axis_source", "axis_source", err)
+	}
+	return e, nil
+}
+
+// wl_pointer.axis_stop
+type WlPointerAxisStopEvent struct {
+	time uint32
+	axis uint32
+}
+
+// DecodeWlPointerAxisStopEvent decodes the body of wl_pointer.axis_stop
+func DecodeWlPointerAxisStopEvent(body []byte) (WlPointerAxisStopEvent, error) {
+	e := WlPointerAxisStopEvent{}
+	r := waylandReader{data: body}
+	var err error
+	if e.time, err = r.uint(); err != nil {
+		return e, decodeError("wl_pointer.axis_stop", "time", err)
+	}
+	if e.axis, err = r.uint(); err != nil {
+		return e, decodeError("wl_pointer.axis_stop", "axis", err)
+	}
+	return e, nil
+}
+
+// wl_pointer.axis_discrete
+type WlPointerAxisDiscreteEvent struct {
+	axis     uint32
+	discrete int32
+}
+
+// DecodeWlPointerAxisDiscreteEvent decodes the body of wl_pointer.axis_discrete
+func DecodeWlPointerAxisDiscreteEvent(body []byte) (WlPointerAxisDiscreteEvent, error) {
+	e := WlPointerAxisDiscreteEvent{}
+	r := waylandReader{data: body}
+	var err error
+	if e.axis, err = r.uint(); err != nil {
+		return e, decodeError("wl_pointer.axis_discrete", "axis", err)
+	}
+	if e.discrete, err = r.int(); err != nil {
+		return e, decodeError("wl_pointer.axis_discrete", "discrete", err)
+	}
+	return e, nil
+}
+
+// wl_pointer.axis_value120
+type WlPointerAxisValue120Event struct {
+	axis     uint32
+	value120 int32
+}
+
+// DecodeWlPointerAxisValue120Event decodes the body of wl_pointer.axis_value120
+func DecodeWlPointerAxisValue120Event(body []byte) (WlPointerAxisValue120Event, error) {
+	e := WlPointerAxisValue120Event{}
+	r := waylandReader{data: body}
+	var err error
+	if e.axis, err = r.uint(); err != nil {
+		return e, decodeError("wl_pointer.axis_value120", "axis", err)
+	}
+	if e.value120, err = r.int(); err != nil {
+		return e, decodeError("wl_pointer.axis_value120", "value120", err)
+	}
+	return e, nil
+}
+
+// wl_pointer.axis_relative_direction
+type WlPointerAxisRelativeDirectionEvent struct {
+	axis      uint32
+	direction uint32
+}
+
+// DecodeWlPointerAxisRelativeDirectionEvent decodes the body of wl_pointer.axis_relative_direction
+func DecodeWlPointerAxisRelativeDirectionEvent(body []byte) (WlPointerAxisRelativeDirectionEvent, error) {
+	e := WlPointerAxisRelativeDirectionEvent{}
+	r := waylandReader{data: body}
+	var err error
+	if e.axis, err = r.uint(); err != nil {
+		return e, decodeError("wl_pointer.axis_relative_direction", "axis", err)
+	}
+	if e.direction, err = r.uint(); err != nil {
+		return e, decodeError("wl_pointer.axis_relative_direction", "direction", err)
+	}
+	return e, nil
+}
+
+var wlPointerInterface = waylandInterfaceInfo{
+	name:    "wl_pointer",
+	version: 9,
+	requests: []waylandMessageInfo{
+		{name: "set_cursor", since: 1, args: []waylandArgInfo{
+			{name: "serial", typ: waylandArgUint},
+			{name: "surface", typ: waylandArgObject, iface: "wl_surface", allowNull: true},
+			{name: "hotspot_x", typ: waylandArgInt},
+			{name: "hotspot_y", typ: waylandArgInt},
+		}},
+		{name: "release", since: 3},
+	},
+	events: []waylandMessageInfo{
+		{name: "enter", since: 1, args: []waylandArgInfo{
+			{name: "serial", typ: waylandArgUint},
+			{name: "surface", typ: waylandArgObject, iface: "wl_surface"},
+			{name: "surface_x", typ: waylandArgFixed},
+			{name: "surface_y", typ: waylandArgFixed},
+		}},
+		{name: "leave", since: 1, args: []waylandArgInfo{
+			{name: "serial", typ: waylandArgUint},
+			{name: "surface", typ: waylandArgObject, iface: "wl_surface"},
+		}},
+		{name: "motion", since: 1, args: []waylandArgInfo{
+			{name: "time", typ: waylandArgUint},
+			{name: "surface_x", typ: waylandArgFixed},
+			{name: "surface_y", typ: waylandArgFixed},
+		}},
+		{name: "button", since: 1, args: []waylandArgInfo{
+			{name: "serial", typ: waylandArgUint},
+			{name: "time", typ: waylandArgUint},
+			{name: "button", typ: waylandArgUint},
+			{name: "state", typ: waylandArgUint},
+		}},
+		{name: "axis", since: 1, args: []waylandArgInfo{
+			{name: "time", typ: waylandArgUint},
+			{name: "axis", typ: waylandArgUint},
+			{name: "value", typ: waylandArgFixed},
+		}},
+		{name: "frame", since: 5},
+		{name: "axis_source", since: 5, args: []waylandArgInfo{
+			{name: "axis_source", typ: waylandArgUint},
+		}},
+		{name: "axis_stop", since: 5, args: []waylandArgInfo{
+			{name: "time", typ: waylandArgUint},
+			{name: "axis", typ: waylandArgUint},
+		}},
+		{name: "axis_discrete", since: 5, args: []waylandArgInfo{
+			{name: "axis", typ: waylandArgUint},
+			{name: "discrete", typ: waylandArgInt},
+		}},
+		{name: "axis_value120", since: 8, args: []waylandArgInfo{
+			{name: "axis", typ: waylandArgUint},
+			{name: "value120", typ: waylandArgInt},
+		}},
+		{name: "axis_relative_direction", since: 9, args: []waylandArgInfo{
+			{name: "axis", typ: waylandArgUint},
+			{name: "direction", typ: waylandArgUint},
+		}},
+	},
+	errors: map[uint32]string{
+		0: "role",
+	},
+}
+
+// wl_keyboard version 9
+
+const (
+	waylandWlKeyboardReleaseOpcode   uint16 = 0
+	waylandWlKeyboardEventKeymap     uint16 = 0
+	waylandWlKeyboardEventEnter      uint16 = 1
+	waylandWlKeyboardEventLeave      uint16 = 2
+	waylandWlKeyboardEventKey        uint16 = 3
+	waylandWlKeyboardEventModifiers  uint16 = 4
+	waylandWlKeyboardEventRepeatInfo uint16 = 5
+)
+
+// wl_keyboard.keymap_format
+const (
+	waylandWlKeyboardKeymapFormatNoKeymap uint32 = 0
+	waylandWlKeyboardKeymapFormatXkbV1    uint32 = 1
+)
+
+// wl_keyboard.key_state
+const (
+	waylandWlKeyboardKeyStateReleased uint32 = 0
+	waylandWlKeyboardKeyStatePressed  uint32 = 1
+)
+
+// WlKeyboardRelease sends wl_keyboard.release
+func (c *WaylandConn) WlKeyboardRelease(wlKeyboard uint32) {
+	slog.Debug("request wl_keyboard.release")
+	w := waylandWriter{}
+	c.request(wlKeyboard, waylandWlKeyboardReleaseOpcode, w.data, w.fds...)
+}
+
+// wl_keyboard.keymap
+type WlKeyboardKeymapEvent struct {
+	format uint32
+	fd     int
+	size   uint32
+}
+
+// DecodeWlKeyboardKeymapEvent decodes the body of wl_keyboard.keymap. File descriptors the event carries are taken from fds.
+func DecodeWlKeyboardKeymapEvent(body []byte, fds waylandFdSource) (WlKeyboardKeymapEvent, error) {
+	e := WlKeyboardKeymapEvent{}
+	r := waylandReader{data: body, fds: fds}
+	var err error
+	if e.format, err = r.uint(); err != nil {
+		return e, decodeError("wl_keyboard.keymap", "format", err)
+	}
+	if e.fd, err = r.fd(); err != nil {
+		return e, decodeError("wl_keyboard.keymap", "fd", err)
+	}
+	if e.size, err = r.uint(); err != nil {
+		return e, decodeError("wl_keyboard.keymap", "size", err)
+	}
+	return e, nil
+}
+
+// wl_keyboard.enter
+type WlKeyboardEnterEvent struct {
+	serial  uint32
+	surface uint32
+	keys    []byte
+}
+
+// DecodeWlKeyboardEnterEvent decodes the body of wl_keyboard.enter
+func DecodeWlKeyboardEnterEvent(body []byte) (WlKeyboardEnterEvent, error) {
+	e := WlKeyboardEnterEvent{}
+	r := waylandReader{data: body}
+	var err error
+	if e.serial, err = r.uint(); err != nil {
+		return e, decodeError("wl_keyboard.enter", "serial", err)
+	}
+	if e.surface, err = r.uint(); err != nil {
+		return e, decodeError("wl_keyboard.enter", "surface", err)
+	}
+	if e.keys, err = r.array(); err != nil {
+		return e, decodeError("wl_keyboard.enter", "keys", err)
+	}
+	return e, nil
+}
+
+// wl_keyboard.leave
+type WlKeyboardLeaveEvent struct {
+	serial  uint32
+	surface uint32
+}
+
+// DecodeWlKeyboardLeaveEvent decodes the body of wl_keyboard.leave
+func DecodeWlKeyboardLeaveEvent(body []byte) (WlKeyboardLeaveEvent, error) {
+	e := WlKeyboardLeaveEvent{}
+	r := waylandReader{data: body}
+	var err error
+	if e.serial, err = r.uint(); err != nil {
+		return e, decodeError("wl_keyboard.leave", "serial", err)
+	}
+	if e.surface, err = r.uint(); err != nil {
+		return e, decodeError("wl_keyboard.leave", "surface", err)
+	}
+	return e, nil
+}
+
+// wl_keyboard.key
+type WlKeyboardKeyEvent struct {
+	serial uint32
+	time   uint32
+	key    uint32
+	state  uint32
+}
+
+// DecodeWlKeyboardKeyEvent decodes the body of wl_keyboard.key
+func DecodeWlKeyboardKeyEvent(body []byte) (WlKeyboardKeyEvent, error) {
+	e := WlKeyboardKeyEvent{}
+	r := waylandReader{data: body}
+	var err error
+	if e.serial, err = r.uint(); err != nil {
+		return e, decodeError("wl_keyboard.key", "serial", err)
+	}
+	if e.time, err = r.uint(); err != nil {
+		return e, decodeError("wl_keyboard.key", "time", err)
+	}
+	if e.key, err = r.uint(); err != nil {
+		return e, decodeError("wl_keyboard.key", "key", err)
+	}
+	if e.state, err = r.uint(); err != nil {
+		return e, decodeError("wl_keyboard.key", "state", err)
+	}
+	return e, nil
+}
+
+// wl_keyboard.modifiers
+type WlKeyboardModifiersEvent struct {
+	serial        uint32
+	modsDepressed uint32
+	modsLatched   uint32
+	modsLocked    uint32
+	group         uint32
+}
+
+// DecodeWlKeyboardModifiersEvent decodes the body of wl_keyboard.modifiers
+func DecodeWlKeyboardModifiersEvent(body []byte) (WlKeyboardModifiersEvent, error) {
+	e := WlKeyboardModifiersEvent{}
+	r := waylandReader{data: body}
+	var err error
+	if e.serial, err = r.uint(); err != nil {
+		return e, decodeError("wl_keyboard.modifiers", "serial", err)
+	}
+	if e.modsDepressed, err = r.uint(); err != nil {
+		return e, decodeError("wl_keyboard.modifiers", "mods_depressed", err)
+	}
+	if e.modsLatched, err = r.uint(); err != nil {
+		return e, decodeError("wl_keyboard.modifiers", "mods_latched", err)
+	}
+	if e.modsLocked, err = r.uint(); err != nil {
+		return e, decodeError("wl_keyboard.modifiers", "mods_locked", err)
+	}
+	if e.group, err = r.uint(); err != nil {
+		return e, decodeError("wl_keyboard.modifiers", "group", err)
+	}
+	return e, nil
+}
+
+// wl_keyboard.repeat_info
+type WlKeyboardRepeatInfoEvent struct {
+	rate  int32
+	delay int32
+}
+
+// DecodeWlKeyboardRepeatInfoEvent decodes the body of wl_keyboard.repeat_info
+func DecodeWlKeyboardRepeatInfoEvent(body []byte) (WlKeyboardRepeatInfoEvent, error) {
+	e := WlKeyboardRepeatInfoEvent{}
+	r := waylandReader{data: body}
+	var err error
+	if e.rate, err = r.int(); err != nil {
+		return e, decodeError("wl_keyboard.repeat_info", "rate", err)
+	}
+	if e.delay, err = r.int(); err != nil {
+		return e, decodeError("wl_keyboard.repeat_info", "delay", err)
+	}
+	return e, nil
+}
+
+var wlKeyboardInterface = waylandInterfaceInfo{
+	name:    "wl_keyboard",
+	version: 9,
+	requests: []waylandMessageInfo{
+		{name: "release", since: 3},
+	},
+	events: []waylandMessageInfo{
+		{name: "keymap", since: 1, args: []waylandArgInfo{
+			{name: "format", typ: waylandArgUint},
+			{name: "fd", typ: waylandArgFd},
+			{name: "size", typ: waylandArgUint},
+		}},
+		{name: "enter", since: 1, args: []waylandArgInfo{
+			{name: "serial", typ: waylandArgUint},
+			{name: "surface", typ: waylandArgObject, iface: "wl_surface"},
+			{name: "keys", typ: waylandArgArray},
+		}},
+		{name: "leave", since: 1, args: []waylandArgInfo{
+			{name: "serial", typ: waylandArgUint},
+			{name: "surface", typ: waylandArgObject, iface: "wl_surface"},
+		}},
+		{name: "key", since: 1, args: []waylandArgInfo{
+			{name: "serial", typ: waylandArgUint},
+			{name: "time", typ: waylandArgUint},
+			{name: "key", typ: waylandArgUint},
+			{name: "state", typ: waylandArgUint},
+		}},
+		{name: "modifiers", since: 1, args: []waylandArgInfo{
+			{name: "serial", typ: waylandArgUint},
+			{name: "mods_depressed", typ: waylandArgUint},
+			{name: "mods_latched", typ: waylandArgUint},
+			{name: "mods_locked", typ: waylandArgUint},
+			{name: "group", typ: waylandArgUint},
+		}},
+		{name: "repeat_info", since: 4, args: []waylandArgInfo{
+			{name: "rate", typ: waylandArgInt},
+			{name: "delay", typ: waylandArgInt},
+		}},
+	},
+}
+
+// wl_output version 4
+
+const (
+	waylandWlOutputReleaseOpcode    uint16 = 0
+	waylandWlOutputEventGeometry    uint16 = 0
+	waylandWlOutputEventMode        uint16 = 1
+	waylandWlOutputEventDone        uint16 = 2
+	waylandWlOutputEventScale       uint16 = 3
+	waylandWlOutputEventName        uint16 = 4
+	waylandWlOutputEventDescription uint16 = 5
+)
+
+// wl_output.subpixel
+const (
+	waylandWlOutputSubpixelUnknown       uint32 = 0
+	waylandWlOutputSubpixelNone          uint32 = 1
+	waylandWlOutputSubpixelHorizontalRgb uint32 = 2
+	waylandWlOutputSubpixelHorizontalBgr uint32 = 3
+	waylandWlOutputSubpixelVerticalRgb   uint32 = 4
+	waylandWlOutputSubpixelVerticalBgr   uint32 = 5
+)
+
+// wl_output.transform
+const (
+	waylandWlOutputTransformNormal     uint32 = 0
+	waylandWlOutputTransform90         uint32 = 1
+	waylandWlOutputTransform180        uint32 = 2
+	waylandWlOutputTransform270        uint32 = 3
+	waylandWlOutputTransformFlipped    uint32 = 4
+	waylandWlOutputTransformFlipped90  uint32 = 5
+	waylandWlOutputTransformFlipped180 uint32 = 6
+	waylandWlOutputTransformFlipped270 uint32 = 7
+)
+
+// wl_output.mode
+const (
+	waylandWlOutputModeCurrent   uint32 = 0x1
+	waylandWlOutputModePreferred uint32 = 0x2
+)
+
+// WlOutputRelease sends wl_output.release
+func (c *WaylandConn) WlOutputRelease(wlOutput uint32) {
+	slog.Debug("request wl_output.release")
+	w := waylandWriter{}
+	c.request(wlOutput, waylandWlOutputReleaseOpcode, w.data, w.fds...)
+}
+
+// wl_output.geometry
+type WlOutputGeometryEvent struct {
+	x              int32
+	y              int32
+	physicalWidth  int32
+	physicalHeight int32
+	subpixel       int32
+	make           string
+	model          string
+	transform      int32
+}
+
+// DecodeWlOutputGeometryEvent decodes the body of wl_output.geometry
+func DecodeWlOutputGeometryEvent(body []byte) (WlOutputGeometryEvent, error) {
+	e := WlOutputGeometryEvent{}
+	r := waylandReader{data: body}
+	var err error
+	if e.x, err = r.int(); err != nil {
+		return e, decodeError("wl_output.geometry", "x", err)
+	}
+	if e.y, err = r.int(); err != nil {
+		return e, decodeError("wl_output.geometry", "y", err)
+	}
+	if e.physicalWidth, err = r.int(); err != nil {
+		return e, decodeError("wl_output.geometry", "physical_width", err)
+	}
+	if e.physicalHeight, err = r.int(); err != nil {
+		return e, decodeError("wl_output.geometry", "physical_height", err)
+	}
+	if e.subpixel, err = r.int(); err != nil {
+		return e, decodeError("wl_output.geometry", "subpixel", err)
+	}
+	if e.make, err = r.string(); err != nil {
+		return e, decodeError("wl_output.geometry", "make", err)
+	}
+	if e.model, err = r.string(); err != nil {
+		return e, decodeError("wl_output.geometry", "model", err)
+	}
+	if e.transform, err = r.int(); err != nil {
+		return e, decodeError("wl_output.geometry", "transform", err)
+	}
+	return e, nil
+}
+
+// wl_output.mode
+type WlOutputModeEvent struct {
+	flags   uint32
+	width   int32
+	height  int32
+	refresh int32
+}
+
+// DecodeWlOutputModeEvent decodes the body of wl_output.mode
+func DecodeWlOutputModeEvent(body []byte) (WlOutputModeEvent, error) {
+	e := WlOutputModeEvent{}
+	r := waylandReader{data: body}
+	var err error
+	if e.flags, err = r.uint(); err != nil {
+		return e, decodeError("wl_output.mode", "flags", err)
+	}
+	if e.width, err = r.int(); err != nil {
+		return e, decodeError("wl_output.mode", "width", err)
+	}
+	if e.height, err = r.int(); err != nil {
+		return e, decodeError("wl_output.mode", "height", err)
+	}
+	if e.refresh, err = r.int(); err != nil {
+		return e, decodeError("wl_output.mode", "refresh", err)
+	}
+	return e, nil
+}
+
+// wl_output.done
+type WlOutputDoneEvent struct {
+}
+
+// DecodeWlOutputDoneEvent decodes the body of wl_output.done
+func DecodeWlOutputDoneEvent(body []byte) (WlOutputDoneEvent, error) {
+	e := WlOutputDoneEvent{}
+	return e, nil
+}
+
+// wl_output.scale
+type WlOutputScaleEvent struct {
+	factor int32
+}
+
+// DecodeWlOutputScaleEvent decodes the body of wl_output.scale
+func DecodeWlOutputScaleEvent(body []byte) (WlOutputScaleEvent, error) {
+	e := WlOutputScaleEvent{}
+	r := waylandReader{data: body}
+	var err error
+	if e.factor, err = r.int(); err != nil {
+		return e, decodeError("wl_output.scale", "factor", err)
+	}
+	return e, nil
+}
+
+// wl_output.name
+type WlOutputNameEvent struct {
+	name string
+}
+
+// DecodeWlOutputNameEvent decodes the body of wl_output.name
+func DecodeWlOutputNameEvent(body []byte) (WlOutputNameEvent, error) {
+	e := WlOutputNameEvent{}
+	r := waylandReader{data: body}
+	var err error
+	if e.name, err = r.string(); err != nil {
+		return e, decodeError("wl_output.name", "name", err)
+	}
+	return e, nil
+}
+
+// wl_output.description
+type WlOutputDescriptionEvent struct {
+	description string
+}
+
+// DecodeWlOutputDescriptionEvent decodes the body of wl_output.description
+func DecodeWlOutputDescriptionEvent(body []byte) (WlOutputDescriptionEvent, error) {
+	e := WlOutputDescriptionEvent{}
+	r := waylandReader{data: body}
+	var err error
+	if e.description, err = r.string(); err != nil {
+		return e, decodeError("wl_output.description", "description", err)
+	}
+	return e, nil
+}
+
+var wlOutputInterface = waylandInterfaceInfo{
+	name:    "wl_output",
+	version: 4,
+	requests: []waylandMessageInfo{
+		{name: "release", since: 3},
+	},
+	events: []waylandMessageInfo{
+		{name: "geometry", since: 1, args: []waylandArgInfo{
+			{name: "x", typ: waylandArgInt},
+			{name: "y", typ: waylandArgInt},
+			{name: "physical_width", typ: waylandArgInt},
+			{name: "physical_height", typ: waylandArgInt},
+			{name: "subpixel", typ: waylandArgInt},
+			{name: "make", typ: waylandArgString},
+			{name: "model", typ: waylandArgString},
+			{name: "transform", typ: waylandArgInt},
+		}},
+		{name: "mode", since: 1, args: []waylandArgInfo{
+			{name: "flags", typ: waylandArgUint},
+			{name: "width", typ: waylandArgInt},
+			{name: "height", typ: waylandArgInt},
+			{name: "refresh", typ: waylandArgInt},
+		}},
+		{name: "done", since: 2},
+		{name: "scale", since: 2, args: []waylandArgInfo{
+			{name: "factor", typ: waylandArgInt},
+		}},
+		{name: "name", since: 4, args: []waylandArgInfo{
+			{name: "name", typ: waylandArgString},
+		}},
+		{name: "description", since: 4, args: []waylandArgInfo{
+			{name: "description", typ: waylandArgString},
+		}},
+	},
+}
+
+// wl_region version 1
+
+const (
+	waylandWlRegionDestroyOpcode  uint16 = 0
+	waylandWlRegionAddOpcode      uint16 = 1
+	waylandWlRegionSubtractOpcode uint16 = 2
+)
+
+// WlRegionDestroy sends wl_region.destroy
+func (c *WaylandConn) WlRegionDestroy(wlRegion uint32) {
+	slog.Debug("request wl_region.destroy")
+	w := waylandWriter{}
+	c.request(wlRegion, waylandWlRegionDestroyOpcode, w.data, w.fds...)
+}
+
+// WlRegionAdd sends wl_region.add
+func (c *WaylandConn) WlRegionAdd(wlRegion uint32, x int32, y int32, width int32, height int32) {
+	slog.Debug("request wl_region.add")
+	w := waylandWriter{}
+	w.putInt(x)
+	w.putInt(y)
+	w.putInt(width)
+	w.putInt(height)
+	c.request(wlRegion, waylandWlRegionAddOpcode, w.data, w.fds...)
+}
+
+// WlRegionSubtract sends wl_region.subtract
+func (c *WaylandConn) WlRegionSubtract(wlRegion uint32, x int32, y int32, width int32, height int32) {
+	slog.Debug("request wl_region.subtract")
+	w := waylandWriter{}
+	w.putInt(x)
+	w.putInt(y)
+	w.putInt(width)
+	w.putInt(height)
+	c.request(wlRegion, waylandWlRegionSubtractOpcode, w.data, w.fds...)
+}
+
+var wlRegionInterface = waylandInterfaceInfo{
+	name:    "wl_region",
+	version: 1,
+	requests: []waylandMessageInfo{
+		{name: "destroy", since: 1},
+		{name: "add", since: 1, args: []waylandArgInfo{
+			{name: "x", typ: waylandArgInt},
+			{name: "y", typ: waylandArgInt},
+			{name: "width", typ: waylandArgInt},
+			{name: "height", typ: waylandArgInt},
+		}},
+		{name: "subtract", since: 1, args: []waylandArgInfo{
+			{name: "x", typ: waylandArgInt},
+			{name: "y", typ: waylandArgInt},
+			{name: "width", typ: waylandArgInt},
+			{name: "height", typ: waylandArgInt},
+		}},
+	},
+}
+
+// xdg_wm_base version 6
+
+const (
+	waylandXdgWmBaseDestroyOpcode          uint16 = 0
+	waylandXdgWmBaseCreatePositionerOpcode uint16 = 1
+	waylandXdgWmBaseGetXdgSurfaceOpcode    uint16 = 2
+	waylandXdgWmBasePongOpcode             uint16 = 3
+	waylandXdgWmBaseEventPing              uint16 = 0
+)
+
+// xdg_wm_base.error
+const (
+	waylandXdgWmBaseErrorRole                uint32 = 0
+	waylandXdgWmBaseErrorDefunctSurfaces     uint32 = 1
+	waylandXdgWmBaseErrorNotTheTopmostPopup  uint32 = 2
+	waylandXdgWmBaseErrorInvalidPopupParent  uint32 = 3
+	waylandXdgWmBaseErrorInvalidSurfaceState uint32 = 4
+	waylandXdgWmBaseErrorInvalidPositioner   uint32 = 5
+	waylandXdgWmBaseErrorUnresponsive        uint32 = 6
+)
+
+// XdgWmBaseDestroy sends xdg_wm_base.destroy
+func (c *WaylandConn) XdgWmBaseDestroy(xdgWmBase uint32) {
+	slog.Debug("request xdg_wm_base.destroy")
+	w := waylandWriter{}
+	c.request(xdgWmBase, waylandXdgWmBaseDestroyOpcode, w.data, w.fds...)
+}
+
+// XdgWmBaseCreatePositioner sends xdg_wm_base.create_positioner and returns an id of the created object
+func (c *WaylandConn) XdgWmBaseCreatePositioner(xdgWmBase uint32) uint32 {
+	slog.Debug("request xdg_wm_base.create_positioner")
+	id := c.newId("xdg_positioner")
+	w := waylandWriter{}
+	w.putUint(id)
+	c.request(xdgWmBase, waylandXdgWmBaseCreatePositionerOpcode, w.data, w.fds...)
+	return id
+}
+
+// XdgWmBaseGetXdgSurface sends xdg_wm_base.get_xdg_surface and returns an id of the created object
+func (c *WaylandConn) XdgWmBaseGetXdgSurface(xdgWmBase uint32, surface uint32) uint32 {
+	slog.Debug("request xdg_wm_base.get_xdg_surface")
+	id := c.newId("xdg_surface")
+	w := waylandWriter{}
+	w.putUint(id)
+	w.putUint(surface)
+	c.request(xdgWmBase, waylandXdgWmBaseGetXdgSurfaceOpcode, w.data, w.fds...)
+	return id
+}
+
+// XdgWmBasePong sends xdg_wm_base.pong
+func (c *WaylandConn) XdgWmBasePong(xdgWmBase uint32, serial uint32) {
+	slog.Debug("request xdg_wm_base.pong")
+	w := waylandWriter{}
+	w.putUint(serial)
+	c.request(xdgWmBase, waylandXdgWmBasePongOpcode, w.data, w.fds...)
+}
+
+// xdg_wm_base.ping
+type XdgWmBasePingEvent struct {
+	serial uint32
+}
+
+// DecodeXdgWmBasePingEvent decodes the body of xdg_wm_base.ping
+func DecodeXdgWmBasePingEvent(body []byte) (XdgWmBasePingEvent, error) {
+	e := XdgWmBasePingEvent{}
+	r := waylandReader{data: body}
+	var err error
+	if e.serial, err = r.uint(); err != nil {
+		return e, decodeError("xdg_wm_base.ping", "serial", err)
+	}
+	return e, nil
+}
+
+var xdgWmBaseInterface = waylandInterfaceInfo{
+	name:    "xdg_wm_base",
+	version: 6,
+	requests: []waylandMessageInfo{
+		{name: "destroy", since: 1},
+		{name: "create_positioner", since: 1, args: []waylandArgInfo{
+			{name: "id", typ: waylandArgNewId, iface: "xdg_positioner"},
+		}},
+		{name: "get_xdg_surface", since: 1, args: []waylandArgInfo{
+			{name: "id", typ: waylandArgNewId, iface: "xdg_surface"},
+			{name: "surface", typ: waylandArgObject, iface: "wl_surface"},
+		}},
+		{name: "pong", since: 1, args: []waylandArgInfo{
+			{name: "serial", typ: waylandArgUint},
+		}},
+	},
+	events: []waylandMessageInfo{
+		{name: "ping", since: 1, args: []waylandArgInfo{
+			{name: "serial", typ: waylandArgUint},
+		}},
+	},
+	errors: map[uint32]string{
+		0: "role",
+		1: "defunct_surfaces",
+		2: "not_the_topmost_popup",
+		3: "invalid_popup_parent",
+		4: "invalid_surface_state",
+		5: "invalid_positioner",
+		6: "unresponsive",
+	},
+}
+
+// xdg_positioner version 6
+
+const (
+	waylandXdgPositionerDestroyOpcode                 uint16 = 0
+	waylandXdgPositionerSetSizeOpcode                 uint16 = 1
+	waylandXdgPositionerSetAnchorRectOpcode           uint16 = 2
+	waylandXdgPositionerSetAnchorOpcode               uint16 = 3
+	waylandXdgPositionerSetGravityOpcode              uint16 = 4
+	waylandXdgPositionerSetConstraintAdjustmentOpcode uint16 = 5
+	waylandXdgPositionerSetOffsetOpcode               uint16 = 6
+	waylandXdgPositionerSetReactiveOpcode             uint16 = 7
+	waylandXdgPositionerSetParentSizeOpcode           uint16 = 8
+	waylandXdgPositionerSetParentConfigureOpcode      uint16 = 9
+)
+
+// xdg_positioner.error
+const (
+	waylandXdgPositionerErrorInvalidInput uint32 = 0
+)
+
+// xdg_positioner.anchor
+const (
+	waylandXdgPositionerAnchorNone        uint32 = 0
+	waylandXdgPositionerAnchorTop         uint32 = 1
+	waylandXdgPositionerAnchorBottom      uint32 = 2
+	waylandXdgPositionerAnchorLeft        uint32 = 3
+	waylandXdgPositionerAnchorRight       uint32 = 4
+	waylandXdgPositionerAnchorTopLeft     uint32 = 5
+	waylandXdgPositionerAnchorBottomLeft  uint32 = 6
+	waylandXdgPositionerAnchorTopRight    uint32 = 7
+	waylandXdgPositionerAnchorBottomRight uint32 = 8
+)
+
+// xdg_positioner.gravity
+const (
+	waylandXdgPositionerGravityNone        uint32 = 0
+	waylandXdgPositionerGravityTop         uint32 = 1
+	waylandXdgPositionerGravityBottom      uint32 = 2
+	waylandXdgPositionerGravityLeft        uint32 = 3
+	waylandXdgPositionerGravityRight       uint32 = 4
+	waylandXdgPositionerGravityTopLeft     uint32 = 5
+	waylandXdgPositionerGravityBottomLeft  uint32 = 6
+	waylandXdgPositionerGravityTopRight    uint32 = 7
+	waylandXdgPositionerGravityBottomRight uint32 = 8
+)
+
+// xdg_positioner.constraint_adjustment
+const (
+	waylandXdgPositionerConstraintAdjustmentNone    uint32 = 0
+	waylandXdgPositionerConstraintAdjustmentSlideX  uint32 = 1
+	waylandXdgPositionerConstraintAdjustmentSlideY  uint32 = 2
+	waylandXdgPositionerConstraintAdjustmentFlipX   uint32 = 4
+	waylandXdgPositionerConstraintAdjustmentFlipY   uint32 = 8
+	waylandXdgPositionerConstraintAdjustmentResizeX uint32 = 16
+	waylandXdgPositionerConstraintAdjustmentResizeY uint32 = 32
+)
+
+// XdgPositionerDestroy sends xdg_positioner.destroy
+func (c *WaylandConn) XdgPositionerDestroy(xdgPositioner uint32) {
+	slog.Debug("request xdg_positioner.destroy")
+	w := waylandWriter{}
+	c.request(xdgPositioner, waylandXdgPositionerDestroyOpcode, w.data, w.fds...)
+}
+
+// XdgPositionerSetSize sends xdg_positioner.set_size
+func (c *WaylandConn) XdgPositionerSetSize(xdgPositioner uint32, width int32, height int32) {
+	slog.Debug("request xdg_positioner.set_size")
+	w := waylandWriter{}
+	w.putInt(width)
+	w.putInt(height)
+	c.request(xdgPositioner, waylandXdgPositionerSetSizeOpcode, w.data, w.fds...)
+}
+
+// XdgPositionerSetAnchorRect sends xdg_positioner.set_anchor_rect
+func (c *WaylandConn) XdgPositionerSetAnchorRect(xdgPositioner uint32, x int32, y int32, width int32, height int32) {
+	slog.Debug("request xdg_positioner.set_anchor_rect")
+	w := waylandWriter{}
+	w.putInt(x)
+	w.putInt(y)
+	w.putInt(width)
+	w.putInt(height)
+	c.request(xdgPositioner, waylandXdgPositionerSetAnchorRectOpcode, w.data, w.fds...)
+}
+
+// XdgPositionerSetAnchor sends xdg_positioner.set_anchor
+func (c *WaylandConn) XdgPositionerSetAnchor(xdgPositioner uint32, anchor uint32) {
+	slog.Debug("request xdg_positioner.set_anchor")
+	w := waylandWriter{}
+	w.putUint(anchor)
+	c.request(xdgPositioner, waylandXdgPositionerSetAnchorOpcode, w.data, w.fds...)
+}
+
+// XdgPositionerSetGravity sends xdg_positioner.set_gravity
+func (c *WaylandConn) XdgPositionerSetGravity(xdgPositioner uint32, gravity uint32) {
+	slog.Debug("request xdg_positioner.set_gravity")
+	w := waylandWriter{}
+	w.putUint(gravity)
+	c.request(xdgPositioner, waylandXdgPositionerSetGravityOpcode, w.data, w.fds...)
+}
+
+// XdgPositionerSetConstraintAdjustment sends xdg_positioner.set_constraint_adjustment
+func (c *WaylandConn) XdgPositionerSetConstraintAdjustment(xdgPositioner uint32, constraintAdjustment uint32) {
+	slog.Debug("request xdg_positioner.set_constraint_adjustment")
+	w := waylandWriter{}
+	w.putUint(constraintAdjustment)
+	c.request(xdgPositioner, waylandXdgPositionerSetConstraintAdjustmentOpcode, w.data, w.fds...)
+}
+
+// XdgPositionerSetOffset sends xdg_positioner.set_offset
+func (c *WaylandConn) XdgPositionerSetOffset(xdgPositioner uint32, x int32, y int32) {
+	slog.Debug("request xdg_positioner.set_offset")
+	w := waylandWriter{}
+	w.putInt(x)
+	w.putInt(y)
+	c.request(xdgPositioner, waylandXdgPositionerSetOffsetOpcode, w.data, w.fds...)
+}
+
+// XdgPositionerSetReactive sends xdg_positioner.set_reactive
+func (c *WaylandConn) XdgPositionerSetReactive(xdgPositioner uint32) {
+	slog.Debug("request xdg_positioner.set_reactive")
+	w := waylandWriter{}
+	c.request(xdgPositioner, waylandXdgPositionerSetReactiveOpcode, w.data, w.fds...)
+}
+
+// XdgPositionerSetParentSize sends xdg_positioner.set_parent_size
+func (c *WaylandConn) XdgPositionerSetParentSize(xdgPositioner uint32, parentWidth int32, parentHeight int32) {
+	slog.Debug("request xdg_positioner.set_parent_size")
+	w := waylandWriter{}
+	w.putInt(parentWidth)
+	w.putInt(parentHeight)
+	c.request(xdgPositioner, waylandXdgPositionerSetParentSizeOpcode, w.data, w.fds...)
+}
+
+// XdgPositionerSetParentConfigure sends xdg_positioner.set_parent_configure
+func (c *WaylandConn) XdgPositionerSetParentConfigure(xdgPositioner uint32, serial uint32) {
+	slog.Debug("request xdg_positioner.set_parent_configure")
+	w := waylandWriter{}
+	w.putUint(serial)
+	c.request(xdgPositioner, waylandXdgPositionerSetParentConfigureOpcode, w.data, w.fds...)
+}
+
+var xdgPositionerInterface = waylandInterfaceInfo{
+	name:    "xdg_positioner",
+	version: 6,
+	requests: []waylandMessageInfo{
+		{name: "destroy", since: 1},
+		{name: "set_size", since: 1, args: []waylandArgInfo{
+			{name: "width", typ: waylandArgInt},
+			{name: "height", typ: waylandArgInt},
+		}},
+		{name: "set_anchor_rect", since: 1, args: []waylandArgInfo{
+			{name: "x", typ: waylandArgInt},
+			{name: "y", typ: waylandArgInt},
+			{name: "width", typ: waylandArgInt},
+			{name: "height", typ: waylandArgInt},
+		}},
+		{name: "set_anchor", since: 1, args: []waylandArgInfo{
+			{name: "anchor", typ: waylandArgUint},
+		}},
+		{name: "set_gravity", since: 1, args: []waylandArgInfo{
+			{name: "gravity", typ: waylandArgUint},
+		}},
+		{name: "set_constraint_adjustment", since: 1, args: []waylandArgInfo{
+			{name: "constraint_adjustment", typ: waylandArgUint},
+		}},
+		{name: "set_offset", since: 1, args: []waylandArgInfo{
+			{name: "x", typ: waylandArgInt},
+			{name: "y", typ: waylandArgInt},
+		}},
+		{name: "set_reactive", since: 3},
+		{name: "set_parent_size", since: 3, args: []waylandArgInfo{
+			{name: "parent_width", typ: waylandArgInt},
+			{name: "parent_height", typ: waylandArgInt},
+		}},
+		{name: "set_parent_configure", since: 3, args: []waylandArgInfo{
+			{name: "serial", typ: waylandArgUint},
+		}},
+	},
+	errors: map[uint32]string{
+		0: "invalid_input",
+	},
+}
+
+// xdg_surface version 6
+
+const (
+	waylandXdgSurfaceDestroyOpcode           uint16 = 0
+	waylandXdgSurfaceGetToplevelOpcode       uint16 = 1
+	waylandXdgSurfaceGetPopupOpcode          uint16 = 2
+	waylandXdgSurfaceSetWindowGeometryOpcode uint16 = 3
+	waylandXdgSurfaceAckConfigureOpcode      uint16 = 4
+	waylandXdgSurfaceEventConfigure          uint16 = 0
+)
+
+// xdg_surface.error
+const (
+	waylandXdgSurfaceErrorNotConstructed     uint32 = 1
+	waylandXdgSurfaceErrorAlreadyConstructed uint32 = 2
+	waylandXdgSurfaceErrorUnconfiguredBuffer uint32 = 3
+	waylandXdgSurfaceErrorInvalidSerial      uint32 = 4
+	waylandXdgSurfaceErrorInvalidSize        uint32 = 5
+	waylandXdgSurfaceErrorDefunctRoleObject  uint32 = 6
+)
+
+// XdgSurfaceDestroy sends xdg_surface.destroy
+func (c *WaylandConn) XdgSurfaceDestroy(xdgSurface uint32) {
+	slog.Debug("request xdg_surface.destroy")
+	w := waylandWriter{}
+	c.request(xdgSurface, waylandXdgSurfaceDestroyOpcode, w.data, w.fds...)
+}
+
+// XdgSurfaceGetToplevel sends xdg_surface.get_toplevel and returns an id of the created object
+func (c *WaylandConn) XdgSurfaceGetToplevel(xdgSurface uint32) uint32 {
+	slog.Debug("request xdg_surface.get_toplevel")
+	id := c.newId("xdg_toplevel")
+	w := waylandWriter{}
+	w.putUint(id)
+	c.request(xdgSurface, waylandXdgSurfaceGetToplevelOpcode, w.data, w.fds...)
+	return id
+}
+
+// XdgSurfaceGetPopup sends xdg_surface.get_popup and returns an id of the created object
+func (c *WaylandConn) XdgSurfaceGetPopup(xdgSurface uint32, parent uint32, positioner uint32) uint32 {
+	slog.Debug("request xdg_surface.get_popup")
+	id := c.newId("xdg_popup")
+	w := waylandWriter{}
+	w.putUint(id)
+	w.putUint(parent)
+	w.putUint(positioner)
+	c.request(xdgSurface, waylandXdgSurfaceGetPopupOpcode, w.data, w.fds...)
+	return id
+}
+
+// XdgSurfaceSetWindowGeometry sends xdg_surface.set_window_geometry
+func (c *WaylandConn) XdgSurfaceSetWindowGeometry(xdgSurface uint32, x int32, y int32, width int32, height int32) {
+	slog.Debug("request xdg_surface.set_window_geometry")
+	w := waylandWriter{}
+	w.putInt(x)
+	w.putInt(y)
+	w.putInt(width)
+	w.putInt(height)
+	c.request(xdgSurface, waylandXdgSurfaceSetWindowGeometryOpcode, w.data, w.fds...)
+}
+
+// XdgSurfaceAckConfigure sends xdg_surface.ack_configure
+func (c *WaylandConn) XdgSurfaceAckConfigure(xdgSurface uint32, serial uint32) {
+	slog.Debug("request xdg_surface.ack_configure")
+	w := waylandWriter{}
+	w.putUint(serial)
+	c.request(xdgSurface, waylandXdgSurfaceAckConfigureOpcode, w.data, w.fds...)
+}
+
+// xdg_surface.configure
+type XdgSurfaceConfigureEvent struct {
+	serial uint32
+}
+
+// DecodeXdgSurfaceConfigureEvent decodes the body of xdg_surface.configure
+func DecodeXdgSurfaceConfigureEvent(body []byte) (XdgSurfaceConfigureEvent, error) {
+	e := XdgSurfaceConfigureEvent{}
+	r := waylandReader{data: body}
+	var err error
+	if e.serial, err = r.uint(); err != nil {
+		return e, decodeError("xdg_surface.configure", "serial", err)
+	}
+	return e, nil
+}
+
+var xdgSurfaceInterface = waylandInterfaceInfo{
+	name:    "xdg_surface",
+	version: 6,
+	requests: []waylandMessageInfo{
+		{name: "destroy", since: 1},
+		{name: "get_toplevel", since: 1, args: []waylandArgInfo{
+			{name: "id", typ: waylandArgNewId, iface: "xdg_toplevel"},
+		}},
+		{name: "get_popup", since: 1, args: []waylandArgInfo{
+			{name: "id", typ: waylandArgNewId, iface: "xdg_popup"},
+			{name: "parent", typ: waylandArgObject, iface: "xdg_surface", allowNull: true},
+			{name: "positioner", typ: waylandArgObject, iface: "xdg_positioner"},
+		}},
+		{name: "set_window_geometry", since: 1, args: []waylandArgInfo{
+			{name: "x", typ: waylandArgInt},
+			{name: "y", typ: waylandArgInt},
+			{name: "width", typ: waylandArgInt},
+			{name: "height", typ: waylandArgInt},
+		}},
+		{name: "ack_configure", since: 1, args: []waylandArgInfo{
+			{name: "serial", typ: waylandArgUint},
+		}},
+	},
+	events: []waylandMessageInfo{
+		{name: "configure", since: 1, args: []waylandArgInfo{
+			{name: "serial", typ: waylandArgUint},
+		}},
+	},
+	errors: map[uint32]string{
+		1: "not_constructed",
+		2: "already_constructed",
+		3: "unconfigured_buffer",
+		4: "invalid_serial",
+		5: "invalid_size",
+		6: "defunct_role_object",
+	},
+}
+
+// xdg_toplevel version 6
+
+const (
+	waylandXdgToplevelDestroyOpcode         uint16 = 0
+	waylandXdgToplevelSetParentOpcode       uint16 = 1
+	waylandXdgToplevelSetTitleOpcode        uint16 = 2
+	waylandXdgToplevelSetAppIdOpcode        uint16 = 3
+	waylandXdgToplevelShowWindowMenuOpcode  uint16 = 4
+	waylandXdgToplevelMoveOpcode            uint16 = 5
+	waylandXdgToplevelResizeOpcode          uint16 = 6
+	waylandXdgToplevelSetMaxSizeOpcode      uint16 = 7
+	waylandXdgToplevelSetMinSizeOpcode      uint16 = 8
+	waylandXdgToplevelSetMaximizedOpcode    uint16 = 9
+	waylandXdgToplevelUnsetMaximizedOpcode  uint16 = 10
+	waylandXdgToplevelSetFullscreenOpcode   uint16 = 11
+	waylandXdgToplevelUnsetFullscreenOpcode uint16 = 12
+	waylandXdgToplevelSetMinimizedOpcode    uint16 = 13
+	waylandXdgToplevelEventConfigure        uint16 = 0
+	waylandXdgToplevelEventClose            uint16 = 1
+	waylandXdgToplevelEventConfigureBounds  uint16 = 2
+	waylandXdgToplevelEventWmCapabilities   uint16 = 3
+)
+
+// xdg_toplevel.error
+const (
+	waylandXdgToplevelErrorInvalidResizeEdge uint32 = 0
+	waylandXdgToplevelErrorInvalidParent     uint32 = 1
+	waylandXdgToplevelErrorInvalidSize       uint32 = 2
+)
+
+// xdg_toplevel.resize_edge
+const (
+	waylandXdgToplevelResizeEdgeNone        uint32 = 0
+	waylandXdgToplevelResizeEdgeTop         uint32 = 1
+	waylandXdgToplevelResizeEdgeBottom      uint32 = 2
+	waylandXdgToplevelResizeEdgeLeft        uint32 = 4
+	waylandXdgToplevelResizeEdgeTopLeft     uint32 = 5
+	waylandXdgToplevelResizeEdgeBottomLeft  uint32 = 6
+	waylandXdgToplevelResizeEdgeRight       uint32 = 8
+	waylandXdgToplevelResizeEdgeTopRight    uint32 = 9
+	waylandXdgToplevelResizeEdgeBottomRight uint32 = 10
+)
+
+// xdg_toplevel.state
+const (
+	waylandXdgToplevelStateMaximized   uint32 = 1
+	waylandXdgToplevelStateFullscreen  uint32 = 2
+	waylandXdgToplevelStateResizing    uint32 = 3
+	waylandXdgToplevelStateActivated   uint32 = 4
+	waylandXdgToplevelStateTiledLeft   uint32 = 5
+	waylandXdgToplevelStateTiledRight  uint32 = 6
+	waylandXdgToplevelStateTiledTop    uint32 = 7
+	waylandXdgToplevelStateTiledBottom uint32 = 8
+	waylandXdgToplevelStateSuspended   uint32 = 9
+)
+
+// xdg_toplevel.wm_capabilities
+const (
+	waylandXdgToplevelWmCapabilitiesWindowMenu uint32 = 1
+	waylandXdgToplevelWmCapabilitiesMaximize   uint32 = 2
+	waylandXdgToplevelWmCapabilitiesFullscreen uint32 = 3
+	waylandXdgToplevelWmCapabilitiesMinimize   uint32 = 4
+)
+
+// XdgToplevelDestroy sends xdg_toplevel.destroy
+func (c *WaylandConn) XdgToplevelDestroy(xdgToplevel uint32) {
+	slog.Debug("request xdg_toplevel.destroy")
+	w := waylandWriter{}
+	c.request(xdgToplevel, waylandXdgToplevelDestroyOpcode, w.data, w.fds...)
+}
+
+// XdgToplevelSetParent sends xdg_toplevel.set_parent
+func (c *WaylandConn) XdgToplevelSetParent(xdgToplevel uint32, parent uint32) {
+	slog.Debug("request xdg_toplevel.set_parent")
+	w := waylandWriter{}
+	w.putUint(parent)
+	c.request(xdgToplevel, waylandXdgToplevelSetParentOpcode, w.data, w.fds...)
+}
+
+// XdgToplevelSetTitle sends xdg_toplevel.set_title
+func (c *WaylandConn) XdgToplevelSetTitle(xdgToplevel uint32, title string) {
+	slog.Debug("request xdg_toplevel.set_title")
+	w := waylandWriter{}
+	w.putString(title, false)
+	c.request(xdgToplevel, waylandXdgToplevelSetTitleOpcode, w.data, w.fds...)
+}
+
+// XdgToplevelSetAppId sends xdg_toplevel.set_app_id
+func (c *WaylandConn) XdgToplevelSetAppId(xdgToplevel uint32, appId string) {
+	slog.Debug("request xdg_toplevel.set_app_id")
+	w := waylandWriter{}
+	w.putString(appId, false)
+	c.request(xdgToplevel, waylandXdgToplevelSetAppIdOpcode, w.data, w.fds...)
+}
+
+// XdgToplevelShowWindowMenu sends xdg_toplevel.show_window_menu
+func (c *WaylandConn) XdgToplevelShowWindowMenu(xdgToplevel uint32, seat uint32, serial uint32, x int32, y int32) {
+	slog.Debug("request xdg_toplevel.show_window_menu")
+	w := waylandWriter{}
+	w.putUint(seat)
+	w.putUint(serial)
+	w.putInt(x)
+	w.putInt(y)
+	c.request(xdgToplevel, waylandXdgToplevelShowWindowMenuOpcode, w.data, w.fds...)
+}
+
+// XdgToplevelMove sends xdg_toplevel.move
+func (c *WaylandConn) XdgToplevelMove(xdgToplevel uint32, seat uint32, serial uint32) {
+	slog.Debug("request xdg_toplevel.move")
+	w := waylandWriter{}
+	w.putUint(seat)
+	w.putUint(serial)
+	c.request(xdgToplevel, waylandXdgToplevelMoveOpcode, w.data, w.fds...)
+}
+
+// XdgToplevelResize sends xdg_toplevel.resize
+func (c *WaylandConn) XdgToplevelResize(xdgToplevel uint32, seat uint32, serial uint32, edges uint32) {
+	slog.Debug("request xdg_toplevel.resize")
+	w := waylandWriter{}
+	w.putUint(seat)
+	w.putUint(serial)
+	w.putUint(edges)
+	c.request(xdgToplevel, waylandXdgToplevelResizeOpcode, w.data, w.fds...)
+}
+
+// XdgToplevelSetMaxSize sends xdg_toplevel.set_max_size
+func (c *WaylandConn) XdgToplevelSetMaxSize(xdgToplevel uint32, width int32, height int32) {
+	slog.Debug("request xdg_toplevel.set_max_size")
+	w := waylandWriter{}
+	w.putInt(width)
+	w.putInt(height)
+	c.request(xdgToplevel, waylandXdgToplevelSetMaxSizeOpcode, w.data, w.fds...)
+}
+
+// XdgToplevelSetMinSize sends xdg_toplevel.set_min_size
+func (c *WaylandConn) XdgToplevelSetMinSize(xdgToplevel uint32, width int32, height int32) {
+	slog.Debug("request xdg_toplevel.set_min_size")
+	w := waylandWriter{}
+	w.putInt(width)
+	w.putInt(height)
+	c.request(xdgToplevel, waylandXdgToplevelSetMinSizeOpcode, w.data, w.fds...)
+}
+
+// XdgToplevelSetMaximized sends xdg_toplevel.set_maximized
+func (c *WaylandConn) XdgToplevelSetMaximized(xdgToplevel uint32) {
+	slog.Debug("request xdg_toplevel.set_maximized")
+	w := waylandWriter{}
+	c.request(xdgToplevel, waylandXdgToplevelSetMaximizedOpcode, w.data, w.fds...)
+}
+
+// XdgToplevelUnsetMaximized sends xdg_toplevel.unset_maximized
+func (c *WaylandConn) XdgToplevelUnsetMaximized(xdgToplevel uint32) {
+	slog.Debug("request xdg_toplevel.unset_maximized")
+	w := waylandWriter{}
+	c.request(xdgToplevel, waylandXdgToplevelUnsetMaximizedOpcode, w.data, w.fds...)
+}
+
+// XdgToplevelSetFullscreen sends xdg_toplevel.set_fullscreen
+func (c *WaylandConn) XdgToplevelSetFullscreen(xdgToplevel uint32, output uint32) {
+	slog.Debug("request xdg_toplevel.set_fullscreen")
+	w := waylandWriter{}
+	w.putUint(output)
+	c.request(xdgToplevel, waylandXdgToplevelSetFullscreenOpcode, w.data, w.fds...)
+}
+
+// XdgToplevelUnsetFullscreen sends xdg_toplevel.unset_fullscreen
+func (c *WaylandConn) XdgToplevelUnsetFullscreen(xdgToplevel uint32) {
+	slog.Debug("request xdg_toplevel.unset_fullscreen")
+	w := waylandWriter{}
+	c.request(xdgToplevel, waylandXdgToplevelUnsetFullscreenOpcode, w.data, w.fds...)
+}
+
+// XdgToplevelSetMinimized sends xdg_toplevel.set_minimized
+func (c *WaylandConn) XdgToplevelSetMinimized(xdgToplevel uint32) {
+	slog.Debug("request xdg_toplevel.set_minimized")
+	w := waylandWriter{}
+	c.request(xdgToplevel, waylandXdgToplevelSetMinimizedOpcode, w.data, w.fds...)
+}
+
+// xdg_toplevel.configure
+type XdgToplevelConfigureEvent struct {
+	width  int32
+	height int32
+	states []byte
+}
+
+// DecodeXdgToplevelConfigureEvent decodes the body of xdg_toplevel.configure
+func DecodeXdgToplevelConfigureEvent(body []byte) (XdgToplevelConfigureEvent, error) {
+	e := XdgToplevelConfigureEvent{}
+	r := waylandReader{data: body}
+	var err error
+	if e.width, err = r.int(); err != nil {
+		return e, decodeError("xdg_toplevel.configure", "width", err)
+	}
+	if e.height, err = r.int(); err != nil {
+		return e, decodeError("xdg_toplevel.configure", "height", err)
+	}
+	if e.states, err = r.array(); err != nil {
+		return e, decodeError("xdg_toplevel.configure", "states", err)
+	}
+	return e, nil
+}
+
+// xdg_toplevel.close
+type XdgToplevelCloseEvent struct {
+}
+
+// DecodeXdgToplevelCloseEvent decodes the body of xdg_toplevel.close
+func DecodeXdgToplevelCloseEvent(body []byte) (XdgToplevelCloseEvent, error) {
+	e := XdgToplevelCloseEvent{}
+	return e, nil
+}
+
+// xdg_toplevel.configure_bounds
+type XdgToplevelConfigureBoundsEvent struct {
+	width  int32
+	height int32
+}
+
+// DecodeXdgToplevelConfigureBoundsEvent decodes the body of xdg_toplevel.configure_bounds
+func DecodeXdgToplevelConfigureBoundsEvent(body []byte) (XdgToplevelConfigureBoundsEvent, error) {
+	e := XdgToplevelConfigureBoundsEvent{}
+	r := waylandReader{data: body}
+	var err error
+	if e.width, err = r.int(); err != nil {
+		return e, decodeError("xdg_toplevel.configure_bounds", "width", err)
+	}
+	if e.height, err = r.int(); err != nil {
+		return e, decodeError("xdg_toplevel.configure_bounds", "height", err)
+	}
+	return e, nil
+}
+
+// xdg_toplevel.wm_capabilities
+type XdgToplevelWmCapabilitiesEvent struct {
+	capabilities []byte
+}
+
+// DecodeXdgToplevelWmCapabilitiesEvent decodes the body of xdg_toplevel.wm_capabilities
+func DecodeXdgToplevelWmCapabilitiesEvent(body []byte) (XdgToplevelWmCapabilitiesEvent, error) {
+	e := XdgToplevelWmCapabilitiesEvent{}
+	r := waylandReader{data: body}
+	var err error
+	if e.capabilities, err = r.array(); err != nil {
+		return e, decodeError("xdg_toplevel.wm_capabilities", "capabilities", err)
+	}
+	return e, nil
+}
+
+var xdgToplevelInterface = waylandInterfaceInfo{
+	name:    "xdg_toplevel",
+	version: 6,
+	requests: []waylandMessageInfo{
+		{name: "destroy", since: 1},
+		{name: "set_parent", since: 1, args: []waylandArgInfo{
+			{name: "parent", typ: waylandArgObject, iface: "xdg_toplevel", allowNull: true},
+		}},
+		{name: "set_title", since: 1, args: []waylandArgInfo{
+			{name: "title", typ: waylandArgString},
+		}},
+		{name: "set_app_id", since: 1, args: []waylandArgInfo{
+			{name: "app_id", typ: waylandArgString},
+		}},
+		{name: "show_window_menu", since: 1, args: []waylandArgInfo{
+			{name: "seat", typ: waylandArgObject, iface: "wl_seat"},
+			{name: "serial", typ: waylandArgUint},
+			{name: "x", typ: waylandArgInt},
+			{name: "y", typ: waylandArgInt},
+		}},
+		{name: "move", since: 1, args: []waylandArgInfo{
+			{name: "seat", typ: waylandArgObject, iface: "wl_seat"},
+			{name: "serial", typ: waylandArgUint},
+		}},
+		{name: "resize", since: 1, args: []waylandArgInfo{
+			{name: "seat", typ: waylandArgObject, iface: "wl_seat"},
+			{name: "serial", typ: waylandArgUint},
+			{name: "edges", typ: waylandArgUint},
+		}},
+		{name: "set_max_size", since: 1, args: []waylandArgInfo{
+			{name: "width", typ: waylandArgInt},
+			{name: "height", typ: waylandArgInt},
+		}},
+		{name: "set_min_size", since: 1, args: []waylandArgInfo{
+			{name: "width", typ: waylandArgInt},
+			{name: "height", typ: waylandArgInt},
+		}},
+		{name: "set_maximized", since: 1},
+		{name: "unset_maximized", since: 1},
+		{name: "set_fullscreen", since: 1, args: []waylandArgInfo{
+			{name: "output", typ: waylandArgObject, iface: "wl_output", allowNull: true},
+		}},
+		{name: "unset_fullscreen", since: 1},
+		{name: "set_minimized", since: 1},
+	},
+	events: []waylandMessageInfo{
+		{name: "configure", since: 1, args: []waylandArgInfo{
+			{name: "width", typ: waylandArgInt},
+			{name: "height", typ: waylandArgInt},
+			{name: "states", typ: waylandArgArray},
+		}},
+		{name: "close", since: 1},
+		{name: "configure_bounds", since: 4, args: []waylandArgInfo{
+			{name: "width", typ: waylandArgInt},
+			{name: "height", typ: waylandArgInt},
+		}},
+		{name: "wm_capabilities", since: 5, args: []waylandArgInfo{
+			{name: "capabilities", typ: waylandArgArray},
+		}},
+	},
+	errors: map[uint32]string{
+		0: "invalid_resize_edge",
+		1: "invalid_parent",
+		2: "invalid_size",
+	},
+}
+
+// xdg_popup version 6
+
+const (
+	waylandXdgPopupDestroyOpcode     uint16 = 0
+	waylandXdgPopupGrabOpcode        uint16 = 1
+	waylandXdgPopupRepositionOpcode  uint16 = 2
+	waylandXdgPopupEventConfigure    uint16 = 0
+	waylandXdgPopupEventPopupDone    uint16 = 1
+	waylandXdgPopupEventRepositioned uint16 = 2
+)
+
+// xdg_popup.error
+const (
+	waylandXdgPopupErrorInvalidGrab uint32 = 0
+)
+
+// XdgPopupDestroy sends xdg_popup.destroy
+func (c *WaylandConn) XdgPopupDestroy(xdgPopup uint32) {
+	slog.Debug("request xdg_popup.destroy")
+	w := waylandWriter{}
+	c.request(xdgPopup, waylandXdgPopupDestroyOpcode, w.data, w.fds...)
+}
+
+// XdgPopupGrab sends xdg_popup.grab
+func (c *WaylandConn) XdgPopupGrab(xdgPopup uint32, seat uint32, serial uint32) {
+	slog.Debug("request xdg_popup.grab")
+	w := waylandWriter{}
+	w.putUint(seat)
+	w.putUint(serial)
+	c.request(xdgPopup, waylandXdgPopupGrabOpcode, w.data, w.fds...)
+}
+
+// XdgPopupReposition sends xdg_popup.reposition
+func (c *WaylandConn) XdgPopupReposition(xdgPopup uint32, positioner uint32, token uint32) {
+	slog.Debug("request xdg_popup.reposition")
+	w := waylandWriter{}
+	w.putUint(positioner)
+	w.putUint(token)
+	c.request(xdgPopup, waylandXdgPopupRepositionOpcode, w.data, w.fds...)
+}
+
+// xdg_popup.configure
+type XdgPopupConfigureEvent struct {
+	x      int32
+	y      int32
+	width  int32
+	height int32
+}
+
+// DecodeXdgPopupConfigureEvent decodes the body of xdg_popup.configure
+func DecodeXdgPopupConfigureEvent(body []byte) (XdgPopupConfigureEvent, error) {
+	e := XdgPopupConfigureEvent{}
+	r := waylandReader{data: body}
+	var err error
+	if e.x, err = r.int(); err != nil {
+		return e, decodeError("xdg_popup.configure", "x", err)
+	}
+	if e.y, err = r.int(); err != nil {
+		return e, decodeError("xdg_popup.configure", "y", err)
+	}
+	if e.width, err = r.int(); err != nil {
+		return e, decodeError("xdg_popup.configure", "width", err)
+	}
+	if e.height, err = r.int(); err != nil {
+		return e, decodeError("xdg_popup.configure", "height", err)
+	}
+	return e, nil
+}
+
+// xdg_popup.popup_done
+type XdgPopupPopupDoneEvent struct {
+}
+
+// DecodeXdgPopupPopupDoneEvent decodes the body of xdg_popup.popup_done
+func DecodeXdgPopupPopupDoneEvent(body []byte) (XdgPopupPopupDoneEvent, error) {
+	e := XdgPopupPopupDoneEvent{}
+	return e, nil
+}
+
+// xdg_popup.repositioned
+type XdgPopupRepositionedEvent struct {
+	token uint32
+}
+
+// DecodeXdgPopupRepositionedEvent decodes the body of xdg_popup.repositioned
+func DecodeXdgPopupRepositionedEvent(body []byte) (XdgPopupRepositionedEvent, error) {
+	e := XdgPopupRepositionedEvent{}
+	r := waylandReader{data: body}
+	var err error
+	if e.token, err = r.uint(); err != nil {
+		return e, decodeError("xdg_popup.repositioned", "token", err)
+	}
+	return e, nil
+}
+
+var xdgPopupInterface = waylandInterfaceInfo{
+	name:    "xdg_popup",
+	version: 6,
+	requests: []waylandMessageInfo{
+		{name: "destroy", since: 1},
+		{name: "grab", since: 1, args: []waylandArgInfo{
+			{name: "seat", typ: waylandArgObject, iface: "wl_seat"},
+			{name: "serial", typ: waylandArgUint},
+		}},
+		{name: "reposition", since: 3, args: []waylandArgInfo{
+			{name: "positioner", typ: waylandArgObject, iface: "xdg_positioner"},
+			{name: "token", typ: waylandArgUint},
+		}},
+	},
+	events: []waylandMessageInfo{
+		{name: "configure", since: 1, args: []waylandArgInfo{
+			{name: "x", typ: waylandArgInt},
+			{name: "y", typ: waylandArgInt},
+			{name: "width", typ: waylandArgInt},
+			{name: "height", typ: waylandArgInt},
+		}},
+		{name: "popup_done", since: 1},
+		{name: "repositioned", since: 3, args: []waylandArgInfo{
+			{name: "token", typ: waylandArgUint},
+		}},
+	},
+	errors: map[uint32]string{
+		0: "invalid_grab",
+	},
+}
+
+// zxdg_decoration_manager_v1 version 1
+
+const (
+	waylandZxdgDecorationManagerV1DestroyOpcode               uint16 = 0
+	waylandZxdgDecorationManagerV1GetToplevelDecorationOpcode uint16 = 1
+)
+
+// ZxdgDecorationManagerV1Destroy sends zxdg_decoration_manager_v1.destroy
+func (c *WaylandConn) ZxdgDecorationManagerV1Destroy(zxdgDecorationManagerV1 uint32) {
+	slog.Debug("request zxdg_decoration_manager_v1.destroy")
+	w := waylandWriter{}
+	c.request(zxdgDecorationManagerV1, waylandZxdgDecorationManagerV1DestroyOpcode, w.data, w.fds...)
+}
+
+// ZxdgDecorationManagerV1GetToplevelDecoration sends zxdg_decoration_manager_v1.get_toplevel_decoration and returns an id of the created object
+func (c *WaylandConn) ZxdgDecorationManagerV1GetToplevelDecoration(zxdgDecorationManagerV1 uint32, toplevel uint32) uint32 {
+	slog.Debug("request zxdg_decoration_manager_v1.get_toplevel_decoration")
+	id := c.newId("zxdg_toplevel_decoration_v1")
+	w := waylandWriter{}
+	w.putUint(id)
+	w.putUint(toplevel)
+	c.request(zxdgDecorationManagerV1, waylandZxdgDecorationManagerV1GetToplevelDecorationOpcode, w.data, w.fds...)
+	return id
+}
+
+var zxdgDecorationManagerV1Interface = waylandInterfaceInfo{
+	name:    "zxdg_decoration_manager_v1",
+	version: 1,
+	requests: []waylandMessageInfo{
+		{name: "destroy", since: 1},
+		{name: "get_toplevel_decoration", since: 1, args: []waylandArgInfo{
+			{name: "id", typ: waylandArgNewId, iface: "zxdg_toplevel_decoration_v1"},
+			{name: "toplevel", typ: waylandArgObject, iface: "xdg_toplevel"},
+		}},
+	},
+}
+
+// zxdg_toplevel_decoration_v1 version 1
+
+const (
+	waylandZxdgToplevelDecorationV1DestroyOpcode   uint16 = 0
+	waylandZxdgToplevelDecorationV1SetModeOpcode   uint16 = 1
+	waylandZxdgToplevelDecorationV1UnsetModeOpcode uint16 = 2
+	waylandZxdgToplevelDecorationV1EventConfigure  uint16 = 0
+)
+
+// zxdg_toplevel_decoration_v1.error
+const (
+	waylandZxdgToplevelDecorationV1ErrorUnconfiguredBuffer uint32 = 0
+	waylandZxdgToplevelDecorationV1ErrorAlreadyConstructed uint32 = 1
+	waylandZxdgToplevelDecorationV1ErrorOrphaned           uint32 = 2
+	waylandZxdgToplevelDecorationV1ErrorInvalidMode        uint32 = 3
+)
+
+// zxdg_toplevel_decoration_v1.mode
+const (
+	waylandZxdgToplevelDecorationV1ModeClientSide uint32 = 1
+	waylandZxdgToplevelDecorationV1ModeServerSide uint32 = 2
+)
+
+// ZxdgToplevelDecorationV1Destroy sends zxdg_toplevel_decoration_v1.destroy
+func (c *WaylandConn) ZxdgToplevelDecorationV1Destroy(zxdgToplevelDecorationV1 uint32) {
+	slog.Debug("request zxdg_toplevel_decoration_v1.destroy")
+	w := waylandWriter{}
+	c.request(zxdgToplevelDecorationV1, waylandZxdgToplevelDecorationV1DestroyOpcode, w.data, w.fds...)
+}
+
+// ZxdgToplevelDecorationV1SetMode sends zxdg_toplevel_decoration_v1.set_mode
+func (c *WaylandConn) ZxdgToplevelDecorationV1SetMode(zxdgToplevelDecorationV1 uint32, mode uint32) {
+	slog.Debug("request zxdg_toplevel_decoration_v1.set_mode")
+	w := waylandWriter{}
+	w.putUint(mode)
+	c.request(zxdgToplevelDecorationV1, waylandZxdgToplevelDecorationV1SetModeOpcode, w.data, w.fds...)
+}
+
+// ZxdgToplevelDecorationV1UnsetMode sends zxdg_toplevel_decoration_v1.unset_mode
+func (c *WaylandConn) ZxdgToplevelDecorationV1UnsetMode(zxdgToplevelDecorationV1 uint32) {
+	slog.Debug("request zxdg_toplevel_decoration_v1.unset_mode")
+	w := waylandWriter{}
+	c.request(zxdgToplevelDecorationV1, waylandZxdgToplevelDecorationV1UnsetModeOpcode, w.data, w.fds...)
+}
+
+// zxdg_toplevel_decoration_v1.configure
+type ZxdgToplevelDecorationV1ConfigureEvent struct {
+	mode uint32
+}
+
+// DecodeZxdgToplevelDecorationV1ConfigureEvent decodes the body of zxdg_toplevel_decoration_v1.configure
+func DecodeZxdgToplevelDecorationV1ConfigureEvent(body []byte) (ZxdgToplevelDecorationV1ConfigureEvent, error) {
+	e := ZxdgToplevelDecorationV1ConfigureEvent{}
+	r := waylandReader{data: body}
+	var err error
+	if e.mode, err = r.uint(); err != nil {
+		return e, decodeError("zxdg_toplevel_decoration_v1.configure", "mode", err)
+	}
+	return e, nil
+}
+
+var zxdgToplevelDecorationV1Interface = waylandInterfaceInfo{
+	name:    "zxdg_toplevel_decoration_v1",
+	version: 1,
+	requests: []waylandMessageInfo{
+		{name: "destroy", since: 1},
+		{name: "set_mode", since: 1, args: []waylandArgInfo{
+			{name: "mode", typ: waylandArgUint},
+		}},
+		{name: "unset_mode", since: 1},
+	},
+	events: []waylandMessageInfo{
+		{name: "configure", since: 1, args: []waylandArgInfo{
+			{name: "mode", typ: waylandArgUint},
+		}},
+	},
+	errors: map[uint32]string{
+		0: "unconfigured_buffer",
+		1: "already_constructed",
+		2: "orphaned",
+		3: "invalid_mode",
+	},
+}
+
+// zwp_keyboard_shortcuts_inhibit_manager_v1 version 1
+
+const (
+	waylandZwpKeyboardShortcutsInhibitManagerV1DestroyOpcode          uint16 = 0
+	waylandZwpKeyboardShortcutsInhibitManagerV1InhibitShortcutsOpcode uint16 = 1
+)
+
+// zwp_keyboard_shortcuts_inhibit_manager_v1.error
+const (
+	waylandZwpKeyboardShortcutsInhibitManagerV1ErrorAlreadyInhibited uint32 = 0
+)
+
+// ZwpKeyboardShortcutsInhibitManagerV1Destroy sends zwp_keyboard_shortcuts_inhibit_manager_v1.destroy
+func (c *WaylandConn) ZwpKeyboardShortcutsInhibitManagerV1Destroy(zwpKeyboardShortcutsInhibitManagerV1 uint32) {
+	slog.Debug("request zwp_keyboard_shortcuts_inhibit_manager_v1.destroy")
+	w := waylandWriter{}
+	c.request(zwpKeyboardShortcutsInhibitManagerV1, waylandZwpKeyboardShortcutsInhibitManagerV1DestroyOpcode, w.data, w.fds...)
+}
+
+// ZwpKeyboardShortcutsInhibitManagerV1InhibitShortcuts sends zwp_keyboard_shortcuts_inhibit_manager_v1.inhibit_shortcuts and returns an id of the created object
+func (c *WaylandConn) ZwpKeyboardShortcutsInhibitManagerV1InhibitShortcuts(zwpKeyboardShortcutsInhibitManagerV1 uint32, surface uint32, seat uint32) uint32 {
+	slog.Debug("request zwp_keyboard_shortcuts_inhibit_manager_v1.inhibit_shortcuts")
+	id := c.newId("zwp_keyboard_shortcuts_inhibitor_v1")
+	w := waylandWriter{}
+	w.putUint(id)
+	w.putUint(surface)
+	w.putUint(seat)
+	c.request(zwpKeyboardShortcutsInhibitManagerV1, waylandZwpKeyboardShortcutsInhibitManagerV1InhibitShortcutsOpcode, w.data, w.fds...)
+	return id
+}
+
+var zwpKeyboardShortcutsInhibitManagerV1Interface = waylandInterfaceInfo{
+	name:    "zwp_keyboard_shortcuts_inhibit_manager_v1",
+	version: 1,
+	requests: []waylandMessageInfo{
+		{name: "destroy", since: 1},
+		{name: "inhibit_shortcuts", since: 1, args: []waylandArgInfo{
+			{name: "id", typ: waylandArgNewId, iface: "zwp_keyboard_shortcuts_inhibitor_v1"},
+			{name: "surface", typ: waylandArgObject, iface: "wl_surface"},
+			{name: "seat", typ: waylandArgObject, iface: "wl_seat"},
+		}},
+	},
+	errors: map[uint32]string{
+		0: "already_inhibited",
+	},
+}
+
+// zwp_keyboard_shortcuts_inhibitor_v1 version 1
+
+const (
+	waylandZwpKeyboardShortcutsInhibitorV1DestroyOpcode uint16 = 0
+	waylandZwpKeyboardShortcutsInhibitorV1EventActive   uint16 = 0
+	waylandZwpKeyboardShortcutsInhibitorV1EventInactive uint16 = 1
+)
+
+// ZwpKeyboardShortcutsInhibitorV1Destroy sends zwp_keyboard_shortcuts_inhibitor_v1.destroy
+func (c *WaylandConn) ZwpKeyboardShortcutsInhibitorV1Destroy(zwpKeyboardShortcutsInhibitorV1 uint32) {
+	slog.Debug("request zwp_keyboard_shortcuts_inhibitor_v1.destroy")
+	w := waylandWriter{}
+	c.request(zwpKeyboardShortcutsInhibitorV1, waylandZwpKeyboardShortcutsInhibitorV1DestroyOpcode, w.data, w.fds...)
+}
+
+// zwp_keyboard_shortcuts_inhibitor_v1.active
+type ZwpKeyboardShortcutsInhibitorV1ActiveEvent struct {
+}
+
+// DecodeZwpKeyboardShortcutsInhibitorV1ActiveEvent decodes the body of zwp_keyboard_shortcuts_inhibitor_v1.active
+func DecodeZwpKeyboardShortcutsInhibitorV1ActiveEvent(body []byte) (ZwpKeyboardShortcutsInhibitorV1ActiveEvent, error) {
+	e := ZwpKeyboardShortcutsInhibitorV1ActiveEvent{}
+	return e, nil
+}
+
+// zwp_keyboard_shortcuts_inhibitor_v1.inactive
+type ZwpKeyboardShortcutsInhibitorV1InactiveEvent struct {
+}
+
+// DecodeZwpKeyboardShortcutsInhibitorV1InactiveEvent decodes the body of zwp_keyboard_shortcuts_inhibitor_v1.inactive
+func DecodeZwpKeyboardShortcutsInhibitorV1InactiveEvent(body []byte) (ZwpKeyboardShortcutsInhibitorV1InactiveEvent, error) {
+	e := ZwpKeyboardShortcutsInhibitorV1InactiveEvent{}
+	return e, nil
+}
+
+var zwpKeyboardShortcutsInhibitorV1Interface = waylandInterfaceInfo{
+	name:    "zwp_keyboard_shortcuts_inhibitor_v1",
+	version: 1,
+	requests: []waylandMessageInfo{
+		{name: "destroy", since: 1},
+	},
+	events: []waylandMessageInfo{
+		{name: "active", since: 1},
+		{name: "inactive", since: 1},
+	},
+}
+
+// descriptions of all the interfaces, by their names
+var waylandInterfaces = map[string]*waylandInterfaceInfo{
+	"wl_display":                  &wlDisplayInterface,
+	"wl_registry":                 &wlRegistryInterface,
+	"wl_callback":                 &wlCallbackInterface,
+	"wl_compositor":               &wlCompositorInterface,
+	"wl_shm_pool":                 &wlShmPoolInterface,
+	"wl_shm":                      &wlShmInterface,
+	"wl_buffer":                   &wlBufferInterface,
+	"wl_surface":                  &wlSurfaceInterface,
+	"wl_seat":                     &wlSeatInterface,
+	"wl_pointer":                  &wlPointerInterface,
+	"wl_keyboard":                 &wlKeyboardInterface,
+	"wl_output":                   &wlOutputInterface,
+	"wl_region":                   &wlRegionInterface,
+	"xdg_wm_base":                 &xdgWmBaseInterface,
+	"xdg_positioner":              &xdgPositionerInterface,
+	"xdg_surface":                 &xdgSurfaceInterface,
+	"xdg_toplevel":                &xdgToplevelInterface,
+	"xdg_popup":                   &xdgPopupInterface,
+	"zxdg_decoration_manager_v1":  &zxdgDecorationManagerV1Interface,
+	"zxdg_toplevel_decoration_v1": &zxdgToplevelDecorationV1Interface,
+	"zwp_keyboard_shortcuts_inhibit_manager_v1": &zwpKeyboardShortcutsInhibitManagerV1Interface,
+	"zwp_keyboard_shortcuts_inhibitor_v1":       &zwpKeyboardShortcutsInhibitorV1Interface,
+}
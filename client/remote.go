@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The protocol spoken with the server on a target machine.
+//
+// Right after connecting the client sends a hello: the magic bytes "VKBD", a
+// version of the protocol (a single byte) and a name of the client (its length
+// in a single byte followed by the name). Then both sides send
+// frames, each made of a type (a single byte), a length of the payload (2 bytes,
+// little endian) and the payload.
+const (
+	protocolMagic   = "VKBD"
+	protocolVersion = 3
+	maxNameSize     = 255
+)
+
+// types of frames
+const (
+	// a key pressed or released: a key code (2 bytes, little endian) followed by
+	// 1 if the key was pressed or 0 if it was released. The server injects it
+	// through the device for the key, eg. volume up through its control device.
+	frameKey byte = 1
+	// sent by the server when the client gains or loses control over the target:
+	// 1 if the client's keys are injected, 0 if they aren't
+	frameControl byte = 3
+	// sent by the server when the LEDs of the target's keyboard change: a single
+	// byte with bit n set if the LED with code n is lit. Until the first one
+	// comes all the LEDs are off.
+	frameLeds byte = 4
+	// sent by the server when it can't do what the client asked for, eg. a key
+	// was rejected by the client's policy. The payload is a message for the user.
+	frameError byte = 5
+)
+
+const frameHeaderSize = 3
+
+// how long the client waits for the server to close the connection once it's
+// done sending
+const shutdownTimeout = 2 * time.Second
+
+func writeHello(w io.Writer, name string) error {
+	if len(name) > maxNameSize {
+		return fmt.Errorf("client name is longer than %d bytes", maxNameSize)
+	}
+	hello := append([]byte(protocolMagic), protocolVersion, byte(len(name)))
+	_, err := w.Write(append(hello, name...))
+	return err
+}
+
+// Encodes a key event as a frame of a type depending on the class of the key
+func encodeKeyMsg(ke KeyEvent) ([]byte, error) {
+	if ke.scanCode > 0xffff {
+		return nil, fmt.Errorf("key code %d doesn't fit in a message", ke.scanCode)
+	}
+	state := byte(0)
+	if ke.state {
+		state = 1
+	}
+	payload := binary.LittleEndian.AppendUint16(nil, uint16(ke.scanCode))
+	payload = append(payload, state)
+	return appendFrame(nil, frameKey, payload), nil
+}
+
+func appendFrame(b []byte, frameType byte, payload []byte) []byte {
+	b = append(b, frameType)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(payload)))
+	return append(b, payload...)
+}
+
+// Reads a single frame. Returns io.EOF if the connection was closed between frames.
+func readFrame(r *bufio.Reader) (byte, []byte, error) {
+	header := make([]byte, frameHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, binary.LittleEndian.Uint16(header[1:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+// Handles the payload of a frame of the type it's registered for
+type frameHandler func(payload []byte) error
+
+// A tcp or unix socket connection, either side of which can be closed on its own
+type streamConn interface {
+	net.Conn
+	CloseWrite() error
+}
+
+// A connection to the server on a target machine. Frames the server sends are
+// read by a goroutine of their own and passed to the handlers of their types,
+// frames of types without a handler are skipped.
+type remoteConn struct {
+	streamConn
+	mu       sync.Mutex
+	handlers map[byte]frameHandler
+	done     chan struct{}    // closed once the server closes the connection
+	log      *slog.Logger     // logs with the address of the server
+	recorder *sessionRecorder // records the keys sent, nil unless a session is recorded
+	shutOnce sync.Once
+	shutErr  error
+}
+
+// Creates a connection with handlers logging control changes and errors
+func newRemoteConn(conn streamConn) *remoteConn {
+	c := &remoteConn{
+		streamConn: conn,
+		handlers:   make(map[byte]frameHandler),
+		done:       make(chan struct{}),
+		log:        slog.With("remote", conn.RemoteAddr().String()),
+	}
+	c.onControl(c.logControl)
+	c.onError(func(msg string) {
+		c.log.Warn("the target machine reported an error", "message", msg)
+	})
+	return c
+}
+
+func (c *remoteConn) logControl(control bool) {
+	if control {
+		c.log.Info("this client has control over the target machine")
+	} else {
+		c.log.Info("another client has control over the target machine, keys sent from here are dropped")
+	}
+}
+
+// Sends a key event to the server, recording it if a session is recorded.
+// Errors are logged as well.
+func (c *remoteConn) sendKey(ke KeyEvent) error {
+	keyMsg, err := encodeKeyMsg(ke)
+	if err != nil {
+		c.log.Error("couldn't encode a key", keyAttr(ke.scanCode), errAttr(err))
+		return err
+	}
+	c.log.Debug("sending a key", keyAttr(ke.scanCode), "down", ke.state)
+	if _, err := c.Write(keyMsg); err != nil {
+		c.log.Error("couldn't send a key", errAttr(err))
+		return err
+	}
+	c.recorder.record(ke, time.Now())
+	return nil
+}
+
+// Sets the handler of frames of a type, replacing the one set before
+func (c *remoteConn) handle(frameType byte, h frameHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handlers[frameType] = h
+}
+
+func (c *remoteConn) onControl(fn func(control bool)) {
+	c.handle(frameControl, func(payload []byte) error {
+		if len(payload) != 1 {
+			return fmt.Errorf("control message has %d bytes, expected 1", len(payload))
+		}
+		fn(payload[0] == 1)
+		return nil
+	})
+}
+
+func (c *remoteConn) onLeds(fn func(leds ledState)) {
+	c.handle(frameLeds, func(payload []byte) error {
+		if len(payload) != 1 {
+			return fmt.Errorf("LED message has %d bytes, expected 1", len(payload))
+		}
+		c.log.Info("LEDs lit on the target machine", "leds", ledState(payload[0]).String())
+		fn(ledState(payload[0]))
+		return nil
+	})
+}
+
+func (c *remoteConn) onError(fn func(msg string)) {
+	c.handle(frameError, func(payload []byte) error {
+		fn(strings.ToValidUTF8(string(payload), "?"))
+		return nil
+	})
+}
+
+// Starts reading the frames the server sends
+func (c *remoteConn) start() {
+	go c.readLoop()
+}
+
+func (c *remoteConn) readLoop() {
+	defer close(c.done)
+	r := bufio.NewReader(c.streamConn)
+	for {
+		frameType, payload, err := readFrame(r)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				c.log.Error("while reading from the target machine", errAttr(err))
+			}
+			return
+		}
+		c.dispatch(frameType, payload)
+	}
+}
+
+func (c *remoteConn) dispatch(frameType byte, payload []byte) {
+	c.mu.Lock()
+	h, ok := c.handlers[frameType]
+	c.mu.Unlock()
+	if !ok {
+		c.log.Debug("skipping a frame of unknown type", "type", frameType)
+		return
+	}
+	if err := h(payload); err != nil {
+		c.log.Error("invalid message from the target machine", errAttr(err))
+	}
+}
+
+// Tells the server nothing more is going to be sent and waits until it closes
+// the connection, having sent everything it queued for the client, or until
+// timeout passes. Then closes the connection. Shutting it down again does nothing.
+func (c *remoteConn) shutdown(timeout time.Duration) error {
+	c.shutOnce.Do(func() {
+		if err := c.CloseWrite(); err == nil {
+			select {
+			case <-c.done:
+			case <-time.After(timeout):
+				c.log.Warn("the target machine didn't close the connection in time")
+			}
+		}
+		c.shutErr = c.Close()
+	})
+	return c.shutErr
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// Connects a remoteConn to a local tcp listener. Returns it together with the
+// end of the connection the server would have.
+func connectRemote(t *testing.T) (*remoteConn, *net.TCPConn) {
+	t.Helper()
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	conn, err := net.DialTCP("tcp", nil, ln.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	server, err := ln.AcceptTCP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return newRemoteConn(conn), server
+}
+
+func TestRemoteDispatch(t *testing.T) {
+	remote, server := connectRemote(t)
+	controls := make(chan bool, 2)
+	leds := make(chan ledState, 1)
+	errs := make(chan string, 1)
+	remote.onControl(func(control bool) { controls <- control })
+	remote.onLeds(func(l ledState) { leds <- l })
+	remote.onError(func(msg string) { errs <- msg })
+	remote.start()
+
+	stream := appendFrame(nil, frameControl, []byte{1})
+	stream = appendFrame(stream, 200, []byte("a frame of a type from the future"))
+	stream = appendFrame(stream, frameLeds, []byte{1, 2}) // invalid, skipped
+	stream = appendFrame(stream, frameLeds, []byte{byte(ledCapsLock)})
+	stream = appendFrame(stream, frameError, []byte("KEY_SYSRQ rejected"))
+	stream = appendFrame(stream, frameControl, []byte{0})
+	if _, err := server.Write(stream); err != nil {
+		t.Fatal(err)
+	}
+	timeout := time.After(5 * time.Second)
+	for _, expected := range []bool{true, false} {
+		select {
+		case control := <-controls:
+			if control != expected {
+				t.Errorf("control %v, expected %v", control, expected)
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for a control change")
+		}
+	}
+	if l := <-leds; l != ledCapsLock {
+		t.Errorf("LEDs %v, expected caps lock", l)
+	}
+	if msg := <-errs; msg != "KEY_SYSRQ rejected" {
+		t.Errorf("error %q", msg)
+	}
+}
+
+func TestRemoteShutdown(t *testing.T) {
+	remote, server := connectRemote(t)
+	remote.start()
+	shutDown := make(chan error, 1)
+	go func() { shutDown <- remote.shutdown(5 * time.Second) }()
+
+	// the server sees the client is done sending, sends what it has left and closes
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := bufio.NewReader(server).ReadByte(); err != io.EOF {
+		t.Fatalf("server read %v, expected the client to stop sending", err)
+	}
+	select {
+	case <-shutDown:
+		t.Fatal("the client didn't wait for the server")
+	case <-time.After(50 * time.Millisecond):
+	}
+	server.Write(appendFrame(nil, frameControl, []byte{0}))
+	server.Close()
+	select {
+	case err := <-shutDown:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the client didn't close the connection after the server did")
+	}
+}
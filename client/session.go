@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Recorded sessions: the keys sent to a target together with when they were sent,
+// so they can be sent again later, eg. to walk through an installer. A session
+// file starts with a header telling the version of the format and when the
+// recording started, followed by a line for each key event: the seconds since
+// the start and the event the way the headless mode reads it, eg.
+//
+//	virt-kbd-session 1 2026-01-02T15:04:05Z
+//	1.250 30 down
+//	1.312 30 up
+const (
+	sessionMagic   = "virt-kbd-session"
+	sessionVersion = 1
+)
+
+// Writes the key events sent to a session file
+type sessionRecorder struct {
+	mu    sync.Mutex
+	w     io.Writer
+	start time.Time
+	err   error // the first write that failed, nothing is written after it
+}
+
+// Starts recording a session to w, writing its header
+func newSessionRecorder(w io.Writer, start time.Time) (*sessionRecorder, error) {
+	r := &sessionRecorder{w: w, start: start}
+	if _, err := fmt.Fprintf(w, "%s %d %s\n", sessionMagic, sessionVersion, start.UTC().Format(time.RFC3339Nano)); err != nil {
+		return nil, errors.New("couldn't write the session header: " + err.Error())
+	}
+	return r, nil
+}
+
+// Records a key event sent at t. Does nothing if r is nil.
+func (r *sessionRecorder) record(ke KeyEvent, t time.Time) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	state := "up"
+	if ke.state {
+		state = "down"
+	}
+	_, r.err = fmt.Fprintf(r.w, "%.3f %d %s\n", t.Sub(r.start).Seconds(), ke.scanCode, state)
+}
+
+// Returns the first error writing the session
+func (r *sessionRecorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Reads a session file and gives out its events the way runHeadless reads
+// them, each one once it's due. The first event is due right away, the next
+// ones as long after it as they were recorded, divided by speed. With speed 0
+// the events are given out without waiting.
+type sessionPlayer struct {
+	scanner *bufio.Scanner
+	speed   float64
+	line    int
+	first   time.Duration // the offset of the first event in the file
+	started time.Time     // when the first event was given out, zero before that
+	last    time.Duration
+	pending []byte // what's left of the event being read
+}
+
+func newSessionPlayer(r io.Reader, speed float64) (*sessionPlayer, error) {
+	if speed < 0 {
+		return nil, fmt.Errorf("invalid speed %v", speed)
+	}
+	p := &sessionPlayer{scanner: bufio.NewScanner(r), speed: speed, line: 1}
+	if !p.scanner.Scan() {
+		if err := p.scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("the session file is empty")
+	}
+	fields := strings.Fields(p.scanner.Text())
+	if len(fields) < 2 || fields[0] != sessionMagic {
+		return nil, errors.New("not a session file")
+	}
+	if version, err := strconv.Atoi(fields[1]); err != nil || version != sessionVersion {
+		return nil, fmt.Errorf("unsupported session version %s, expected %d", fields[1], sessionVersion)
+	}
+	return p, nil
+}
+
+func (p *sessionPlayer) Read(b []byte) (int, error) {
+	if len(p.pending) == 0 {
+		if err := p.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(b, p.pending)
+	p.pending = p.pending[n:]
+	return n, nil
+}
+
+// Reads the next event and waits until it's due
+func (p *sessionPlayer) next() error {
+	for p.scanner.Scan() {
+		p.line++
+		text := strings.TrimSpace(p.scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		offsetText, event, _ := strings.Cut(text, " ")
+		seconds, err := strconv.ParseFloat(offsetText, 64)
+		if err != nil || seconds < 0 {
+			return fmt.Errorf("line %d: invalid time %q", p.line, offsetText)
+		}
+		offset := time.Duration(seconds * float64(time.Second))
+		if offset < p.last {
+			return fmt.Errorf("line %d: the event is older than the one before", p.line)
+		}
+		if _, err := ParseKeyEvent(event); err != nil {
+			return fmt.Errorf("line %d: %v", p.line, err)
+		}
+		p.last = offset
+		if p.started.IsZero() {
+			p.first, p.started = offset, time.Now()
+		} else if p.speed > 0 {
+			time.Sleep(time.Until(p.started.Add(time.Duration(float64(offset-p.first) / p.speed))))
+		}
+		p.pending = []byte(strings.TrimSpace(event) + "\n")
+		return nil
+	}
+	if err := p.scanner.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// reads n bytes of frames the client sent
+func readSent(t *testing.T, conn io.Reader, n int) []byte {
+	t.Helper()
+	got := make([]byte, n)
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestSessionRecordReplay(t *testing.T) {
+	remote, server := connectRemote(t)
+	var session bytes.Buffer
+	var err error
+	if remote.recorder, err = newSessionRecorder(&session, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := runHeadless(strings.NewReader("30 down\n30 up\n113 down\n113 up\n"), remote); err != nil {
+		t.Fatal(err)
+	}
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	sent := readSent(t, server, 24)
+
+	lines := strings.Split(strings.TrimSpace(session.String()), "\n")
+	if len(lines) != 5 || !strings.HasPrefix(lines[0], "virt-kbd-session 1 ") {
+		t.Fatalf("unexpected session:\n%s", session.String())
+	}
+	for i, event := range []string{"30 down", "30 up", "113 down", "113 up"} {
+		if !strings.HasSuffix(lines[i+1], " "+event) {
+			t.Errorf("line %q, expected %q", lines[i+1], event)
+		}
+	}
+
+	player, err := newSessionPlayer(&session, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed, replayServer := connectRemote(t)
+	if err := runHeadless(player, replayed); err != nil {
+		t.Fatal(err)
+	}
+	replayServer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if got := readSent(t, replayServer, 24); !bytes.Equal(got, sent) {
+		t.Errorf("replayed %v, expected %v", got, sent)
+	}
+}
+
+func TestSessionTiming(t *testing.T) {
+	const session = "virt-kbd-session 1 2026-01-02T15:04:05Z\n5.000 30 down\n\n5.100 30 up\n# a comment\n5.300 31 down\n"
+	for _, test := range []struct {
+		speed    float64
+		min, max time.Duration
+	}{
+		{2, 140 * time.Millisecond, 2 * time.Second},
+		{0, 0, 100 * time.Millisecond},
+	} {
+		player, err := newSessionPlayer(strings.NewReader(session), test.speed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := time.Now()
+		events, err := io.ReadAll(player)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if elapsed := time.Since(start); elapsed < test.min || elapsed > test.max {
+			t.Errorf("at speed %v the events took %v, expected %v to %v", test.speed, elapsed, test.min, test.max)
+		}
+		if string(events) != "30 down\n30 up\n31 down\n" {
+			t.Errorf("unexpected events %q", events)
+		}
+	}
+}
+
+func TestSessionInvalid(t *testing.T) {
+	for _, header := range []string{"", "30 down\n", "virt-kbd-session 2 2026-01-02T15:04:05Z\n"} {
+		if _, err := newSessionPlayer(strings.NewReader(header), 1); err == nil {
+			t.Errorf("%q is accepted", header)
+		}
+	}
+	for _, events := range []string{"1.0 30 down\n0.5 30 up\n", "soon 30 down\n", "1.0 30 sideways\n"} {
+		player, err := newSessionPlayer(strings.NewReader("virt-kbd-session 1 2026-01-02T15:04:05Z\n"+events), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadAll(player); err == nil {
+			t.Errorf("%q is accepted", events)
+		}
+	}
+}
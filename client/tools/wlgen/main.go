@@ -0,0 +1,364 @@
+// wlgen generates Go bindings for wayland protocols described in xml files.
+//
+// For every interface it writes constants with opcodes of requests and events
+// and values of enums, a method of WaylandConn for every request, a struct and
+// a decoder for every event and a description of the interface used to print
+// messages and errors in a readable form.
+//
+// Usage:
+//
+//	go run ./tools/wlgen -o protocol_gen.go protocols/*.xml
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type protocol struct {
+	Name       string  `xml:"name,attr"`
+	Interfaces []iface `xml:"interface"`
+	file       string
+}
+
+type iface struct {
+	Name     string    `xml:"name,attr"`
+	Version  uint32    `xml:"version,attr"`
+	Requests []message `xml:"request"`
+	Events   []message `xml:"event"`
+	Enums    []enum    `xml:"enum"`
+}
+
+type message struct {
+	Name  string `xml:"name,attr"`
+	Type  string `xml:"type,attr"`
+	Since uint32 `xml:"since,attr"`
+	Args  []arg  `xml:"arg"`
+}
+
+type arg struct {
+	Name      string `xml:"name,attr"`
+	Type      string `xml:"type,attr"`
+	Interface string `xml:"interface,attr"`
+	AllowNull bool   `xml:"allow-null,attr"`
+}
+
+type enum struct {
+	Name    string  `xml:"name,attr"`
+	Entries []entry `xml:"entry"`
+}
+
+type entry struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+func main() {
+	out := flag.String("o", "protocol_gen.go", "file to write the bindings to")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		log.Fatal("provide protocol xml files to generate the bindings from")
+	}
+	protocols := make([]protocol, 0)
+	for _, path := range flag.Args() {
+		p, err := parseProtocol(path)
+		if err != nil {
+			log.Fatalf("%s: %v", path, err)
+		}
+		protocols = append(protocols, p)
+	}
+	src, err := generate(protocols)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(*out, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func parseProtocol(path string) (protocol, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return protocol{}, err
+	}
+	p := protocol{}
+	if err := xml.Unmarshal(data, &p); err != nil {
+		return protocol{}, err
+	}
+	p.file = path
+	for _, i := range p.Interfaces {
+		for _, m := range append(append([]message{}, i.Requests...), i.Events...) {
+			for _, a := range m.Args {
+				if _, ok := argTypes[a.Type]; !ok {
+					return protocol{}, fmt.Errorf("%s.%s: unknown type %q of argument %s", i.Name, m.Name, a.Type, a.Name)
+				}
+			}
+		}
+	}
+	return p, nil
+}
+
+// how each argument type is represented in Go and in the interface descriptions
+var argTypes = map[string]struct {
+	goType  string
+	argType string
+	put     string
+	get     string
+}{
+	"int":    {"int32", "waylandArgInt", "putInt", "int"},
+	"uint":   {"uint32", "waylandArgUint", "putUint", "uint"},
+	"fixed":  {"waylandFixed", "waylandArgFixed", "putFixed", "fixed"},
+	"string": {"string", "waylandArgString", "putString", "string"},
+	"object": {"uint32", "waylandArgObject", "putUint", "uint"},
+	"new_id": {"uint32", "waylandArgNewId", "putUint", "uint"},
+	"array":  {"[]byte", "waylandArgArray", "putArray", "array"},
+	"fd":     {"int", "waylandArgFd", "putFd", "fd"},
+}
+
+func generate(protocols []protocol) ([]byte, error) {
+	b := &bytes.Buffer{}
+	files := make([]string, 0)
+	for _, p := range protocols {
+		files = append(files, p.file)
+	}
+	fmt.Fprintf(b, "// Code generated by wlgen from %s; DO NOT EDIT.\n\n", strings.Join(files, ", "))
+	fmt.Fprintf(b, "package main\n\n")
+	fmt.Fprintf(b, "import \"log/slog\"\n\n")
+	for _, p := range protocols {
+		for _, i := range p.Interfaces {
+			writeInterface(b, i)
+		}
+	}
+	fmt.Fprintf(b, "// descriptions of all the interfaces, by their names\n")
+	fmt.Fprintf(b, "var waylandInterfaces = map[string]*waylandInterfaceInfo{\n")
+	for _, p := range protocols {
+		for _, i := range p.Interfaces {
+			fmt.Fprintf(b, "%q: &%sInterface,\n", i.Name, unexported(i.Name))
+		}
+	}
+	fmt.Fprintf(b, "}\n")
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return b.Bytes(), fmt.Errorf("formatting generated code: %v", err)
+	}
+	return src, nil
+}
+
+func writeInterface(b *bytes.Buffer, i iface) {
+	name := camel(i.Name)
+	fmt.Fprintf(b, "// %s version %d\n\n", i.Name, i.Version)
+	fmt.Fprintf(b, "const (\n")
+	for opcode, r := range i.Requests {
+		fmt.Fprintf(b, "wayland%s%sOpcode uint16 = %d\n", name, camel(r.Name), opcode)
+	}
+	for opcode, e := range i.Events {
+		fmt.Fprintf(b, "wayland%sEvent%s uint16 = %d\n", name, camel(e.Name), opcode)
+	}
+	fmt.Fprintf(b, ")\n\n")
+	for _, e := range i.Enums {
+		fmt.Fprintf(b, "// %s.%s\nconst (\n", i.Name, e.Name)
+		for _, en := range e.Entries {
+			fmt.Fprintf(b, "wayland%s%s%s uint32 = %s\n", name, camel(e.Name), camel(en.Name), en.Value)
+		}
+		fmt.Fprintf(b, ")\n\n")
+	}
+	for _, r := range i.Requests {
+		writeRequest(b, i, r)
+	}
+	for _, e := range i.Events {
+		writeEvent(b, i, e)
+	}
+	writeInterfaceInfo(b, i)
+}
+
+func writeRequest(b *bytes.Buffer, i iface, r message) {
+	name := camel(i.Name) + camel(r.Name)
+	self := unexported(i.Name)
+	params := []string{self + " uint32"}
+	newId := ""
+	newIface := strconv.Quote("")
+	for _, a := range r.Args {
+		switch {
+		case a.Type == "new_id" && a.Interface == "":
+			// the interface of the object is chosen by the caller, eg. in wl_registry.bind
+			params = append(params, "iface string", "version uint32")
+			newId = argName(a.Name)
+			newIface = "iface"
+		case a.Type == "new_id":
+			newId = argName(a.Name)
+			newIface = strconv.Quote(a.Interface)
+		default:
+			params = append(params, argName(a.Name)+" "+argTypes[a.Type].goType)
+		}
+	}
+	fmt.Fprintf(b, "// %s sends %s.%s", name, i.Name, r.Name)
+	if newId != "" {
+		fmt.Fprintf(b, " and returns an id of the created object")
+	}
+	fmt.Fprintf(b, "\n")
+	result := ""
+	if newId != "" {
+		result = " uint32"
+	}
+	fmt.Fprintf(b, "func (c *WaylandConn) %s(%s)%s {\n", name, strings.Join(params, ", "), result)
+	fmt.Fprintf(b, "slog.Debug(%q)\n", "request "+i.Name+"."+r.Name)
+	if newId != "" {
+		fmt.Fprintf(b, "%s := c.newId(%s)\n", newId, newIface)
+	}
+	fmt.Fprintf(b, "w := waylandWriter{}\n")
+	for _, a := range r.Args {
+		n := argName(a.Name)
+		switch {
+		case a.Type == "new_id" && a.Interface == "":
+			fmt.Fprintf(b, "w.putString(iface, false)\n")
+			fmt.Fprintf(b, "w.putUint(version)\n")
+			fmt.Fprintf(b, "w.putUint(%s)\n", n)
+		case a.Type == "string":
+			fmt.Fprintf(b, "w.putString(%s, %v)\n", n, a.AllowNull)
+		default:
+			fmt.Fprintf(b, "w.%s(%s)\n", argTypes[a.Type].put, n)
+		}
+	}
+	fmt.Fprintf(b, "c.request(%s, wayland%sOpcode, w.data, w.fds...)\n", self, name)
+	if newId != "" {
+		fmt.Fprintf(b, "return %s\n", newId)
+	}
+	fmt.Fprintf(b, "}\n\n")
+}
+
+func writeEvent(b *bytes.Buffer, i iface, e message) {
+	name := camel(i.Name) + camel(e.Name) + "Event"
+	hasFd := false
+	fmt.Fprintf(b, "// %s.%s\n", i.Name, e.Name)
+	fmt.Fprintf(b, "type %s struct {\n", name)
+	for _, a := range e.Args {
+		fmt.Fprintf(b, "%s %s\n", fieldName(a.Name), argTypes[a.Type].goType)
+		if a.Type == "fd" {
+			hasFd = true
+		}
+	}
+	fmt.Fprintf(b, "}\n\n")
+	if hasFd {
+		fmt.Fprintf(b, "// Decode%s decodes the body of %s.%s. File descriptors the event carries are taken from fds.\n", name, i.Name, e.Name)
+		fmt.Fprintf(b, "func Decode%s(body []byte, fds waylandFdSource) (%s, error) {\n", name, name)
+	} else {
+		fmt.Fprintf(b, "// Decode%s decodes the body of %s.%s\n", name, i.Name, e.Name)
+		fmt.Fprintf(b, "func Decode%s(body []byte) (%s, error) {\n", name, name)
+	}
+	fmt.Fprintf(b, "e := %s{}\n", name)
+	if len(e.Args) > 0 {
+		if hasFd {
+			fmt.Fprintf(b, "r := waylandReader{data: body, fds: fds}\n")
+		} else {
+			fmt.Fprintf(b, "r := waylandReader{data: body}\n")
+		}
+		fmt.Fprintf(b, "var err error\n")
+	}
+	for _, a := range e.Args {
+		fmt.Fprintf(b, "if e.%s, err = r.%s(); err != nil {\n", fieldName(a.Name), argTypes[a.Type].get)
+		fmt.Fprintf(b, "return e, decodeError(%q, %q, err)\n", i.Name+"."+e.Name, a.Name)
+		fmt.Fprintf(b, "}\n")
+	}
+	fmt.Fprintf(b, "return e, nil\n")
+	fmt.Fprintf(b, "}\n\n")
+}
+
+func writeInterfaceInfo(b *bytes.Buffer, i iface) {
+	fmt.Fprintf(b, "var %sInterface = waylandInterfaceInfo{\n", unexported(i.Name))
+	fmt.Fprintf(b, "name: %q,\n", i.Name)
+	fmt.Fprintf(b, "version: %d,\n", i.Version)
+	writeMessagesInfo(b, "requests", i.Requests)
+	writeMessagesInfo(b, "events", i.Events)
+	for _, e := range i.Enums {
+		if e.Name != "error" {
+			continue
+		}
+		fmt.Fprintf(b, "errors: map[uint32]string{\n")
+		for _, en := range e.Entries {
+			fmt.Fprintf(b, "%s: %q,\n", en.Value, en.Name)
+		}
+		fmt.Fprintf(b, "},\n")
+	}
+	fmt.Fprintf(b, "}\n\n")
+}
+
+func writeMessagesInfo(b *bytes.Buffer, field string, msgs []message) {
+	if len(msgs) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "%s: []waylandMessageInfo{\n", field)
+	for _, m := range msgs {
+		since := m.Since
+		if since == 0 {
+			since = 1
+		}
+		fmt.Fprintf(b, "{name: %q, since: %d", m.Name, since)
+		if len(m.Args) > 0 {
+			fmt.Fprintf(b, ", args: []waylandArgInfo{\n")
+			for _, a := range m.Args {
+				fmt.Fprintf(b, "{name: %q, typ: %s", a.Name, argTypes[a.Type].argType)
+				if a.Interface != "" {
+					fmt.Fprintf(b, ", iface: %q", a.Interface)
+				}
+				if a.AllowNull {
+					fmt.Fprintf(b, ", allowNull: true")
+				}
+				fmt.Fprintf(b, "},\n")
+			}
+			fmt.Fprintf(b, "}")
+		}
+		fmt.Fprintf(b, "},\n")
+	}
+	fmt.Fprintf(b, "},\n")
+}
+
+// wl_shm_pool -> WlShmPool
+func camel(s string) string {
+	parts := strings.Split(s, "_")
+	for i, p := range parts {
+		if p == "" {
+			continue
+		}
+		parts[i] = strings.ToUpper(p[:1]) + p[1:]
+	}
+	return strings.Join(parts, "")
+}
+
+// wl_shm_pool -> wlShmPool
+func unexported(s string) string {
+	c := camel(s)
+	return strings.ToLower(c[:1]) + c[1:]
+}
+
+var goKeywords = map[string]bool{
+	"break": true, "case": true, "chan": true, "const": true, "continue": true, "default": true,
+	"defer": true, "else": true, "fallthrough": true, "for": true, "func": true, "go": true,
+	"goto": true, "if": true, "import": true, "interface": true, "map": true, "package": true,
+	"range": true, "return": true, "select": true, "struct": true, "switch": true, "type": true,
+	"var": true,
+}
+
+// names of parameters of request methods. c and w are used by the method itself.
+func argName(s string) string {
+	n := unexported(s)
+	if goKeywords[n] || n == "c" || n == "w" || n == "iface" || n == "version" {
+		return n + "Arg"
+	}
+	return n
+}
+
+// names of fields of event structs
+func fieldName(s string) string {
+	n := unexported(s)
+	if goKeywords[n] {
+		return n + "Arg"
+	}
+	return n
+}
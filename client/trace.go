@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A waylandTracer prints every request sent and every event received in a form
+// similar to the one libwayland uses with WAYLAND_DEBUG=1, eg.
+//
+//	[     12.345] -> wl_compositor@4.create_surface(new id wl_surface@7) # 04000000...
+//	[     13.001] <- wl_keyboard@9.key(12, 34567, 30, 1) # 09000000...
+//
+// Each line ends with the raw message in hex, so a trace written to a file can
+// be read back with ReadTrace and replayed.
+type waylandTracer struct {
+	w     io.Writer
+	start time.Time
+}
+
+func newWaylandTracer(w io.Writer) *waylandTracer {
+	return &waylandTracer{w: w, start: time.Now()}
+}
+
+// Turns tracing of all the requests and events on. Passing nil turns it off.
+func (c *WaylandConn) SetTrace(w io.Writer) {
+	if w == nil {
+		c.trace = nil
+		return
+	}
+	c.trace = newWaylandTracer(w)
+}
+
+func (t *waylandTracer) request(c *WaylandConn, header WaylandHeader, args []byte) {
+	t.print("->", c, header, args, func(info *waylandInterfaceInfo) []waylandMessageInfo { return info.requests })
+}
+
+func (t *waylandTracer) event(c *WaylandConn, header WaylandHeader, body []byte) {
+	t.print("<-", c, header, body, func(info *waylandInterfaceInfo) []waylandMessageInfo { return info.events })
+}
+
+func (t *waylandTracer) print(direction string, c *WaylandConn, header WaylandHeader, body []byte, messages func(*waylandInterfaceInfo) []waylandMessageInfo) {
+	elapsed := time.Since(t.start)
+	iface := c.Interface(header.objectId)
+	msg := fmt.Sprintf("[unknown]@%d.%d(?)", header.objectId, header.opcode)
+	if info, ok := waylandInterfaces[iface]; ok && int(header.opcode) < len(messages(info)) {
+		m := messages(info)[header.opcode]
+		msg = fmt.Sprintf("%s@%d.%s(%s)", iface, header.objectId, m.name, formatArgs(c, m, body))
+	} else if iface != "" {
+		msg = fmt.Sprintf("%s@%d.%d(?)", iface, header.objectId, header.opcode)
+	}
+	raw := make([]byte, 0, int(waylandHeaderSize)+len(body))
+	raw = appendHeader(raw, header)
+	raw = append(raw, body...)
+	fmt.Fprintf(t.w, "[%11.3f] %s %s # %s\n", float64(elapsed.Microseconds())/1000, direction, msg, hex.EncodeToString(raw))
+}
+
+// Decodes the arguments of a message using its description. File descriptors
+// aren't part of a message body, so only their position is shown.
+func formatArgs(c *WaylandConn, m waylandMessageInfo, body []byte) string {
+	r := waylandReader{data: body}
+	args := make([]string, 0, len(m.args))
+	for _, a := range m.args {
+		s, err := formatArg(c, &r, a)
+		if err != nil {
+			args = append(args, "<malformed>")
+			break
+		}
+		args = append(args, s)
+	}
+	return strings.Join(args, ", ")
+}
+
+func formatArg(c *WaylandConn, r *waylandReader, a waylandArgInfo) (string, error) {
+	switch a.typ {
+	case waylandArgInt:
+		v, err := r.int()
+		return strconv.Itoa(int(v)), err
+	case waylandArgUint:
+		v, err := r.uint()
+		return strconv.FormatUint(uint64(v), 10), err
+	case waylandArgFixed:
+		v, err := r.fixed()
+		return strconv.FormatFloat(v.Float64(), 'f', -1, 64), err
+	case waylandArgString:
+		v, err := r.bytes()
+		if len(v) == 0 {
+			return "nil", err
+		}
+		return strconv.Quote(strings.TrimSuffix(string(v), "\x00")), err
+	case waylandArgObject:
+		id, err := r.uint()
+		if id == 0 {
+			return "nil", err
+		}
+		return objectName(c, id, a.iface), err
+	case waylandArgNewId:
+		if a.iface == "" {
+			// new_id without an interface comes with a name and a version of the interface
+			iface, err := r.string()
+			if err != nil {
+				return "", err
+			}
+			version, err := r.uint()
+			if err != nil {
+				return "", err
+			}
+			id, err := r.uint()
+			return fmt.Sprintf("%q, %d, new id %s@%d", iface, version, iface, id), err
+		}
+		id, err := r.uint()
+		return fmt.Sprintf("new id %s@%d", a.iface, id), err
+	case waylandArgArray:
+		v, err := r.bytes()
+		return fmt.Sprintf("array[%d]", len(v)), err
+	case waylandArgFd:
+		return "fd", nil
+	}
+	return "", errors.New("unknown argument type")
+}
+
+func objectName(c *WaylandConn, id uint32, iface string) string {
+	if known := c.Interface(id); known != "" {
+		iface = known
+	}
+	if iface == "" {
+		iface = "[unknown]"
+	}
+	return fmt.Sprintf("%s@%d", iface, id)
+}
+
+// A single message read from a trace
+type TraceEntry struct {
+	at       time.Duration // time since the start of the trace
+	outgoing bool          // true for requests, false for events
+	msg      []byte        // the whole message, including the header
+}
+
+// Reads back a trace written by a tracer. Lines that aren't messages are skipped.
+func ReadTrace(r io.Reader) ([]TraceEntry, error) {
+	entries := make([]TraceEntry, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if !strings.HasPrefix(text, "[") {
+			continue
+		}
+		end := strings.Index(text, "]")
+		rawStart := strings.LastIndex(text, " # ")
+		if end < 0 || rawStart < end {
+			return nil, fmt.Errorf("line %d: not a trace entry", line)
+		}
+		ms, err := strconv.ParseFloat(strings.TrimSpace(text[1:end]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		rest := strings.TrimSpace(text[end+1:])
+		entry := TraceEntry{at: time.Duration(ms * float64(time.Millisecond))}
+		switch {
+		case strings.HasPrefix(rest, "->"):
+			entry.outgoing = true
+		case strings.HasPrefix(rest, "<-"):
+			entry.outgoing = false
+		default:
+			return nil, fmt.Errorf("line %d: unknown direction", line)
+		}
+		entry.msg, err = hex.DecodeString(text[rawStart+3:])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if len(entry.msg) < int(waylandHeaderSize) {
+			return nil, fmt.Errorf("line %d: message shorter than a header", line)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestTraceFormat(t *testing.T) {
+	conn, server := newTestConn(t)
+	trace := &bytes.Buffer{}
+	conn.SetTrace(trace)
+
+	registry := conn.WlDisplayGetRegistry(waylandDisplayObjectId)
+	compositor := conn.WlRegistryBind(registry, 1, "wl_compositor", 6)
+	surface := conn.WlCompositorCreateSurface(compositor)
+	conn.WlSurfaceAttach(surface, 0, 0, 0)
+	keyboard := conn.newId("wl_keyboard")
+	conn.SetHandler(keyboard, func(opcode uint16, body []byte) {})
+
+	global := waylandWriter{}
+	global.putUint(2)
+	global.putString("wl_seat", false)
+	global.putUint(9)
+	writeEvent(t, server, registry, waylandWlRegistryEventGlobal, global.data)
+	key := waylandWriter{}
+	for _, v := range []uint32{12, 34567, 30, 1} {
+		key.putUint(v)
+	}
+	writeEvent(t, server, keyboard, waylandWlKeyboardEventKey, key.data)
+	writeEvent(t, server, 77, 0, nil)
+	if err := conn.Dispatch(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"-> wl_display@1.get_registry(new id wl_registry@2)",
+		`-> wl_registry@2.bind(1, "wl_compositor", 6, new id wl_compositor@3)`,
+		"-> wl_compositor@3.create_surface(new id wl_surface@4)",
+		"-> wl_surface@4.attach(nil, 0, 0)",
+		`<- wl_registry@2.global(2, "wl_seat", 9)`,
+		"<- wl_keyboard@5.key(12, 34567, 30, 1)",
+		"<- [unknown]@77.0(?)",
+	}
+	lines := strings.Split(strings.TrimSpace(trace.String()), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("got %d lines, want %d:\n%s", len(lines), len(want), trace)
+	}
+	for i, line := range lines {
+		if !strings.Contains(line, want[i]) {
+			t.Errorf("line %d: %q doesn't contain %q", i, line, want[i])
+		}
+	}
+}
+
+type dispatchedEvent struct {
+	objectId uint32
+	opcode   uint16
+	body     string
+}
+
+func TestTraceReplay(t *testing.T) {
+	// record a session
+	conn, server := newTestConn(t)
+	trace := &bytes.Buffer{}
+	conn.SetTrace(trace)
+	registry := conn.WlDisplayGetRegistry(waylandDisplayObjectId)
+	wmBase := conn.WlRegistryBind(registry, 3, "xdg_wm_base", 6)
+	recorded := make([]dispatchedEvent, 0)
+	record := func(id uint32) EventHandler {
+		return func(opcode uint16, body []byte) {
+			recorded = append(recorded, dispatchedEvent{id, opcode, string(body)})
+		}
+	}
+	conn.SetHandler(registry, record(registry))
+	conn.SetHandler(wmBase, record(wmBase))
+	for name, iface := range []string{"wl_compositor", "wl_shm", "xdg_wm_base"} {
+		global := waylandWriter{}
+		global.putUint(uint32(name))
+		global.putString(iface, false)
+		global.putUint(1)
+		writeEvent(t, server, registry, waylandWlRegistryEventGlobal, global.data)
+	}
+	writeEvent(t, server, wmBase, waylandXdgWmBaseEventPing, []byte{7, 0, 0, 0})
+	if err := conn.Dispatch(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := ReadTrace(bytes.NewReader(trace.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 6 {
+		t.Fatalf("got %d entries, want 6", len(entries))
+	}
+
+	// replay the events to a new connection, it should see exactly the same
+	replayConn, replayServer := newTestConn(t)
+	replayConn.WlDisplayGetRegistry(waylandDisplayObjectId)
+	replayConn.WlRegistryBind(registry, 3, "xdg_wm_base", 6)
+	replayed := make([]dispatchedEvent, 0)
+	for _, id := range []uint32{registry, wmBase} {
+		replayConn.SetHandler(id, func(opcode uint16, body []byte) {
+			replayed = append(replayed, dispatchedEvent{id, opcode, string(body)})
+		})
+	}
+	sent := make([]byte, 0)
+	for _, e := range entries {
+		if e.outgoing {
+			sent = append(sent, e.msg...)
+			continue
+		}
+		if _, err := syscall.Write(replayServer, e.msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(sent, replayConn.out) {
+		t.Errorf("requests in the trace don't match the requests of the replay")
+	}
+	if err := replayConn.Dispatch(); err != nil {
+		t.Fatal(err)
+	}
+	if len(replayed) != len(recorded) {
+		t.Fatalf("replayed %d events, recorded %d", len(replayed), len(recorded))
+	}
+	for i := range recorded {
+		if replayed[i] != recorded[i] {
+			t.Errorf("event %d: replayed %+v, recorded %+v", i, replayed[i], recorded[i])
+		}
+	}
+}
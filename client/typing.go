@@ -0,0 +1,60 @@
+package main
+
+import "fmt"
+
+// Keys typing text takes on a US keyboard: the scancode of each character and
+// whether it needs shift
+type typedKey struct {
+	scanCode uint32
+	shift    bool
+}
+
+const keyLeftShift = 42
+
+var usLayout = map[rune]typedKey{
+	'\t': {15, false}, '\n': {28, false}, ' ': {57, false},
+	'1': {2, false}, '2': {3, false}, '3': {4, false}, '4': {5, false}, '5': {6, false},
+	'6': {7, false}, '7': {8, false}, '8': {9, false}, '9': {10, false}, '0': {11, false},
+	'!': {2, true}, '@': {3, true}, '#': {4, true}, '$': {5, true}, '%': {6, true},
+	'^': {7, true}, '&': {8, true}, '*': {9, true}, '(': {10, true}, ')': {11, true},
+	'-': {12, false}, '_': {12, true}, '=': {13, false}, '+': {13, true},
+	'[': {26, false}, '{': {26, true}, ']': {27, false}, '}': {27, true},
+	';': {39, false}, ':': {39, true}, '\'': {40, false}, '"': {40, true},
+	'`': {41, false}, '~': {41, true}, '\\': {43, false}, '|': {43, true},
+	',': {51, false}, '<': {51, true}, '.': {52, false}, '>': {52, true},
+	'/': {53, false}, '?': {53, true},
+}
+
+// scancodes of the letters, from KEY_A to KEY_Z
+var letterKeys = [26]uint32{30, 48, 46, 32, 18, 33, 34, 35, 23, 36, 37, 38, 50, 49, 24, 25, 16, 19, 31, 20, 22, 47, 17, 45, 21, 44}
+
+func lookupTypedKey(r rune) (typedKey, bool) {
+	switch {
+	case r >= 'a' && r <= 'z':
+		return typedKey{letterKeys[r-'a'], false}, true
+	case r >= 'A' && r <= 'Z':
+		return typedKey{letterKeys[r-'A'], true}, true
+	}
+	k, ok := usLayout[r]
+	return k, ok
+}
+
+// Returns the key events typing text takes on a US keyboard. Characters that
+// can't be typed on one are an error.
+func textKeyEvents(text string) ([]KeyEvent, error) {
+	events := make([]KeyEvent, 0, 2*len(text))
+	for _, r := range text {
+		k, ok := lookupTypedKey(r)
+		if !ok {
+			return nil, fmt.Errorf("%q can't be typed on a US keyboard", r)
+		}
+		if k.shift {
+			events = append(events, KeyEvent{scanCode: keyLeftShift, state: true})
+		}
+		events = append(events, KeyEvent{scanCode: k.scanCode, state: true}, KeyEvent{scanCode: k.scanCode, state: false})
+		if k.shift {
+			events = append(events, KeyEvent{scanCode: keyLeftShift, state: false})
+		}
+	}
+	return events, nil
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"syscall"
+)
+
+// how many file descriptors can come along a single read from a socket.
+// libwayland uses the same limit.
+const waylandMaxFdsInMsg = 28
+
+// how big a message can be. Its size field would allow 65535 bytes, but
+// libwayland doesn't take messages bigger than its buffer.
+const waylandMaxMessageSize = 4096
+
+// An EventHandler gets every event the display server sends to an object.
+// body is the content of the message without the header.
+type EventHandler func(opcode uint16, body []byte)
+
+// an object living on the client side of a connection
+type waylandObject struct {
+	iface   string // name of the interface the object implements, eg. wl_surface
+	handler EventHandler
+}
+
+// A WaylandConn is a single connection to a display server.
+//
+// It owns the socket and everything that's tied to it: allocation of
+// object ids (ids released by the server with wl_display.delete_id are
+// given out again), a buffer of requests waiting to be sent together
+// with file descriptors that have to be passed along them, and a buffer
+// of incoming data that's split into events and dispatched to handlers
+// of objects the events are meant for.
+type WaylandConn struct {
+	fd      int
+	lastId  uint32   // highest object id given out so far
+	freeIds []uint32 // ids the display server is done with and can be used again
+	objects map[uint32]*waylandObject
+	out     []byte // requests not yet sent
+	outFds  []int  // file descriptors to send along the requests in out
+	in      []byte // data received, but not dispatched yet
+	inFds   []int  // file descriptors received, but not taken by a handler yet
+	trace   *waylandTracer
+	err     error // why a request couldn't be queued, Flush returns it
+}
+
+// Creates a connection on top of an already connected socket.
+func NewWaylandConn(fd int) *WaylandConn {
+	c := &WaylandConn{
+		fd:      fd,
+		lastId:  waylandDisplayObjectId,
+		objects: make(map[uint32]*waylandObject),
+	}
+	c.objects[waylandDisplayObjectId] = &waylandObject{iface: "wl_display"}
+	return c
+}
+
+// Connects to a display server socket pointed by XDG_RUNTIME_DIR and WAYLAND_DISPLAY
+func DisplayConnect() (*WaylandConn, error) {
+	slog.Debug("connect to a display server")
+	fd, err := syscall.Socket(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, errors.New("socket error: " + err.Error())
+	}
+	xdgRuntimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	waylandDisplay := os.Getenv("WAYLAND_DISPLAY")
+	path := fmt.Sprintf("%s/%s", xdgRuntimeDir, waylandDisplay)
+	addr := syscall.SockaddrUnix{Name: path}
+	if err := syscall.Connect(fd, &addr); err != nil {
+		syscall.Close(fd)
+		return nil, errors.New("connection error: " + err.Error())
+	}
+	return NewWaylandConn(fd), nil
+}
+
+func (c *WaylandConn) Close() error {
+	for _, fd := range c.inFds {
+		syscall.Close(fd)
+	}
+	c.inFds = nil
+	return syscall.Close(c.fd)
+}
+
+// Gives out an id for a new object implementing iface.
+func (c *WaylandConn) newId(iface string) uint32 {
+	var id uint32
+	if n := len(c.freeIds); n > 0 {
+		id = c.freeIds[n-1]
+		c.freeIds = c.freeIds[:n-1]
+	} else {
+		c.lastId++
+		id = c.lastId
+	}
+	c.objects[id] = &waylandObject{iface: iface}
+	return id
+}
+
+// Sets a function that's called for every event sent to an object
+func (c *WaylandConn) SetHandler(objectId uint32, handler EventHandler) {
+	if obj, ok := c.objects[objectId]; ok {
+		obj.handler = handler
+	}
+}
+
+// Returns a name of the interface an object implements or an empty string
+// if there is no such object
+func (c *WaylandConn) Interface(objectId uint32) string {
+	if obj, ok := c.objects[objectId]; ok {
+		return obj.iface
+	}
+	return ""
+}
+
+// Queues a request. It's sent to the display server with the next Flush.
+// fds are file descriptors that are passed along the request. A request too
+// big for a message isn't queued and the connection can't be used anymore,
+// as the requests after it may refer to the objects it creates: Flush returns
+// an error instead of sending anything.
+func (c *WaylandConn) request(objectId uint32, opcode uint16, args []byte, fds ...int) {
+	size := int(waylandHeaderSize) + len(args)
+	if size > waylandMaxMessageSize {
+		if c.err == nil {
+			c.err = fmt.Errorf("request %d to %s@%d has %d bytes, a message can't have more than %d", opcode, c.Interface(objectId), objectId, size, waylandMaxMessageSize)
+		}
+		return
+	}
+	header := WaylandHeader{objectId: objectId, opcode: opcode, msgSize: uint16(size)}
+	if c.trace != nil {
+		c.trace.request(c, header, args)
+	}
+	c.out = appendHeader(c.out, header)
+	c.out = append(c.out, args...)
+	c.outFds = append(c.outFds, fds...)
+}
+
+// Sends all the queued requests to the display server
+func (c *WaylandConn) Flush() error {
+	if c.err != nil {
+		return c.err
+	}
+	for len(c.out) > 0 {
+		var oob []byte
+		if len(c.outFds) > 0 {
+			oob = syscall.UnixRights(c.outFds...)
+		}
+		n, err := syscall.SendmsgN(c.fd, c.out, oob, nil, 0)
+		if errors.Is(err, syscall.EINTR) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		// file descriptors go with the first byte that's been written
+		c.outFds = c.outFds[:0]
+		c.out = c.out[n:]
+	}
+	c.out = c.out[:0]
+	return nil
+}
+
+// Reads data available on the socket and dispatches all the complete events
+// to handlers of their objects. Blocks until there's something to read.
+func (c *WaylandConn) Dispatch() error {
+	buf := make([]byte, 4096)
+	oob := make([]byte, syscall.CmsgSpace(waylandMaxFdsInMsg*4))
+	n, oobn, _, _, err := syscall.Recvmsg(c.fd, buf, oob, syscall.MSG_CMSG_CLOEXEC)
+	if errors.Is(err, syscall.EINTR) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("display server closed the connection")
+	}
+	if oobn > 0 {
+		fds, err := parseFds(oob[:oobn])
+		if err != nil {
+			return err
+		}
+		c.inFds = append(c.inFds, fds...)
+	}
+	c.in = append(c.in, buf[:n]...)
+	return c.dispatchBuffered()
+}
+
+// Dispatches all the complete events received so far
+func (c *WaylandConn) dispatchBuffered() error {
+	for len(c.in) >= int(waylandHeaderSize) {
+		header, err := getMsgHeader(c.in)
+		if err != nil {
+			return err
+		}
+		if len(c.in) < int(header.msgSize) {
+			break // the rest of the message comes with the next read
+		}
+		body := c.in[waylandHeaderSize:header.msgSize]
+		if err := c.dispatchEvent(header, body); err != nil {
+			return err
+		}
+		c.in = c.in[header.msgSize:]
+	}
+	// move whatever is left to the beginning so the buffer doesn't grow forever
+	c.in = append(c.in[:0], c.in...)
+	return nil
+}
+
+func (c *WaylandConn) dispatchEvent(header WaylandHeader, body []byte) error {
+	if c.trace != nil {
+		c.trace.event(c, header, body)
+	}
+	if header.objectId == waylandDisplayObjectId {
+		return c.handleDisplayEvent(header.opcode, body)
+	}
+	obj, ok := c.objects[header.objectId]
+	if !ok || obj.handler == nil {
+		slog.Debug("no handler for an event", "opcode", header.opcode, "object", header.objectId)
+		return nil
+	}
+	obj.handler(header.opcode, body)
+	return nil
+}
+
+func (c *WaylandConn) handleDisplayEvent(opcode uint16, body []byte) error {
+	switch opcode {
+	case waylandWlDisplayEventError:
+		e, err := DecodeWlDisplayErrorEvent(body)
+		if err != nil {
+			return err
+		}
+		return c.protocolError(e)
+	case waylandWlDisplayEventDeleteId:
+		e, err := DecodeWlDisplayDeleteIdEvent(body)
+		if err != nil {
+			return err
+		}
+		delete(c.objects, e.id)
+		c.freeIds = append(c.freeIds, e.id)
+	}
+	return nil
+}
+
+// Takes the oldest file descriptor received from the display server.
+// Handlers of events that carry a file descriptor are responsible for
+// taking it and closing it once it's not needed.
+func (c *WaylandConn) TakeFd() (int, error) {
+	if len(c.inFds) == 0 {
+		return -1, errors.New("no file descriptor received")
+	}
+	fd := c.inFds[0]
+	c.inFds = c.inFds[1:]
+	return fd, nil
+}
+
+func parseFds(oob []byte) ([]int, error) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, err
+	}
+	fds := make([]int, 0)
+	for _, msg := range msgs {
+		rights, err := syscall.ParseUnixRights(&msg)
+		if err != nil {
+			continue
+		}
+		fds = append(fds, rights...)
+	}
+	return fds, nil
+}
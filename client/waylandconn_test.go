@@ -0,0 +1,27 @@
+package main
+
+import (
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestRequestTooBig(t *testing.T) {
+	conn, server := newTestConn(t)
+	registry := conn.WlDisplayGetRegistry(waylandDisplayObjectId)
+	// a string that doesn't fit in a message, its length would wrap around
+	// in the message's size field
+	conn.WlRegistryBind(registry, 1, strings.Repeat("x", 70000), 1)
+	err := conn.Flush()
+	if err == nil || !strings.Contains(err.Error(), "wl_registry@2") {
+		t.Fatalf("flushing a request too big returned %v", err)
+	}
+	// nothing is sent, not even the requests before it
+	syscall.SetNonblock(server, true)
+	if n, _ := syscall.Read(server, make([]byte, 64)); n > 0 {
+		t.Errorf("%d bytes got to the display server", n)
+	}
+	if err := conn.Flush(); err == nil {
+		t.Error("the connection is still used after a request was dropped")
+	}
+}
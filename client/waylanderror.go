@@ -0,0 +1,47 @@
+package main
+
+import "fmt"
+
+// A WaylandProtocolError is a fatal error reported by a display server with
+// wl_display.error. After sending it the display server closes the connection.
+type WaylandProtocolError struct {
+	objectId uint32
+	iface    string // interface of the object, empty if the object isn't known to the client
+	code     uint32
+	codeName string // name of the code from the error enum, empty if it's not known
+	message  string
+}
+
+func (e *WaylandProtocolError) Error() string {
+	object := fmt.Sprintf("unknown object %d", e.objectId)
+	if e.iface != "" {
+		object = fmt.Sprintf("%s@%d", e.iface, e.objectId)
+	}
+	code := fmt.Sprintf("%d", e.code)
+	if e.codeName != "" {
+		code = fmt.Sprintf("%d (%s)", e.code, e.codeName)
+	}
+	return fmt.Sprintf("display server sent an error about %s, code %s: %s", object, code, e.message)
+}
+
+// Maps a wl_display.error event to the object it's about and to a name of the error.
+//
+// Codes come from the error enum of the object's interface, except for the generic
+// errors (invalid_object, invalid_method, no_memory, implementation) which come from
+// the wl_display.error enum even when they're about some other object. When the
+// object's interface doesn't know a code, the generic errors are tried instead.
+func (c *WaylandConn) protocolError(e WlDisplayErrorEvent) *WaylandProtocolError {
+	protoErr := &WaylandProtocolError{
+		objectId: e.objectId,
+		iface:    c.Interface(e.objectId),
+		code:     e.code,
+		message:  e.message,
+	}
+	if info, ok := waylandInterfaces[protoErr.iface]; ok {
+		protoErr.codeName = info.errors[e.code]
+	}
+	if protoErr.codeName == "" {
+		protoErr.codeName = wlDisplayInterface.errors[e.code]
+	}
+	return protoErr
+}
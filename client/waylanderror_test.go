@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"syscall"
+	"testing"
+)
+
+// returns a connection and the display server's end of its socket
+func newTestConn(t *testing.T) (*WaylandConn, int) {
+	t.Helper()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := NewWaylandConn(fds[0])
+	t.Cleanup(func() {
+		conn.Close()
+		syscall.Close(fds[1])
+	})
+	return conn, fds[1]
+}
+
+func writeEvent(t *testing.T, fd int, objectId uint32, opcode uint16, args []byte) {
+	t.Helper()
+	msg := binary.LittleEndian.AppendUint32(nil, objectId)
+	msg = binary.LittleEndian.AppendUint16(msg, opcode)
+	msg = binary.LittleEndian.AppendUint16(msg, uint16(waylandHeaderSize)+uint16(len(args)))
+	msg = append(msg, args...)
+	if _, err := syscall.Write(fd, msg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func displayErrorArgs(objectId uint32, code uint32, message string) []byte {
+	w := waylandWriter{}
+	w.putUint(objectId)
+	w.putUint(code)
+	w.putString(message, false)
+	return w.data
+}
+
+func TestDisplayError(t *testing.T) {
+	tests := []struct {
+		name     string
+		objectId uint32
+		code     uint32
+		message  string
+		want     WaylandProtocolError
+		wantMsg  string
+	}{
+		{
+			name:     "error from the object's interface",
+			objectId: 2, // xdg_surface
+			code:     4,
+			message:  "wrong configure serial",
+			want:     WaylandProtocolError{objectId: 2, iface: "xdg_surface", code: 4, codeName: "invalid_serial", message: "wrong configure serial"},
+			wantMsg:  "display server sent an error about xdg_surface@2, code 4 (invalid_serial): wrong configure serial",
+		},
+		{
+			name:     "generic error about an object without an error enum",
+			objectId: 3, // wl_registry
+			code:     1,
+			message:  "invalid method 7",
+			want:     WaylandProtocolError{objectId: 3, iface: "wl_registry", code: 1, codeName: "invalid_method", message: "invalid method 7"},
+			wantMsg:  "display server sent an error about wl_registry@3, code 1 (invalid_method): invalid method 7",
+		},
+		{
+			name:     "error about the display",
+			objectId: waylandDisplayObjectId,
+			code:     0,
+			message:  "invalid object 12",
+			want:     WaylandProtocolError{objectId: 1, iface: "wl_display", code: 0, codeName: "invalid_object", message: "invalid object 12"},
+			wantMsg:  "display server sent an error about wl_display@1, code 0 (invalid_object): invalid object 12",
+		},
+		{
+			name:     "unknown object",
+			objectId: 42,
+			code:     2,
+			message:  "out of memory",
+			want:     WaylandProtocolError{objectId: 42, code: 2, codeName: "no_memory", message: "out of memory"},
+			wantMsg:  "display server sent an error about unknown object 42, code 2 (no_memory): out of memory",
+		},
+		{
+			name:     "unknown code",
+			objectId: 2,
+			code:     99,
+			message:  "something new",
+			want:     WaylandProtocolError{objectId: 2, iface: "xdg_surface", code: 99, message: "something new"},
+			wantMsg:  "display server sent an error about xdg_surface@2, code 99: something new",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, server := newTestConn(t)
+			conn.newId("xdg_surface")
+			conn.newId("wl_registry")
+			writeEvent(t, server, waylandDisplayObjectId, waylandWlDisplayEventError, displayErrorArgs(tt.objectId, tt.code, tt.message))
+			err := conn.Dispatch()
+			var protoErr *WaylandProtocolError
+			if !errors.As(err, &protoErr) {
+				t.Fatalf("expected a protocol error, got %v", err)
+			}
+			if *protoErr != tt.want {
+				t.Errorf("got %+v, want %+v", *protoErr, tt.want)
+			}
+			if protoErr.Error() != tt.wantMsg {
+				t.Errorf("got message %q, want %q", protoErr.Error(), tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestDisplayErrorAfterDeleteId(t *testing.T) {
+	conn, server := newTestConn(t)
+	surface := conn.newId("wl_surface")
+	writeEvent(t, server, waylandDisplayObjectId, waylandWlDisplayEventDeleteId, binary.LittleEndian.AppendUint32(nil, surface))
+	writeEvent(t, server, waylandDisplayObjectId, waylandWlDisplayEventError, displayErrorArgs(surface, 3, "late error"))
+	err := conn.Dispatch()
+	var protoErr *WaylandProtocolError
+	if !errors.As(err, &protoErr) {
+		t.Fatalf("expected a protocol error, got %v", err)
+	}
+	if protoErr.iface != "" {
+		t.Errorf("deleted object reported as %s", protoErr.iface)
+	}
+}
+
+func TestMalformedDisplayError(t *testing.T) {
+	conn, server := newTestConn(t)
+	args := displayErrorArgs(waylandDisplayObjectId, 0, "a message that's cut off")
+	writeEvent(t, server, waylandDisplayObjectId, waylandWlDisplayEventError, args[:len(args)-8])
+	err := conn.Dispatch()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	var protoErr *WaylandProtocolError
+	if errors.As(err, &protoErr) {
+		t.Fatalf("a malformed event decoded as %v", protoErr)
+	}
+}
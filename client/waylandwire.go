@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// A signed 24.8 fixed point number, the way wayland sends non-integer values
+type waylandFixed int32
+
+func (f waylandFixed) Float64() float64 {
+	return float64(f) / 256
+}
+
+func waylandFixedFromFloat64(v float64) waylandFixed {
+	return waylandFixed(v * 256)
+}
+
+type waylandArgType int
+
+const (
+	waylandArgInt waylandArgType = iota
+	waylandArgUint
+	waylandArgFixed
+	waylandArgString
+	waylandArgObject
+	waylandArgNewId
+	waylandArgArray
+	waylandArgFd
+)
+
+// Description of a single argument of a request or an event
+type waylandArgInfo struct {
+	name      string
+	typ       waylandArgType
+	iface     string // interface of an object or new_id argument, empty if any interface is allowed
+	allowNull bool
+}
+
+type waylandMessageInfo struct {
+	name  string
+	since uint32
+	args  []waylandArgInfo
+}
+
+// Description of an interface as it's declared in protocol xml files.
+// Requests and events are indexed by their opcodes.
+type waylandInterfaceInfo struct {
+	name     string
+	version  uint32
+	requests []waylandMessageInfo
+	events   []waylandMessageInfo
+	errors   map[uint32]string // names of the values of the interface's error enum
+}
+
+// Something that gives out file descriptors received from a display server
+type waylandFdSource interface {
+	TakeFd() (int, error)
+}
+
+// Encodes arguments of a request one after another
+type waylandWriter struct {
+	data []byte
+	fds  []int
+}
+
+func (w *waylandWriter) putUint(v uint32) {
+	w.data = binary.LittleEndian.AppendUint32(w.data, v)
+}
+
+func (w *waylandWriter) putInt(v int32) {
+	w.putUint(uint32(v))
+}
+
+func (w *waylandWriter) putFixed(v waylandFixed) {
+	w.putUint(uint32(v))
+}
+
+// strings are sent with a length that includes a string terminator.
+// a null string is sent as a length equal to 0.
+func (w *waylandWriter) putString(s string, allowNull bool) {
+	if s == "" && allowNull {
+		w.putUint(0)
+		return
+	}
+	w.data = append(w.data, waylandStringBytes(s)...)
+}
+
+func (w *waylandWriter) putArray(a []byte) {
+	w.putUint(uint32(len(a)))
+	w.data = append(w.data, a...)
+	w.data = append(w.data, make([]byte, roundUpToMultpl4(uint32(len(a)))-uint32(len(a)))...)
+}
+
+// file descriptors aren't part of the message, they're sent as ancillary data
+func (w *waylandWriter) putFd(fd int) {
+	w.fds = append(w.fds, fd)
+}
+
+var errWaylandShortMessage = errors.New("message too short")
+
+// Decodes arguments of an event one after another
+type waylandReader struct {
+	data []byte
+	fds  waylandFdSource
+}
+
+func (r *waylandReader) uint() (uint32, error) {
+	if len(r.data) < 4 {
+		return 0, errWaylandShortMessage
+	}
+	v := binary.LittleEndian.Uint32(r.data[:4])
+	r.data = r.data[4:]
+	return v, nil
+}
+
+func (r *waylandReader) int() (int32, error) {
+	v, err := r.uint()
+	return int32(v), err
+}
+
+func (r *waylandReader) fixed() (waylandFixed, error) {
+	v, err := r.uint()
+	return waylandFixed(v), err
+}
+
+// reads length prefixed bytes padded to a multiple of 4
+func (r *waylandReader) bytes() ([]byte, error) {
+	n, err := r.uint()
+	if err != nil {
+		return nil, err
+	}
+	padded := uint64(roundUpToMultpl4(n))
+	if uint64(n) > uint64(len(r.data)) || padded > uint64(len(r.data)) {
+		return nil, fmt.Errorf("length %d is longer than the rest of the message (%d bytes)", n, len(r.data))
+	}
+	v := r.data[:n]
+	r.data = r.data[padded:]
+	return v, nil
+}
+
+func (r *waylandReader) string() (string, error) {
+	b, err := r.bytes()
+	if err != nil {
+		return "", err
+	}
+	if len(b) == 0 {
+		return "", nil // a null string
+	}
+	if b[len(b)-1] != 0 {
+		return "", errors.New("string without a terminator")
+	}
+	if bytes.IndexByte(b[:len(b)-1], 0) >= 0 {
+		return "", errors.New("string with a terminator in the middle")
+	}
+	return string(b[:len(b)-1]), nil
+}
+
+func (r *waylandReader) array() ([]byte, error) {
+	b, err := r.bytes()
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), b...), nil
+}
+
+func (r *waylandReader) fd() (int, error) {
+	if r.fds == nil {
+		return -1, errors.New("no file descriptors to take from")
+	}
+	return r.fds.TakeFd()
+}
+
+func decodeError(event string, arg string, err error) error {
+	return fmt.Errorf("couldn't decode %s, argument %s: %w", event, arg, err)
+}
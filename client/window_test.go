@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/sys/unix"
+)
+
+var defaultGlobals = []fakeGlobal{
+	{"wl_compositor", 6},
+	{"wl_shm", 1},
+	{"xdg_wm_base", 6},
+	{"wl_seat", 9},
+	{"zwp_keyboard_shortcuts_inhibit_manager_v1", 1},
+	{"zxdg_decoration_manager_v1", 1},
+}
+
+// Starts a window connected to the fake compositor, which forwards keys to a local
+// tcp listener. Returns the end of the tcp connection the keys come to and a channel
+// that's closed when the window is done.
+func startWindow(t *testing.T, fc *fakeCompositor) (net.Conn, chan struct{}) {
+	t.Helper()
+	remote, _, finished := startWindowWithLink(t, fc)
+	return remote, finished
+}
+
+// The same as startWindow, returning the link the window forwards keys to as well
+func startWindowWithLink(t *testing.T, fc *fakeCompositor) (net.Conn, *targetLink, chan struct{}) {
+	t.Helper()
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	targetConn, err := net.DialTCP("tcp", nil, ln.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { targetConn.Close() })
+	remote, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { remote.Close() })
+	waylandConn, err := DisplayConnect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { waylandConn.Close() })
+	finished := make(chan struct{})
+	link := &targetLink{}
+	link.attach(newRemoteConn(targetConn), "tcp", "test", false)
+	go func() {
+		runWindow(waylandConn, link, nil)
+		close(finished)
+	}()
+	return remote, link, finished
+}
+
+func requestString(t *testing.T, req fakeRequest) string {
+	t.Helper()
+	r := waylandReader{data: req.body}
+	s, err := r.string()
+	if err != nil {
+		t.Fatalf("%s.%s: %v", req.iface, req.name, err)
+	}
+	return s
+}
+
+func requestUints(t *testing.T, req fakeRequest) []uint32 {
+	t.Helper()
+	r := waylandReader{data: req.body}
+	v := make([]uint32, 0)
+	for len(r.data) > 0 {
+		u, err := r.uint()
+		if err != nil {
+			t.Fatalf("%s.%s: %v", req.iface, req.name, err)
+		}
+		v = append(v, u)
+	}
+	return v
+}
+
+func TestWindowSetup(t *testing.T) {
+	fc := newFakeCompositor(t, defaultGlobals...)
+	startWindow(t, fc)
+
+	fc.expect("wl_display", "get_registry")
+	fc.expect("xdg_wm_base", "get_xdg_surface")
+	fc.expect("xdg_surface", "get_toplevel")
+	if title := requestString(t, fc.expect("xdg_toplevel", "set_title")); title != "virt-kbd: test" {
+		t.Errorf("title %q", title)
+	}
+	if appId := requestString(t, fc.expect("xdg_toplevel", "set_app_id")); appId != waylandAppId {
+		t.Errorf("app_id %q", appId)
+	}
+	fc.expect("zxdg_decoration_manager_v1", "get_toplevel_decoration")
+	if mode := requestUints(t, fc.expect("zxdg_toplevel_decoration_v1", "set_mode")); mode[0] != waylandZxdgToplevelDecorationV1ModeServerSide {
+		t.Errorf("decoration mode %d", mode[0])
+	}
+	fc.expect("wl_surface", "commit")
+
+	fc.sendConfigure(1234)
+	if serial := requestUints(t, fc.expect("xdg_surface", "ack_configure")); serial[0] != 1234 {
+		t.Errorf("acked serial %d, expected 1234", serial[0])
+	}
+	pool := fc.expect("wl_shm", "create_pool")
+	if len(pool.fds) != 1 {
+		t.Fatalf("create_pool came with %d file descriptors", len(pool.fds))
+	}
+	var stat syscall.Stat_t
+	if err := syscall.Fstat(pool.fds[0], &stat); err != nil {
+		t.Fatal(err)
+	}
+	syscall.Close(pool.fds[0])
+	size := requestUints(t, pool)[1]
+	if stat.Size != int64(700*700*colorChannels) || size != uint32(stat.Size) {
+		t.Errorf("pool size %d, shared memory size %d", size, stat.Size)
+	}
+	buffer := requestUints(t, fc.expect("wl_shm_pool", "create_buffer"))
+	if buffer[2] != 700 || buffer[3] != 700 || buffer[4] != 700*colorChannels || buffer[5] != waylandWlShmFormatXrgb8888 {
+		t.Errorf("create_buffer(%v)", buffer[1:])
+	}
+	fc.expect("wl_surface", "attach")
+	fc.expect("wl_surface", "commit")
+
+	fc.sendPing(77)
+	if serial := requestUints(t, fc.expect("xdg_wm_base", "pong")); serial[0] != 77 {
+		t.Errorf("pong with serial %d, expected 77", serial[0])
+	}
+}
+
+func TestWindowTitleFollowsTarget(t *testing.T) {
+	fc := newFakeCompositor(t, defaultGlobals...)
+	remote, link, _ := startWindowWithLink(t, fc)
+
+	fc.expect("xdg_toplevel", "set_title")
+	link.setPaused(true)
+	if title := requestString(t, fc.expect("xdg_toplevel", "set_title")); title != "virt-kbd: test (paused)" {
+		t.Errorf("title %q", title)
+	}
+	closeWhenDone(remote)
+	link.detach()
+	if title := requestString(t, fc.expect("xdg_toplevel", "set_title")); title != "virt-kbd: disconnected" {
+		t.Errorf("title %q", title)
+	}
+}
+
+func TestTruncateTitle(t *testing.T) {
+	long := strings.Repeat("a", maxTitleBytes-1) + "żółw"
+	got := truncateTitle(long)
+	if len(got) != maxTitleBytes-1 || !utf8.ValidString(got) {
+		t.Errorf("%q cut to %d bytes, valid %v", long, len(got), utf8.ValidString(got))
+	}
+	if got := truncateTitle("virt-kbd: żółw"); got != "virt-kbd: żółw" {
+		t.Errorf("a short title is cut to %q", got)
+	}
+}
+
+func TestWindowWithoutOptionalGlobals(t *testing.T) {
+	fc := newFakeCompositor(t, defaultGlobals[:4]...)
+	startWindow(t, fc)
+
+	fc.expect("wl_seat", "get_keyboard")
+	fc.sendConfigure(1)
+	fc.expect("xdg_surface", "ack_configure")
+	fc.expect("wl_surface", "attach")
+	for _, req := range fc.drain() {
+		if req.iface == "zxdg_toplevel_decoration_v1" || req.iface == "zwp_keyboard_shortcuts_inhibitor_v1" {
+			t.Errorf("unexpected %s.%s", req.iface, req.name)
+		}
+	}
+}
+
+func TestFakeCompositorUnknownInterface(t *testing.T) {
+	fc := newFakeCompositor(t)
+	fc.objects[2] = "wl_registry"
+	w := waylandWriter{}
+	w.putUint(1)
+	w.putString("wp_unknown_v1", false)
+	w.putUint(1)
+	w.putUint(3)
+	bind := WaylandHeader{objectId: 2, opcode: 0, msgSize: uint16(int(waylandHeaderSize) + len(w.data))}
+	// fails the test rather than crashing it
+	if _, err := fc.handleRequest(bind, w.data, &[]int{}); err == nil {
+		t.Error("binding an interface without a description succeeded")
+	}
+	fc.objects[3] = "wp_unknown_v1"
+	if _, err := fc.handleRequest(WaylandHeader{objectId: 3, msgSize: uint16(waylandHeaderSize)}, nil, &[]int{}); err == nil {
+		t.Error("a request to an object without a description succeeded")
+	}
+}
+
+func TestWindowForwardsKeys(t *testing.T) {
+	fc := newFakeCompositor(t, defaultGlobals...)
+	remote, finished := startWindow(t, fc)
+
+	fc.expect("wl_seat", "get_keyboard")
+	inhibit := requestUints(t, fc.expect("zwp_keyboard_shortcuts_inhibit_manager_v1", "inhibit_shortcuts"))
+	if inhibit[1] != fc.object("wl_surface") || inhibit[2] != fc.object("wl_seat") {
+		t.Errorf("inhibit_shortcuts(%v)", inhibit)
+	}
+
+	keymap, err := unix.MemfdCreate("keymap", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(keymap)
+	fc.sendKeymap(keymap, 0)
+	fc.sendKey(30, waylandWlKeyboardKeyStatePressed)
+	fc.sendKey(30, waylandWlKeyboardKeyStateReleased)
+
+	remote.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got := make([]byte, 12)
+	if _, err := io.ReadFull(remote, got); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{frameKey, 3, 0, 30, 0, 1, frameKey, 3, 0, 30, 0, 0}; !bytes.Equal(got, expected) {
+		t.Errorf("received %v, expected %v", got, expected)
+	}
+
+	fc.sendClose()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("window didn't close")
+	}
+}
+
+func TestWindowShowsLeds(t *testing.T) {
+	fc := newFakeCompositor(t, defaultGlobals...)
+	remote, _ := startWindow(t, fc)
+
+	fc.expect("xdg_surface", "get_toplevel")
+	fc.sendConfigure(1)
+	pool := fc.expect("wl_shm", "create_pool")
+	if len(pool.fds) != 1 {
+		t.Fatalf("create_pool came with %d file descriptors", len(pool.fds))
+	}
+	defer syscall.Close(pool.fds[0])
+	data, err := unix.Mmap(pool.fds[0], 0, int(requestUints(t, pool)[1]), unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Munmap(data)
+	fc.expect("wl_surface", "commit")
+	// the color in the middle of a LED
+	led := func(i int) uint32 {
+		x, y := ledMargin+i*(ledWidth+ledMargin)+ledWidth/2, ledMargin+ledHeight/2
+		return binary.LittleEndian.Uint32(data[uint32(y)*700*colorChannels+uint32(x)*colorChannels:])
+	}
+	for i := range ledShown {
+		if color := led(i); color != ledColorOff {
+			t.Errorf("LED %d has color %06x before the target sent any", i, color)
+		}
+	}
+
+	if _, err := remote.Write([]byte{frameLeds, 1, 0, byte(ledCapsLock | ledKana)}); err != nil {
+		t.Fatal(err)
+	}
+	fc.expect("wl_surface", "damage")
+	fc.expect("wl_surface", "commit")
+	for i, expected := range []uint32{ledColorOff, ledColorOn, ledColorOff} {
+		if color := led(i); color != expected {
+			t.Errorf("LED %d has color %06x, expected %06x", i, color, expected)
+		}
+	}
+}
+
+func TestLedIndicatorStop(t *testing.T) {
+	indicator, err := newLedIndicator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer indicator.Close()
+	fds := make([]int, 2)
+	if err := unix.Pipe2(fds, unix.O_CLOEXEC); err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(fds[0])
+	defer unix.Close(fds[1])
+	waited := make(chan error, 1)
+	go func() {
+		_, err := indicator.wait(fds[0])
+		waited <- err
+	}()
+	indicator.stop()
+	select {
+	case err := <-waited:
+		if !errors.Is(err, errIndicatorStopped) {
+			t.Errorf("wait returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("wait doesn't return once the indicator is stopped")
+	}
+	if _, err := indicator.wait(fds[0]); !errors.Is(err, errIndicatorStopped) {
+		t.Errorf("wait after stop returned %v", err)
+	}
+}
@@ -0,0 +1,518 @@
+// Package dgram is the datagram transport: the hello and the frames carried
+// over udp, for links where a lost tcp segment holds up everything sent after
+// it for a noticeable moment. The client dials sessions of it and the server
+// accepts them, each side with a socket of its own feeding datagrams to a Conn.
+//
+// Each datagram starts with its kind (a single byte) and the id of the session,
+// picked by the client (4 bytes, little endian). A data datagram goes on with an
+// acknowledgement, the sequence number of the first message the sender hasn't
+// received yet (4 bytes), and the messages, each made of a sequence number
+// (4 bytes), a length (2 bytes) and that many bytes of the stream. An empty
+// message ends the stream. A reset datagram tells the peer its session is unknown.
+//
+// The server doesn't start a session, or send anything but a single datagram no
+// bigger than the one it got, until the client proved it receives datagrams at
+// its address: the first data datagram of a session is answered with a cookie
+// datagram carrying a cookie (CookieSize bytes) the server derives from the
+// address and the session. The client then sends open datagrams, data
+// datagrams with the cookie between the session and the acknowledgement,
+// until the server answers with a data datagram.
+//
+// Every data datagram carries all the messages that haven't been acknowledged
+// yet (as many as fit), so a lost datagram is usually made up for by the next
+// one. The messages are sent again after retransmitInterval, doubling up to
+// maxRetransmitInterval while nothing new is acknowledged, and the session
+// ends after maxRetransmits. The receiving side passes them on in order, each
+// of them once.
+package dgram
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// kinds of datagrams
+const (
+	KindData   byte = 1
+	KindReset  byte = 2
+	KindCookie byte = 3
+	KindOpen   byte = 4
+)
+
+const (
+	HeaderSize         = 1 + 4 // the kind and the session
+	msgHeaderSize      = 4 + 2
+	MaxSize            = 1200 // fits in the MTU of pretty much any link
+	maxChunkSize       = 512  // bytes of the stream in a single message
+	maxUnacked         = 512  // messages sent and not acknowledged yet
+	CookieSize         = 16
+	retransmitInterval = 25 * time.Millisecond
+	// retransmits are this far apart at most, so they give up after about 12 seconds
+	maxRetransmitInterval = time.Second
+	maxRetransmits        = 15
+	keepaliveInterval     = 5 * time.Second
+	idleTimeout           = 30 * time.Second
+	// how long a closed session keeps sending what hasn't been acknowledged yet
+	lingerTimeout = 2 * time.Second
+)
+
+type message struct {
+	seq  uint32
+	data []byte
+}
+
+// Splits the body of a data datagram into the acknowledgement and the messages
+func parse(body []byte) (uint32, []message, error) {
+	if len(body) < 4 {
+		return 0, nil, errors.New("datagram too short")
+	}
+	ack := binary.LittleEndian.Uint32(body)
+	body = body[4:]
+	msgs := make([]message, 0)
+	for len(body) > 0 {
+		if len(body) < msgHeaderSize {
+			return 0, nil, errors.New("truncated message header")
+		}
+		seq := binary.LittleEndian.Uint32(body)
+		size := int(binary.LittleEndian.Uint16(body[4:]))
+		if len(body) < msgHeaderSize+size {
+			return 0, nil, fmt.Errorf("message %d has %d bytes, %d left in the datagram", seq, size, len(body)-msgHeaderSize)
+		}
+		msgs = append(msgs, message{seq: seq, data: body[msgHeaderSize : msgHeaderSize+size]})
+		body = body[msgHeaderSize+size:]
+	}
+	return ack, msgs, nil
+}
+
+// whether the body of a data datagram has the first message of the stream
+func StartsStream(body []byte) bool {
+	_, msgs, err := parse(body)
+	return err == nil && len(msgs) > 0 && msgs[0].seq == 0
+}
+
+func ResetDatagram(session uint32) []byte {
+	return binary.LittleEndian.AppendUint32([]byte{KindReset}, session)
+}
+
+func CookieDatagram(session uint32, cookie []byte) []byte {
+	return append(binary.LittleEndian.AppendUint32([]byte{KindCookie}, session), cookie...)
+}
+
+// A session of the datagram transport. It's a net.Conn, so the hello and the
+// frames are read and written the same way they are over tcp.
+type Conn struct {
+	session uint32
+	local   net.Addr
+	remote  net.Addr
+	send    func(datagram []byte) error
+	onClose func() // called once the session is over
+
+	mu             sync.Mutex
+	cookie         []byte // sent along until the server answers, nil if it didn't ask for one
+	answered       bool   // whether a data datagram came from the peer
+	nextSeq        uint32
+	unacked        []message
+	retransmits    int // since the peer last acknowledged something
+	nextRetransmit time.Time
+	lastSent       time.Time
+	writeClosed    bool // whether the end of the stream is sent
+	expected       uint32
+	outOfOrder     map[uint32][]byte
+	readBuf        []byte
+	readEOF        bool
+	closed         bool
+	closedAt       time.Time
+	err            error // why the session broke
+	lastHeard      time.Time
+	readDeadline   time.Time
+	writeDeadline  time.Time
+	readWake       chan struct{}
+	writeWake      chan struct{}
+	done           chan struct{} // closed once the session is over
+}
+
+// Returns a session sending its datagrams with send. Datagrams coming from the
+// peer are passed to HandleDatagram, onClose is called once the session is over.
+func NewConn(session uint32, local net.Addr, remote net.Addr, send func([]byte) error, onClose func()) *Conn {
+	c := &Conn{
+		session:    session,
+		local:      local,
+		remote:     remote,
+		send:       send,
+		onClose:    onClose,
+		outOfOrder: make(map[uint32][]byte),
+		lastHeard:  time.Now(),
+		readWake:   make(chan struct{}, 1),
+		writeWake:  make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	go c.tick()
+	return c
+}
+
+func wake(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// waits for a wake up or the deadline
+func waitFor(ch chan struct{}, deadline time.Time) {
+	if deadline.IsZero() {
+		<-ch
+		return
+	}
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case <-ch:
+	case <-timer.C:
+	}
+}
+
+func (c *Conn) isDone() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// ends the session, must be called with mu held
+func (c *Conn) finish(err error) {
+	if c.isDone() {
+		return
+	}
+	if c.err == nil {
+		c.err = err
+	}
+	close(c.done)
+	wake(c.readWake)
+	wake(c.writeWake)
+}
+
+func (c *Conn) Session() uint32 {
+	return c.session
+}
+
+// Ends the session, reads and writes return err from then on
+func (c *Conn) Fail(err error) {
+	c.mu.Lock()
+	c.finish(err)
+	c.mu.Unlock()
+}
+
+// Returns a data datagram, an open one while there's a cookie, with as many of
+// the messages not acknowledged yet as fit. Must be called with mu held.
+func (c *Conn) datagram() []byte {
+	var d []byte
+	if c.cookie != nil {
+		d = binary.LittleEndian.AppendUint32([]byte{KindOpen}, c.session)
+		d = append(d, c.cookie...)
+	} else {
+		d = binary.LittleEndian.AppendUint32([]byte{KindData}, c.session)
+	}
+	d = binary.LittleEndian.AppendUint32(d, c.expected)
+	for _, m := range c.unacked {
+		if len(d)+msgHeaderSize+len(m.data) > MaxSize {
+			break
+		}
+		d = binary.LittleEndian.AppendUint32(d, m.seq)
+		d = binary.LittleEndian.AppendUint16(d, uint16(len(m.data)))
+		d = append(d, m.data...)
+	}
+	c.lastSent = time.Now()
+	return d
+}
+
+// queues a message to be sent until it's acknowledged, must be called with mu held
+func (c *Conn) queue(m message) {
+	if len(c.unacked) == 0 {
+		c.resetRetransmits()
+	}
+	c.unacked = append(c.unacked, m)
+}
+
+// starts retransmitting at the shortest interval, must be called with mu held
+func (c *Conn) resetRetransmits() {
+	c.retransmits = 0
+	c.nextRetransmit = time.Now().Add(retransmitInterval)
+}
+
+// how long to wait after the nth retransmit without an acknowledgement
+func retransmitBackoff(n int) time.Duration {
+	return min(retransmitInterval<<n, maxRetransmitInterval)
+}
+
+// Retransmits, keeps the session alive and ends it when the peer is gone or,
+// once it's closed, everything it sent is acknowledged
+func (c *Conn) tick() {
+	defer c.onClose()
+	ticker := time.NewTicker(retransmitInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case now := <-ticker.C:
+			c.mu.Lock()
+			switch {
+			case now.Sub(c.lastHeard) > idleTimeout:
+				c.finish(os.ErrDeadlineExceeded)
+			case c.closed && (len(c.unacked) == 0 || now.Sub(c.closedAt) > lingerTimeout):
+				c.finish(net.ErrClosed)
+			}
+			var d []byte
+			switch {
+			case c.isDone():
+			case len(c.unacked) > 0 && c.retransmits >= maxRetransmits && !now.Before(c.nextRetransmit):
+				c.finish(os.ErrDeadlineExceeded)
+			case len(c.unacked) > 0 && !now.Before(c.nextRetransmit):
+				c.retransmits++
+				c.nextRetransmit = now.Add(retransmitBackoff(c.retransmits))
+				d = c.datagram()
+			case len(c.unacked) == 0 && now.Sub(c.lastSent) >= keepaliveInterval:
+				d = c.datagram()
+			}
+			c.mu.Unlock()
+			if d != nil {
+				c.send(d)
+			}
+		}
+	}
+}
+
+// Handles a datagram of the session coming from the peer
+func (c *Conn) HandleDatagram(kind byte, body []byte) {
+	c.mu.Lock()
+	if c.isDone() {
+		c.mu.Unlock()
+		return
+	}
+	switch kind {
+	case KindReset:
+		c.finish(syscall.ECONNRESET)
+		c.mu.Unlock()
+		return
+	case KindCookie:
+		// only comes before the server answered
+		var d []byte
+		if len(body) == CookieSize && !c.answered {
+			c.cookie = append([]byte(nil), body...)
+			c.lastHeard = time.Now()
+			c.resetRetransmits()
+			d = c.datagram()
+		}
+		c.mu.Unlock()
+		if d != nil {
+			c.send(d)
+		}
+		return
+	case KindOpen:
+		// the listener checked the cookie before the session was started
+		if len(body) < CookieSize {
+			c.mu.Unlock()
+			return
+		}
+		body = body[CookieSize:]
+	case KindData:
+		c.cookie, c.answered = nil, true
+	}
+	ack, msgs, err := parse(body)
+	if err != nil {
+		c.mu.Unlock()
+		return
+	}
+	c.lastHeard = time.Now()
+	acked := 0
+	for acked < len(c.unacked) && c.unacked[acked].seq < ack {
+		acked++
+	}
+	if acked > 0 {
+		c.unacked = c.unacked[acked:]
+		c.resetRetransmits()
+		wake(c.writeWake)
+	}
+	for _, m := range msgs {
+		switch {
+		case m.seq < c.expected:
+			// a duplicate, it's acknowledged again below
+		case m.seq > c.expected:
+			if m.seq-c.expected < maxUnacked {
+				c.outOfOrder[m.seq] = append([]byte(nil), m.data...)
+			}
+		default:
+			c.deliver(m.data)
+			for {
+				data, ok := c.outOfOrder[c.expected]
+				if !ok {
+					break
+				}
+				delete(c.outOfOrder, c.expected)
+				c.deliver(data)
+			}
+		}
+	}
+	var d []byte
+	if len(msgs) > 0 {
+		d = c.datagram()
+	}
+	c.mu.Unlock()
+	if d != nil {
+		c.send(d)
+	}
+}
+
+// passes the next message on to the reader, must be called with mu held
+func (c *Conn) deliver(data []byte) {
+	c.expected++
+	if len(data) == 0 {
+		c.readEOF = true
+	} else if !c.readEOF {
+		c.readBuf = append(c.readBuf, data...)
+	}
+	wake(c.readWake)
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	for {
+		c.mu.Lock()
+		switch {
+		case c.closed:
+			c.mu.Unlock()
+			return 0, net.ErrClosed
+		case len(c.readBuf) > 0:
+			n := copy(b, c.readBuf)
+			c.readBuf = c.readBuf[n:]
+			c.mu.Unlock()
+			return n, nil
+		case c.readEOF:
+			c.mu.Unlock()
+			return 0, io.EOF
+		case c.err != nil:
+			err := c.err
+			c.mu.Unlock()
+			return 0, err
+		case !c.readDeadline.IsZero() && !time.Now().Before(c.readDeadline):
+			c.mu.Unlock()
+			return 0, os.ErrDeadlineExceeded
+		}
+		deadline := c.readDeadline
+		c.mu.Unlock()
+		waitFor(c.readWake, deadline)
+	}
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	n := 0
+	for n < len(b) {
+		c.mu.Lock()
+		for len(c.unacked) >= maxUnacked && c.err == nil && !c.closed {
+			if !c.writeDeadline.IsZero() && !time.Now().Before(c.writeDeadline) {
+				c.mu.Unlock()
+				return n, os.ErrDeadlineExceeded
+			}
+			deadline := c.writeDeadline
+			c.mu.Unlock()
+			waitFor(c.writeWake, deadline)
+			c.mu.Lock()
+		}
+		if c.err != nil {
+			err := c.err
+			c.mu.Unlock()
+			return n, err
+		}
+		if c.closed || c.writeClosed {
+			c.mu.Unlock()
+			return n, net.ErrClosed
+		}
+		chunk := b[n:min(len(b), n+maxChunkSize)]
+		c.queue(message{seq: c.nextSeq, data: append([]byte(nil), chunk...)})
+		c.nextSeq++
+		d := c.datagram()
+		c.mu.Unlock()
+		c.send(d)
+		n += len(chunk)
+	}
+	return n, nil
+}
+
+// sends the end of the stream, must be called with mu held
+func (c *Conn) endStream() []byte {
+	if c.writeClosed || c.err != nil {
+		return nil
+	}
+	c.writeClosed = true
+	c.queue(message{seq: c.nextSeq})
+	c.nextSeq++
+	return c.datagram()
+}
+
+// Tells the peer nothing more is going to be written. Reading goes on until the
+// peer does the same.
+func (c *Conn) CloseWrite() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return net.ErrClosed
+	}
+	d := c.endStream()
+	c.mu.Unlock()
+	if d != nil {
+		c.send(d)
+	}
+	return nil
+}
+
+// Ends the stream. What's been written and not acknowledged yet is still sent
+// for lingerTimeout.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return net.ErrClosed
+	}
+	c.closed = true
+	c.closedAt = time.Now()
+	d := c.endStream()
+	wake(c.readWake)
+	wake(c.writeWake)
+	c.mu.Unlock()
+	if d != nil {
+		c.send(d)
+	}
+	return nil
+}
+
+func (c *Conn) LocalAddr() net.Addr  { return c.local }
+func (c *Conn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	c.mu.Unlock()
+	wake(c.readWake)
+	return nil
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.writeDeadline = t
+	c.mu.Unlock()
+	wake(c.writeWake)
+	return nil
+}
@@ -0,0 +1,165 @@
+package dgram
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Links two sessions of the datagram transport in memory. Datagrams going either
+// way are lost with the probability loss.
+func datagramPair(t *testing.T, loss float64) (*Conn, *Conn) {
+	t.Helper()
+	var mu sync.Mutex
+	r := rand.New(rand.NewPCG(3, 4))
+	var a, b *Conn
+	link := func(to **Conn) func([]byte) error {
+		return func(d []byte) error {
+			mu.Lock()
+			lost := r.Float64() < loss
+			peer := *to
+			mu.Unlock()
+			if !lost {
+				d = append([]byte(nil), d...)
+				go peer.HandleDatagram(d[0], d[HeaderSize:])
+			}
+			return nil
+		}
+	}
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	mu.Lock()
+	a = NewConn(1, addr, addr, link(&b), func() {})
+	b = NewConn(1, addr, addr, link(&a), func() {})
+	mu.Unlock()
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	return a, b
+}
+
+func TestStream(t *testing.T) {
+	a, b := datagramPair(t, 0.3)
+	sent := make([]byte, 0)
+	for i := range 4000 {
+		sent = append(sent, byte(i), byte(i>>8))
+	}
+	go func() {
+		// in writes of all sizes, some split into several messages
+		for rest := sent; len(rest) > 0; {
+			n := min(len(rest), 1+len(rest)%700)
+			if _, err := a.Write(rest[:n]); err != nil {
+				t.Error(err)
+				return
+			}
+			rest = rest[n:]
+		}
+		a.CloseWrite()
+	}()
+	b.SetReadDeadline(time.Now().Add(10 * time.Second))
+	received, err := io.ReadAll(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(received, sent) {
+		t.Fatalf("received %d bytes, sent %d, they differ", len(received), len(sent))
+	}
+	// the other way still works after the end of the stream
+	if _, err := b.Write([]byte("led")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 3)
+	a.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err := io.ReadFull(a, buf); err != nil || string(buf) != "led" {
+		t.Fatalf("read %q, %v", buf, err)
+	}
+}
+
+func TestReadDeadline(t *testing.T) {
+	_, b := datagramPair(t, 0)
+	b.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, err := b.Read(make([]byte, 1)); !isTimeout(err) {
+		t.Fatalf("read returned %v, expected a timeout", err)
+	}
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func TestReset(t *testing.T) {
+	a, _ := datagramPair(t, 0)
+	// the server doesn't know about the session
+	a.HandleDatagram(KindReset, nil)
+	if _, err := a.Read(make([]byte, 1)); !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("read returned %v, expected a reset", err)
+	}
+	if _, err := a.Write([]byte{1}); !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("write returned %v, expected a reset", err)
+	}
+}
+
+// A session whose datagrams are kept rather than sent
+func recordingDatagramConn(t *testing.T) (*Conn, func() [][]byte) {
+	t.Helper()
+	var mu sync.Mutex
+	sent := make([][]byte, 0)
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	c := NewConn(7, addr, addr, func(d []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		sent = append(sent, append([]byte(nil), d...))
+		return nil
+	}, func() {})
+	t.Cleanup(func() { c.Close() })
+	return c, func() [][]byte {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(sent)
+	}
+}
+
+func TestCookie(t *testing.T) {
+	c, sent := recordingDatagramConn(t)
+	c.Write([]byte("hello"))
+	cookie := bytes.Repeat([]byte{0xc0}, CookieSize)
+	c.HandleDatagram(KindCookie, cookie)
+	// the messages go out again right away, with the cookie
+	d := sent()[len(sent())-1]
+	if d[0] != KindOpen || !bytes.Equal(d[HeaderSize:HeaderSize+CookieSize], cookie) {
+		t.Fatalf("expected an open datagram with the cookie, got %v", d)
+	}
+	// until the server answers
+	c.HandleDatagram(KindData, []byte{1, 0, 0, 0})
+	c.Write([]byte("key"))
+	if d := sent()[len(sent())-1]; d[0] != KindData {
+		t.Fatalf("expected a data datagram once the server answered, got %v", d)
+	}
+	// a cookie coming after that is ignored
+	c.HandleDatagram(KindCookie, cookie)
+	c.Write([]byte("key"))
+	if d := sent()[len(sent())-1]; d[0] != KindData {
+		t.Fatalf("a late cookie is sent along: %v", d)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	c, sent := recordingDatagramConn(t)
+	c.Write([]byte("hello"))
+	time.Sleep(time.Second)
+	// sent once and then after 25, 50, 100, 200 and 400 ms, rather than every 25 ms
+	if n := len(sent()); n < 4 || n > 8 {
+		t.Errorf("sent %d datagrams in a second", n)
+	}
+	if backoff := retransmitBackoff(maxRetransmits); backoff != maxRetransmitInterval {
+		t.Errorf("the last retransmit waits %v", backoff)
+	}
+}
@@ -0,0 +1,3 @@
+module virt-kbd/dgram
+
+go 1.23.6
@@ -0,0 +1,291 @@
+// Package dnsmsg is a minimal codec of DNS messages, enough for multicast DNS
+// (RFC 6762) and DNS service discovery (RFC 6763). The server advertises itself
+// with it and the client looks for servers.
+package dnsmsg
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// types of records
+const (
+	TypeA   uint16 = 1
+	TypePTR uint16 = 12
+	TypeTXT uint16 = 16
+	TypeSRV uint16 = 33
+	TypeANY uint16 = 255 // in questions only: records of every type
+)
+
+const (
+	classIN uint16 = 1
+	// the top bit of the class: a question asking for a unicast response or
+	// a record replacing the ones cached for its name
+	classTopBit uint16 = 0x8000
+)
+
+const headerSize = 12
+
+// A question asking for the records of a name
+type Question struct {
+	Name    string
+	Type    uint16
+	Unicast bool // whether a unicast response is asked for
+}
+
+// A resource record. Only the fields of its type are set.
+type Record struct {
+	Name   string
+	Type   uint16
+	Flush  bool // whether the record replaces the ones cached for its name
+	TTL    uint32
+	Target string   // PTR and SRV
+	Port   uint16   // SRV
+	TXT    []string // TXT
+	IP     net.IP   // A
+}
+
+// A query or a response
+type Message struct {
+	ID         uint16
+	Response   bool
+	Questions  []Question
+	Answers    []Record
+	Additional []Record
+}
+
+// Encodes the message without compressing the names
+func (m *Message) Encode() ([]byte, error) {
+	b := make([]byte, headerSize)
+	binary.BigEndian.PutUint16(b, m.ID)
+	if m.Response {
+		b[2] = 0x84 // a response, authoritative
+	}
+	binary.BigEndian.PutUint16(b[4:], uint16(len(m.Questions)))
+	binary.BigEndian.PutUint16(b[6:], uint16(len(m.Answers)))
+	binary.BigEndian.PutUint16(b[10:], uint16(len(m.Additional)))
+	var err error
+	for _, q := range m.Questions {
+		if b, err = appendName(b, q.Name); err != nil {
+			return nil, err
+		}
+		class := classIN
+		if q.Unicast {
+			class |= classTopBit
+		}
+		b = binary.BigEndian.AppendUint16(b, q.Type)
+		b = binary.BigEndian.AppendUint16(b, class)
+	}
+	for _, r := range append(append([]Record(nil), m.Answers...), m.Additional...) {
+		if b, err = appendRecord(b, r); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func appendName(b []byte, name string) ([]byte, error) {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, fmt.Errorf("invalid name %q", name)
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0), nil
+}
+
+func appendRecord(b []byte, r Record) ([]byte, error) {
+	b, err := appendName(b, r.Name)
+	if err != nil {
+		return nil, err
+	}
+	class := classIN
+	if r.Flush {
+		class |= classTopBit
+	}
+	b = binary.BigEndian.AppendUint16(b, r.Type)
+	b = binary.BigEndian.AppendUint16(b, class)
+	b = binary.BigEndian.AppendUint32(b, r.TTL)
+	lengthAt := len(b)
+	b = append(b, 0, 0)
+	switch r.Type {
+	case TypeA:
+		ip := r.IP.To4()
+		if ip == nil {
+			return nil, fmt.Errorf("%v isn't an IPv4 address", r.IP)
+		}
+		b = append(b, ip...)
+	case TypePTR:
+		if b, err = appendName(b, r.Target); err != nil {
+			return nil, err
+		}
+	case TypeSRV:
+		b = append(b, 0, 0, 0, 0) // priority and weight
+		b = binary.BigEndian.AppendUint16(b, r.Port)
+		if b, err = appendName(b, r.Target); err != nil {
+			return nil, err
+		}
+	case TypeTXT:
+		if len(r.TXT) == 0 {
+			b = append(b, 0)
+		}
+		for _, s := range r.TXT {
+			if len(s) > 255 {
+				return nil, fmt.Errorf("TXT string %q is too long", s)
+			}
+			b = append(b, byte(len(s)))
+			b = append(b, s...)
+		}
+	default:
+		return nil, fmt.Errorf("can't encode a record of type %d", r.Type)
+	}
+	binary.BigEndian.PutUint16(b[lengthAt:], uint16(len(b)-lengthAt-2))
+	return b, nil
+}
+
+// returned when a message ends before what it says it holds
+var ErrTruncated = errors.New("truncated DNS message")
+
+// Parses a message, following compressed names
+func Parse(b []byte) (*Message, error) {
+	if len(b) < headerSize {
+		return nil, ErrTruncated
+	}
+	m := &Message{ID: binary.BigEndian.Uint16(b), Response: b[2]&0x80 != 0}
+	counts := []int{
+		int(binary.BigEndian.Uint16(b[4:])),
+		int(binary.BigEndian.Uint16(b[6:])),
+		int(binary.BigEndian.Uint16(b[8:])),
+		int(binary.BigEndian.Uint16(b[10:])),
+	}
+	off := headerSize
+	for range counts[0] {
+		name, next, err := readName(b, off)
+		if err != nil {
+			return nil, err
+		}
+		if next+4 > len(b) {
+			return nil, ErrTruncated
+		}
+		class := binary.BigEndian.Uint16(b[next+2:])
+		m.Questions = append(m.Questions, Question{
+			Name:    name,
+			Type:    binary.BigEndian.Uint16(b[next:]),
+			Unicast: class&classTopBit != 0,
+		})
+		off = next + 4
+	}
+	for section, n := range counts[1:] {
+		for range n {
+			r, next, err := readRecord(b, off)
+			if err != nil {
+				return nil, err
+			}
+			off = next
+			switch section {
+			case 0:
+				m.Answers = append(m.Answers, r)
+			case 2:
+				m.Additional = append(m.Additional, r)
+			}
+		}
+	}
+	return m, nil
+}
+
+// Reads a possibly compressed name at off. Returns it together with the offset
+// following it.
+func readName(b []byte, off int) (string, int, error) {
+	labels := make([]string, 0)
+	next := -1
+	for jumps := 0; ; {
+		if off >= len(b) {
+			return "", 0, ErrTruncated
+		}
+		size := int(b[off])
+		switch {
+		case size == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return strings.Join(labels, ".") + ".", next, nil
+		case size&0xc0 == 0xc0:
+			if off+2 > len(b) {
+				return "", 0, ErrTruncated
+			}
+			if jumps++; jumps > 16 {
+				return "", 0, errors.New("too many compression pointers in a DNS name")
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(b[off:]) & 0x3fff)
+		case size > 63:
+			return "", 0, fmt.Errorf("invalid label length %d in a DNS name", size)
+		default:
+			if off+1+size > len(b) {
+				return "", 0, ErrTruncated
+			}
+			labels = append(labels, string(b[off+1:off+1+size]))
+			off += 1 + size
+		}
+	}
+}
+
+func readRecord(b []byte, off int) (Record, int, error) {
+	name, off, err := readName(b, off)
+	if err != nil {
+		return Record{}, 0, err
+	}
+	if off+10 > len(b) {
+		return Record{}, 0, ErrTruncated
+	}
+	class := binary.BigEndian.Uint16(b[off+2:])
+	r := Record{
+		Name:  name,
+		Type:  binary.BigEndian.Uint16(b[off:]),
+		Flush: class&classTopBit != 0,
+		TTL:   binary.BigEndian.Uint32(b[off+4:]),
+	}
+	size := int(binary.BigEndian.Uint16(b[off+8:]))
+	start, end := off+10, off+10+size
+	if end > len(b) {
+		return Record{}, 0, ErrTruncated
+	}
+	data := b[start:end]
+	switch r.Type {
+	case TypeA:
+		if size != 4 {
+			return Record{}, 0, errors.New("invalid A record")
+		}
+		r.IP = net.IP(append([]byte(nil), data...))
+	case TypePTR:
+		if r.Target, _, err = readName(b, start); err != nil {
+			return Record{}, 0, err
+		}
+	case TypeSRV:
+		if size < 7 {
+			return Record{}, 0, errors.New("invalid SRV record")
+		}
+		r.Port = binary.BigEndian.Uint16(data[4:])
+		if r.Target, _, err = readName(b, start+6); err != nil {
+			return Record{}, 0, err
+		}
+	case TypeTXT:
+		for len(data) > 0 {
+			n := int(data[0])
+			if 1+n > len(data) {
+				return Record{}, 0, errors.New("invalid TXT record")
+			}
+			if n > 0 {
+				r.TXT = append(r.TXT, string(data[1:1+n]))
+			}
+			data = data[1+n:]
+		}
+	}
+	return r, end, nil
+}
@@ -0,0 +1,78 @@
+package dnsmsg
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const service = "_virt-kbd._tcp.local."
+
+func TestRoundTrip(t *testing.T) {
+	m := &Message{
+		ID:        4242,
+		Response:  true,
+		Questions: []Question{{Name: service, Type: TypePTR, Unicast: true}},
+		Answers:   []Record{{Name: service, Type: TypePTR, TTL: 4500, Target: "pi-desk." + service}},
+		Additional: []Record{
+			{Name: "pi-desk." + service, Type: TypeSRV, Flush: true, TTL: 120, Port: 3002, Target: "pi-desk.local."},
+			{Name: "pi-desk." + service, Type: TypeTXT, Flush: true, TTL: 4500, TXT: []string{"v=3", "udp=1"}},
+			{Name: "pi-desk.local.", Type: TypeA, Flush: true, TTL: 120, IP: net.IPv4(192, 168, 1, 30).To4()},
+		},
+	}
+	data, err := m.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, m) {
+		t.Errorf("parsed %+v, expected %+v", parsed, m)
+	}
+	for n := range len(data) {
+		if _, err := Parse(data[:n]); err == nil {
+			t.Errorf("a message cut to %d bytes is parsed", n)
+		}
+	}
+}
+
+func TestParseCompressedResponse(t *testing.T) {
+	resp, err := (&Message{Response: true, Answers: []Record{{Name: service, Type: TypePTR, TTL: 10, Target: "pi-desk." + service}}}).Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an SRV record named with a pointer to the target of the PTR record, as
+	// responders usually compress names
+	ptrTarget := strings.Index(string(resp), "\x07pi-desk")
+	srv := []byte{0xc0, byte(ptrTarget), 0, byte(TypeSRV), 0x80, 1, 0, 0, 0, 10, 0, 8, 0, 0, 0, 0, 0x0b, 0xba, 0xc0, byte(ptrTarget + 8)}
+	resp = append(resp, srv...)
+	resp[7] = 2
+	m, err := Parse(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Answers) != 2 {
+		t.Fatalf("got %d answers, expected 2", len(m.Answers))
+	}
+	r := m.Answers[1]
+	if r.Type != TypeSRV || !r.Flush || r.Name != "pi-desk."+service || r.Port != 3002 || r.Target != service {
+		t.Errorf("unexpected record %+v", r)
+	}
+}
+
+func TestEncodeInvalid(t *testing.T) {
+	for _, r := range []Record{
+		{Name: "a..local.", Type: TypePTR, Target: service},
+		{Name: strings.Repeat("a", 64) + ".local.", Type: TypeA, IP: net.IPv4(10, 0, 0, 1)},
+		{Name: "pi.local.", Type: TypeA, IP: net.ParseIP("fe80::1")},
+		{Name: "pi.local.", Type: TypeTXT, TXT: []string{strings.Repeat("a", 256)}},
+		{Name: "pi.local.", Type: 28},
+	} {
+		if _, err := (&Message{Answers: []Record{r}}).Encode(); err == nil {
+			t.Errorf("%+v is encoded", r)
+		}
+	}
+}
@@ -0,0 +1,3 @@
+module virt-kbd/dnsmsg
+
+go 1.23.6
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"sync"
+)
+
+// How keys of several clients connected at the same time are handled
+type arbitrationMode string
+
+const (
+	// the client that connected first has control until it disconnects, then
+	// the one that's been waiting the longest gets it
+	arbitrationExclusive arbitrationMode = "exclusive"
+	// a client pressing a key takes control over from the one that had it
+	arbitrationTakeover arbitrationMode = "takeover"
+	// keys of all the clients are injected
+	arbitrationMerge arbitrationMode = "merge"
+)
+
+func parseArbitrationMode(s string) (arbitrationMode, error) {
+	switch mode := arbitrationMode(s); mode {
+	case arbitrationExclusive, arbitrationTakeover, arbitrationMerge:
+		return mode, nil
+	case "":
+		return arbitrationTakeover, nil
+	}
+	return "", fmt.Errorf("unknown arbitration mode %q, expected exclusive, takeover or merge", s)
+}
+
+// a change of control to tell a client about
+type controlNotification struct {
+	client  *clientConn
+	control bool
+}
+
+// An arbiter decides whose keys get injected when several clients are
+// connected. All the keys go through it, so injection is serialized. Keys
+// held by a client that loses control or disconnects are released, unless
+// another client holds them on the same devices.
+type arbiter struct {
+	mu       sync.Mutex
+	notifyMu sync.Mutex // keeps notifications in the order of the changes
+	mode     arbitrationMode
+	clients  []*clientConn // in the order they connected
+	// how many clients hold each key on each of the devices
+	holders map[inputSink]map[int]int
+}
+
+func newArbiter(mode arbitrationMode) *arbiter {
+	return &arbiter{mode: mode, holders: make(map[inputSink]map[int]int)}
+}
+
+// Registers a newly connected client and tells it whether it has control
+func (a *arbiter) join(c *clientConn) {
+	a.mu.Lock()
+	a.clients = append(a.clients, c)
+	switch a.mode {
+	case arbitrationMerge:
+		c.control = true
+	case arbitrationExclusive:
+		c.control = a.owner() == c
+	}
+	a.unlockAndNotify([]controlNotification{{c, c.control}})
+}
+
+// Removes a disconnected client, releasing its keys
+func (a *arbiter) leave(c *clientConn) {
+	a.mu.Lock()
+	a.releaseAll(c)
+	for i, other := range a.clients {
+		if other == c {
+			a.clients = append(a.clients[:i], a.clients[i+1:]...)
+			break
+		}
+	}
+	notifications := make([]controlNotification, 0)
+	if a.mode == arbitrationExclusive && c.control {
+		if next := a.owner(); next != nil {
+			next.control = true
+			notifications = append(notifications, controlNotification{next, true})
+		}
+	}
+	c.control = false
+	a.unlockAndNotify(notifications)
+}
+
+// Injects a key of a client if it has control or can take it. Returns whether
+// the key was passed on to the devices: it's dropped if the client doesn't
+// have control, or if it's a release of a key the client doesn't hold.
+func (a *arbiter) inject(c *clientConn, key int, pressed bool) (bool, error) {
+	a.mu.Lock()
+	notifications := make([]controlNotification, 0)
+	if a.mode == arbitrationTakeover && pressed && !c.control {
+		for _, other := range a.clients {
+			if other.control {
+				a.releaseAll(other)
+				other.control = false
+				notifications = append(notifications, controlNotification{other, false})
+			}
+		}
+		c.control = true
+		notifications = append(notifications, controlNotification{c, true})
+	}
+	var err error
+	injected := false
+	switch {
+	case !c.control:
+		c.log.Debug("dropped a key, the client doesn't have control", keyAttr(key))
+	case pressed:
+		injected = true
+		// a key that couldn't be pressed isn't held, it mustn't keep another
+		// client's release of it from going through
+		if err = c.sink.KeyDown(key); err == nil && !c.held[key] {
+			c.held[key] = true
+			a.hold(c.devices, key, 1)
+		}
+	case c.held[key]:
+		err = a.release(c, key)
+		injected = true
+	}
+	a.unlockAndNotify(notifications)
+	return injected, err
+}
+
+// changes how many clients hold a key on devices by n, returns how many are
+// left. Must be called with mu held.
+func (a *arbiter) hold(devices inputSink, key int, n int) int {
+	keys := a.holders[devices]
+	if keys == nil {
+		keys = make(map[int]int)
+		a.holders[devices] = keys
+	}
+	keys[key] += n
+	left := keys[key]
+	if left <= 0 {
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(a.holders, devices)
+		}
+	}
+	return left
+}
+
+// drops a key held by a client, it's released once no other client holds it.
+// Must be called with mu held.
+func (a *arbiter) release(c *clientConn, key int) error {
+	delete(c.held, key)
+	if a.hold(c.devices, key, -1) > 0 {
+		c.log.Debug("the key is still held by another client", keyAttr(key))
+		return nil
+	}
+	return c.sink.KeyUp(key)
+}
+
+// Returns how many clients are connected
+func (a *arbiter) count() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.clients)
+}
+
+// the client with exclusive control: the one connected the longest
+func (a *arbiter) owner() *clientConn {
+	if len(a.clients) == 0 {
+		return nil
+	}
+	return a.clients[0]
+}
+
+// drops all the keys a client holds, must be called with mu held
+func (a *arbiter) releaseAll(c *clientConn) {
+	for _, key := range slices.Sorted(maps.Keys(c.held)) {
+		if err := a.release(c, key); err != nil {
+			c.log.Error("couldn't release a key", keyAttr(key), errAttr(err))
+		}
+	}
+}
+
+// Unlocks mu and tells clients about their control. Notifications are queued
+// outside of mu, but in the order the changes were made.
+func (a *arbiter) unlockAndNotify(notifications []controlNotification) {
+	if len(notifications) == 0 {
+		a.mu.Unlock()
+		return
+	}
+	a.notifyMu.Lock()
+	defer a.notifyMu.Unlock()
+	a.mu.Unlock()
+	for _, n := range notifications {
+		if n.control {
+			n.client.log.Info("the client has control")
+		} else {
+			n.client.log.Info("the client doesn't have control")
+		}
+		if err := n.client.send(frameControl, encodeControlMsg(n.control)); err != nil && !errors.Is(err, net.ErrClosed) {
+			n.client.log.Error("couldn't notify the client about control", errAttr(err))
+		}
+	}
+}
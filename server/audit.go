@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Where the audit log is kept and how it's rotated, eg.
+//
+//	"audit": {"path": "/var/log/virt-kbd/audit.jsonl", "max_size_mb": 10, "keep": 5}
+type auditConfig struct {
+	Path      string `json:"path"`        // no audit log is kept if it's empty
+	MaxSizeMB int    `json:"max_size_mb"` // the size the file is rotated at, 10 by default
+	Keep      int    `json:"keep"`        // how many rotated files are kept, 5 by default
+}
+
+// A line of the audit log. Keys are never recorded, only how many were sent.
+type auditRecord struct {
+	Time      time.Time     `json:"time"`
+	Event     string        `json:"event"` // connect, refuse, deny or disconnect
+	Conn      uint64        `json:"conn"`
+	Identity  string        `json:"identity"`
+	Client    string        `json:"client,omitempty"` // the name the client said hello with
+	Remote    string        `json:"remote"`
+	Transport string        `json:"transport"`
+	Reason    string        `json:"reason,omitempty"`  // why a connection was refused or a key denied
+	Session   *auditSession `json:"session,omitempty"` // set once the client disconnects
+}
+
+type auditSession struct {
+	Start    time.Time `json:"start"`
+	Duration float64   `json:"duration_seconds"`
+	Events   uint64    `json:"events"` // key events the client sent
+	Denied   uint64    `json:"denied"` // key presses rejected by its policy
+}
+
+// An append-only audit log of the sessions, a json object per line. Once the
+// file grows past maxSize it's renamed to <path>.1, the one before to <path>.2
+// and so on, the ones past keep are removed. A nil log records nothing.
+type auditLog struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	keep    int
+	f       *os.File
+	size    int64
+}
+
+// Opens the audit log as cfg says, appending to the file if it's there
+func openAuditLog(cfg auditConfig) (*auditLog, error) {
+	l := &auditLog{path: cfg.Path, maxSize: 10 << 20, keep: 5}
+	if cfg.MaxSizeMB > 0 {
+		l.maxSize = int64(cfg.MaxSizeMB) << 20
+	}
+	if cfg.Keep > 0 {
+		l.keep = cfg.Keep
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *auditLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return errors.New("couldn't open the audit log: " + err.Error())
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return errors.New("couldn't open the audit log: " + err.Error())
+	}
+	l.f, l.size = f, info.Size()
+	return nil
+}
+
+// Appends r as an event, stamped with the current time
+func (l *auditLog) record(event string, r auditRecord) {
+	if l == nil {
+		return
+	}
+	r.Time, r.Event = time.Now(), event
+	line, err := json.Marshal(r)
+	if err != nil {
+		slog.Error("couldn't encode an audit record", errAttr(err))
+		return
+	}
+	line = append(line, '\n')
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return
+	}
+	if l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			slog.Error("couldn't rotate the audit log", errAttr(err))
+			if l.f == nil {
+				return
+			}
+		}
+	}
+	n, err := l.f.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		slog.Error("couldn't write to the audit log", errAttr(err))
+	}
+}
+
+// Shifts the rotated files by one and starts a new file
+func (l *auditLog) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	l.f = nil
+	os.Remove(l.path + "." + strconv.Itoa(l.keep))
+	for i := l.keep - 1; i > 0; i-- {
+		err := os.Rename(l.path+"."+strconv.Itoa(i), l.path+"."+strconv.Itoa(i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.Rename(l.path, l.path+".1"); err != nil {
+		return err
+	}
+	return l.open()
+}
+
+func (l *auditLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+// Returns the files of the audit log at path, from the oldest to the newest
+func auditFiles(path string) ([]string, error) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	rotated := make(map[int]string)
+	for _, match := range matches {
+		if n, err := strconv.Atoi(strings.TrimPrefix(match, path+".")); err == nil && n > 0 {
+			rotated[n] = match
+		}
+	}
+	files := make([]string, 0, len(rotated)+1)
+	for _, n := range slices.Backward(slices.Sorted(maps.Keys(rotated))) {
+		files = append(files, rotated[n])
+	}
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	}
+	return files, nil
+}
+
+// What an audit query looks for. Zero values match everything.
+type auditFilter struct {
+	since time.Time
+	until time.Time
+	user  string // an identity, a client name or, for unix sockets, a user
+}
+
+func (f *auditFilter) matches(r *auditRecord) bool {
+	if !f.since.IsZero() && r.Time.Before(f.since) {
+		return false
+	}
+	if !f.until.IsZero() && r.Time.After(f.until) {
+		return false
+	}
+	return f.user == "" || f.user == r.Identity || "unix:"+f.user == r.Identity || f.user == r.Client
+}
+
+// Writes the lines of the files matching f to w, unchanged
+func queryAudit(files []string, f auditFilter, w io.Writer) error {
+	for _, path := range files {
+		if err := queryAuditFile(path, f, w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func queryAuditFile(path string, f auditFilter, w io.Writer) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		var r auditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		if f.matches(&r) {
+			if _, err := fmt.Fprintf(w, "%s\n", scanner.Bytes()); err != nil {
+				return err
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// Parses a point in time given as RFC 3339, a date or a duration before now, eg. "24h"
+func parseAuditTime(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected eg. 2026-01-02T15:04:05Z, 2026-01-02 or 24h", s)
+}
+
+// The audit command: prints the records of the audit log in a time range and of a user
+func runAudit(args []string) int {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to the json config file telling where the audit log is")
+	file := fs.String("file", "", "path to the audit log, instead of the one from the config")
+	since := fs.String("since", "", "only records from this time on, eg. 2026-01-02T15:04:05Z, 2026-01-02 or 24h (that long ago)")
+	until := fs.String("until", "", "only records up to this time")
+	user := fs.String("user", "", "only records of this client identity (eg. 192.168.1.20 or unix:alice), unix user or client name")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s audit [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	path := *file
+	if path == "" {
+		cfg, err := loadConfig(*configPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		path = cfg.Audit.Path
+	}
+	if path == "" {
+		fmt.Fprintln(os.Stderr, "no audit log, give its path with -file or the config with -config")
+		return 1
+	}
+	f := auditFilter{user: *user}
+	now := time.Now()
+	for _, t := range []struct {
+		flag string
+		to   *time.Time
+	}{{*since, &f.since}, {*until, &f.until}} {
+		if t.flag == "" {
+			continue
+		}
+		var err error
+		if *t.to, err = parseAuditTime(t.flag, now); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	files, err := auditFiles(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	if err := queryAudit(files, f, w); err != nil {
+		fmt.Fprintln(os.Stderr, "couldn't read the audit log: "+err.Error())
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// reads the records of an audit log file
+func readAudit(t *testing.T, path string) []auditRecord {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := make([]auditRecord, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var r auditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("invalid audit record %q: %v", scanner.Text(), err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	audit, err := openAuditLog(auditConfig{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer audit.Close()
+	policies, err := newPolicySet(map[string]policyConfig{"*": {Deny: []string{"b"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := newRecordingSink()
+	srv := &server{arbiter: newArbiter(arbitrationTakeover), devices: sharedDevices(sink), policies: policies, audit: audit}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go srv.serve(context.Background(), ln)
+
+	conn := dialServer(t, ln.Addr().String())
+	conn.Write(helloWithName("alice-laptop"))
+	readControl(t, conn)
+	sendKeys(t, conn, down(30), up(30), down(48), up(48))
+	sink.expect(t, down(30), up(30))
+	conn.Close()
+
+	var records []auditRecord
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if records = readAudit(t, path); len(records) == 3 {
+			break
+		}
+	}
+	if len(records) != 3 {
+		t.Fatalf("expected connect, deny and disconnect records, got %+v", records)
+	}
+	for i, event := range []string{"connect", "deny", "disconnect"} {
+		r := records[i]
+		if r.Event != event || r.Identity != "127.0.0.1" || r.Client != "alice-laptop" || r.Transport != "tcp" || r.Conn != records[0].Conn {
+			t.Errorf("unexpected %s record %+v", event, r)
+		}
+	}
+	if records[1].Reason != "on the denylist" {
+		t.Errorf("unexpected reason of the denial %q", records[1].Reason)
+	}
+	session := records[2].Session
+	if session == nil || session.Events != 4 || session.Denied != 1 || session.Duration <= 0 {
+		t.Errorf("unexpected session %+v", session)
+	}
+	// what was typed isn't recorded
+	if data, _ := os.ReadFile(path); bytes.Contains(data, []byte("KEY_B")) || bytes.Contains(data, []byte(`"key`)) {
+		t.Errorf("the audit log records keys:\n%s", data)
+	}
+}
+
+func TestAuditRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	audit, err := openAuditLog(auditConfig{Path: path, Keep: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit.maxSize = 400
+	for conn := uint64(1); conn <= 20; conn++ {
+		audit.record("connect", auditRecord{Conn: conn, Identity: "127.0.0.1", Remote: "127.0.0.1:4000", Transport: "tcp"})
+	}
+	audit.Close()
+
+	files, err := auditFiles(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{path + ".2", path + ".1", path}
+	if strings.Join(files, " ") != strings.Join(expected, " ") {
+		t.Fatalf("files %v, expected %v", files, expected)
+	}
+	var buf bytes.Buffer
+	if err := queryAudit(files, auditFilter{}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	for i, line := range lines {
+		var r auditRecord
+		json.Unmarshal([]byte(line), &r)
+		// the oldest records are gone, the rest come in order
+		if r.Conn != uint64(20-len(lines)+i+1) {
+			t.Errorf("record %d is of connection %d: %s", i, r.Conn, buf.String())
+		}
+	}
+	for _, file := range files {
+		if info, _ := os.Stat(file); info.Size() > 400 {
+			t.Errorf("%s has %d bytes", file, info.Size())
+		}
+	}
+}
+
+func TestAuditQuery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	day := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+	var data []byte
+	for i, identity := range []string{"192.168.1.20", "unix:alice", "192.168.1.30", "unix:alice"} {
+		line, _ := json.Marshal(auditRecord{Time: day.Add(time.Duration(i) * time.Hour), Event: "connect", Conn: uint64(i + 1), Identity: identity, Client: "host" + string(rune('a'+i))})
+		data = append(append(data, line...), '\n')
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		filter   auditFilter
+		expected []uint64
+	}{
+		{auditFilter{}, []uint64{1, 2, 3, 4}},
+		{auditFilter{user: "alice"}, []uint64{2, 4}},
+		{auditFilter{user: "192.168.1.30"}, []uint64{3}},
+		{auditFilter{user: "hosta"}, []uint64{1}},
+		{auditFilter{since: day.Add(time.Hour), until: day.Add(2 * time.Hour)}, []uint64{2, 3}},
+		{auditFilter{since: day.Add(time.Hour), user: "unix:alice"}, []uint64{2, 4}},
+	} {
+		var buf bytes.Buffer
+		if err := queryAudit([]string{path}, test.filter, &buf); err != nil {
+			t.Fatal(err)
+		}
+		conns := make([]uint64, 0)
+		for _, line := range strings.Fields(buf.String()) {
+			var r auditRecord
+			json.Unmarshal([]byte(line), &r)
+			conns = append(conns, r.Conn)
+		}
+		if !slices.Equal(conns, test.expected) {
+			t.Errorf("%+v matched %v, expected %v", test.filter, conns, test.expected)
+		}
+	}
+
+	now := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	for s, expected := range map[string]time.Time{
+		"2026-03-01T10:00:00Z": time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC),
+		"2026-03-01":           time.Date(2026, 3, 1, 0, 0, 0, 0, time.Local),
+		"36h":                  time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+	} {
+		if got, err := parseAuditTime(s, now); err != nil || !got.Equal(expected) {
+			t.Errorf("%q parsed as %v (%v), expected %v", s, got, err, expected)
+		}
+	}
+	if _, err := parseAuditTime("yesterday", now); err == nil {
+		t.Error("yesterday is accepted")
+	}
+}
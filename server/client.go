@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// how long writing a frame to a client can take before the client is disconnected
+const writeTimeout = time.Second
+
+// how many frames can wait for a client to read them. A client that lets more
+// pile up is disconnected.
+const outQueueSize = 64
+
+// A client connected to the server.
+//
+// Frames sent to it are queued and written by a goroutine of its own, so
+// whoever sends them (the arbiter, the LEDs of a device) never waits for a
+// slow client.
+type clientConn struct {
+	conn     net.Conn
+	addr     string // where the client connects from
+	identity string // what the client's policy is chosen by
+	name     string // what the client calls itself, may be empty
+	log      *slog.Logger
+	sink     inputSink
+	devices  inputSink    // the devices sink injects into, the same for all the clients unless they get their own
+	held     map[int]bool // keys injected for this client and not released yet, guarded by the arbiter
+	control  bool         // whether the client's keys are injected, guarded by the arbiter
+
+	outMu   sync.Mutex
+	out     chan []byte   // frames waiting for the writer
+	closed  bool          // whether out is closed, guarded by outMu
+	written chan struct{} // closed once the writer is done
+}
+
+// Returns a client logging with the attributes of log, its name and its identity
+func newClientConn(conn net.Conn, identity string, name string, log *slog.Logger) *clientConn {
+	return &clientConn{
+		log:      log.With("client", name, "identity", identity),
+		conn:     conn,
+		addr:     connAddr(conn),
+		identity: identity,
+		name:     name,
+		held:     make(map[int]bool),
+		out:      make(chan []byte, outQueueSize),
+		written:  make(chan struct{}),
+	}
+}
+
+func (c *clientConn) String() string {
+	if c.name != "" {
+		return c.name + " (" + c.addr + ")"
+	}
+	return c.addr
+}
+
+// the name of the client, or the host it connects from if it hasn't given one
+func (c *clientConn) displayName() string {
+	if c.name != "" {
+		return c.name
+	}
+	return c.identity
+}
+
+// Queues a frame for the client. Returns net.ErrClosed if the client is being
+// disconnected already.
+func (c *clientConn) send(frameType byte, payload []byte) error {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	select {
+	case c.out <- appendFrame(nil, frameType, payload):
+		return nil
+	default:
+		// the reading side of handleConnection ends when the connection is closed
+		c.conn.Close()
+		return fmt.Errorf("%d frames are waiting for %s, disconnecting it", outQueueSize, c)
+	}
+}
+
+// Tells the client what went wrong with its message
+func (c *clientConn) reportError(err error) {
+	if err := c.send(frameError, []byte(err.Error())); err != nil && !errors.Is(err, net.ErrClosed) {
+		c.log.Error("couldn't send an error", errAttr(err))
+	}
+}
+
+// Writes the queued frames until closeWriter is called. If a write fails, the
+// connection is closed and the frames queued afterwards are dropped.
+func (c *clientConn) writeLoop() {
+	defer close(c.written)
+	for frame := range c.out {
+		c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if _, err := c.conn.Write(frame); err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				c.log.Error("couldn't write to the client", errAttr(err))
+			}
+			c.conn.Close()
+			for range c.out {
+			}
+			return
+		}
+	}
+}
+
+// Stops accepting frames and waits until the ones queued so far are written
+func (c *clientConn) closeWriter() {
+	c.outMu.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.out)
+	}
+	c.outMu.Unlock()
+	<-c.written
+}
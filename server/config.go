@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+const defaultPort = 3001
+
+// Configuration of the server, read from a json file, eg.
+//
+//	{
+//		"port": 3001,
+//		"udp": true,
+//		"mdns": true,
+//		"metrics": "127.0.0.1:9101",
+//		"log": {"level": "info", "format": "json"},
+//		"audit": {"path": "/var/log/virt-kbd/audit.jsonl", "max_size_mb": 10, "keep": 5},
+//		"arbitration": "takeover",
+//		"devices": "shared",
+//		"unix_socket": {"path": "/run/virt-kbd.sock", "mode": "0660", "group": "virt-kbd"},
+//		"policies": {
+//			"*": {"deny": ["sysrq", "power"], "deny_combos": [["ctrl", "alt", "delete"]]},
+//			"192.168.1.20": {"allow": ["a", "b", "c", "enter"]},
+//			"192.168.1.30": {"allow_power": true},
+//			"unix:alice": {"allow_power": true}
+//		}
+//	}
+type config struct {
+	Port int `json:"port"`
+	// whether to also take clients over the datagram transport, on the same port number
+	UDP bool `json:"udp"`
+	// what happens when several clients are connected: exclusive, takeover (the default) or merge
+	Arbitration string `json:"arbitration"`
+	// shared (the default) for all the clients to use the same devices, per_client
+	// for each client to get devices of its own
+	Devices string `json:"devices"`
+	// whether to advertise the server on the local network with multicast DNS
+	MDNS bool `json:"mdns"`
+	// the network interface to advertise on, the system's default one if it's empty
+	MDNSInterface string `json:"mdns_interface"`
+	// address to serve /metrics and /healthz on over http, nothing is served if it's empty
+	Metrics string `json:"metrics"`
+	// level and format of the logs and whether keys are logged
+	Log logConfig `json:"log"`
+	// an audit log of the sessions, none is kept if its path is empty
+	Audit auditConfig `json:"audit"`
+	// key policies by client identity, "*" is for the clients not listed
+	Policies map[string]policyConfig `json:"policies"`
+	// a unix socket to listen on besides the port, none if the path is empty
+	UnixSocket unixSocketConfig `json:"unix_socket"`
+}
+
+// Keys a client is allowed to inject. Keys are written as key codes or names
+// from linux/input-event-codes.h, eg. "KEY_SYSRQ", "sysrq" or "99".
+type policyConfig struct {
+	Allow      []string   `json:"allow"`       // if not empty, only these keys are allowed
+	Deny       []string   `json:"deny"`        // keys that are never allowed
+	DenyCombos [][]string `json:"deny_combos"` // a key is rejected if it completes one of these
+	AllowPower bool       `json:"allow_power"` // power, sleep, suspend and wake up keys are rejected unless it's true
+}
+
+// Reads a config file. An empty path gives the default config.
+func loadConfig(path string) (*config, error) {
+	cfg := &config{Port: defaultPort}
+	if path == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.New("couldn't read the config: " + err.Error())
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, errors.New("couldn't parse the config: " + err.Error())
+	}
+	return cfg, nil
+}
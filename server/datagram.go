@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"virt-kbd/dgram"
+)
+
+// A listener of the datagram transport. Sessions are told apart by the address
+// of the client and the id it picked.
+type dgramListener struct {
+	conn      *net.UDPConn
+	secret    []byte // what cookies are derived from
+	accepted  chan *dgram.Conn
+	done      chan struct{} // closed once the listener is closed
+	closeConn sync.Once
+
+	mu       sync.Mutex
+	sessions map[dgramKey]*dgram.Conn
+	closed   bool
+}
+
+type dgramKey struct {
+	addr    string
+	session uint32
+}
+
+func listenDatagram(addr string) (*dgramListener, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	l := &dgramListener{
+		conn:     conn,
+		secret:   secret,
+		accepted: make(chan *dgram.Conn, 16),
+		done:     make(chan struct{}),
+		sessions: make(map[dgramKey]*dgram.Conn),
+	}
+	go l.readLoop()
+	return l, nil
+}
+
+func (l *dgramListener) readLoop() {
+	buf := make([]byte, 2*dgram.MaxSize)
+	for {
+		n, from, err := l.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		if n < dgram.HeaderSize {
+			continue
+		}
+		kind, session := buf[0], binary.LittleEndian.Uint32(buf[1:])
+		body := buf[dgram.HeaderSize:n]
+		key := dgramKey{addr: from.String(), session: session}
+		l.mu.Lock()
+		c, ok := l.sessions[key]
+		opened := !ok && kind == dgram.KindOpen && len(body) >= dgram.CookieSize && l.validCookie(key, body[:dgram.CookieSize])
+		if opened {
+			c = l.newSession(key, from, body[dgram.CookieSize:])
+		}
+		l.mu.Unlock()
+		switch {
+		case c != nil:
+			c.HandleDatagram(kind, body)
+		case opened:
+			// the listener is closed or too many sessions wait to be accepted
+			l.conn.WriteToUDP(dgram.ResetDatagram(session), from)
+		case kind == dgram.KindData && dgram.StartsStream(body) && n >= dgram.HeaderSize+dgram.CookieSize:
+			// nothing is kept until the client sends the cookie back
+			l.conn.WriteToUDP(dgram.CookieDatagram(session, l.cookie(key, time.Now())), from)
+		case kind == dgram.KindData:
+			l.conn.WriteToUDP(dgram.ResetDatagram(session), from)
+		}
+	}
+}
+
+// how long a cookie is good for, it's accepted until the end of the next period
+const cookiePeriod = time.Minute
+
+// Derives the cookie of a session from the address of the client, the id of
+// the session and the period t falls in
+func (l *dgramListener) cookie(key dgramKey, t time.Time) []byte {
+	mac := hmac.New(sha256.New, l.secret)
+	binary.Write(mac, binary.LittleEndian, t.Unix()/int64(cookiePeriod/time.Second))
+	binary.Write(mac, binary.LittleEndian, key.session)
+	mac.Write([]byte(key.addr))
+	return mac.Sum(nil)[:dgram.CookieSize]
+}
+
+func (l *dgramListener) validCookie(key dgramKey, cookie []byte) bool {
+	now := time.Now()
+	return hmac.Equal(cookie, l.cookie(key, now)) || hmac.Equal(cookie, l.cookie(key, now.Add(-cookiePeriod)))
+}
+
+// Starts a session if the body of an open datagram begins one: it has the
+// first message of the stream. Must be called with mu held.
+func (l *dgramListener) newSession(key dgramKey, from *net.UDPAddr, body []byte) *dgram.Conn {
+	if l.closed || !dgram.StartsStream(body) {
+		return nil
+	}
+	c := dgram.NewConn(key.session, l.conn.LocalAddr(), from, func(d []byte) error {
+		_, err := l.conn.WriteToUDP(d, from)
+		return err
+	}, func() { l.remove(key) })
+	select {
+	case l.accepted <- c:
+	default:
+		// too many sessions waiting to be accepted, the client will try again
+		c.Fail(syscall.ECONNREFUSED)
+		return nil
+	}
+	l.sessions[key] = c
+	return c
+}
+
+func (l *dgramListener) remove(key dgramKey) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.sessions, key)
+	if l.closed && len(l.sessions) == 0 {
+		l.closeConn.Do(func() { l.conn.Close() })
+	}
+}
+
+func (l *dgramListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.accepted:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Stops accepting sessions. The ones accepted so far go on until they're closed.
+func (l *dgramListener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return net.ErrClosed
+	}
+	l.closed = true
+	close(l.done)
+	// sessions nobody is going to accept
+	for {
+		select {
+		case c := <-l.accepted:
+			c.Fail(net.ErrClosed)
+			continue
+		default:
+		}
+		break
+	}
+	if len(l.sessions) == 0 {
+		l.closeConn.Do(func() { l.conn.Close() })
+	}
+	return nil
+}
+
+func (l *dgramListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
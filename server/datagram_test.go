@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"virt-kbd/dgram"
+)
+
+// starts a server taking clients over the datagram transport on an ephemeral loopback port
+func startDatagramServer(t *testing.T) (*net.UDPAddr, *recordingSink) {
+	t.Helper()
+	policies, err := newPolicySet(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := listenDatagram("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := newRecordingSink()
+	srv := &server{arbiter: newArbiter(arbitrationTakeover), devices: sharedDevices(sink), policies: policies}
+	go srv.serve(context.Background(), ln)
+	t.Cleanup(func() { ln.Close() })
+	return ln.Addr().(*net.UDPAddr), sink
+}
+
+func datagramArgs(addr *net.UDPAddr) []string {
+	return []string{"-udp", addr.IP.String(), strconv.Itoa(addr.Port)}
+}
+
+// A relay between a client and a server losing, duplicating and reordering
+// datagrams in both directions
+type lossyRelay struct {
+	conn *net.UDPConn // where the client sends its datagrams
+
+	mu     sync.Mutex
+	rand   *rand.Rand
+	client *net.UDPAddr
+}
+
+func startLossyRelay(t *testing.T, server *net.UDPAddr) *lossyRelay {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream, err := net.DialUDP("udp", nil, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &lossyRelay{conn: conn, rand: rand.New(rand.NewPCG(1, 2))}
+	t.Cleanup(func() {
+		conn.Close()
+		upstream.Close()
+	})
+	go r.relay(func(buf []byte) (int, error) {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err == nil {
+			r.mu.Lock()
+			r.client = from
+			r.mu.Unlock()
+		}
+		return n, err
+	}, func(d []byte) { upstream.Write(d) })
+	go r.relay(upstream.Read, func(d []byte) {
+		r.mu.Lock()
+		client := r.client
+		r.mu.Unlock()
+		conn.WriteToUDP(d, client)
+	})
+	return r
+}
+
+// Passes datagrams on. Some are lost, some duplicated and some held back until
+// the next one is passed on.
+func (r *lossyRelay) relay(read func([]byte) (int, error), write func([]byte)) {
+	var held []byte
+	for {
+		buf := make([]byte, 2*dgram.MaxSize)
+		n, err := read(buf)
+		if err != nil {
+			return
+		}
+		d := buf[:n]
+		r.mu.Lock()
+		p := r.rand.Float64()
+		r.mu.Unlock()
+		switch {
+		case p < 0.3:
+			continue
+		case p < 0.4:
+			write(d)
+			write(d)
+		case p < 0.5 && held == nil:
+			held = d
+			continue
+		default:
+			write(d)
+		}
+		if held != nil {
+			write(held)
+			held = nil
+		}
+	}
+}
+
+func (r *lossyRelay) addr() *net.UDPAddr {
+	return r.conn.LocalAddr().(*net.UDPAddr)
+}
+
+func TestE2EDatagram(t *testing.T) {
+	addr, sink := startDatagramServer(t)
+	events := []sinkEvent{down(42), down(30), up(30), up(42), down(29), down(46), up(46), up(29)}
+	runClientWithArgs(t, datagramArgs(addr), events...)
+	sink.expect(t, events...)
+}
+
+func TestE2EDatagramLossy(t *testing.T) {
+	addr, sink := startDatagramServer(t)
+	relay := startLossyRelay(t, addr)
+	events := make([]sinkEvent, 0)
+	for i := range 50 {
+		key := 16 + i%20
+		events = append(events, down(key), up(key))
+	}
+	runClientWithArgs(t, datagramArgs(relay.addr()), events...)
+	// every key gets through once and releases aren't reordered with presses
+	sink.expect(t, events...)
+}
+
+// encodes a data datagram of a session, carrying messages starting at seq
+func dataDatagram(session uint32, seq uint32, msgs ...[]byte) []byte {
+	d := binary.LittleEndian.AppendUint32([]byte{dgram.KindData}, session)
+	d = binary.LittleEndian.AppendUint32(d, 0)
+	for i, m := range msgs {
+		d = binary.LittleEndian.AppendUint32(d, seq+uint32(i))
+		d = binary.LittleEndian.AppendUint16(d, uint16(len(m)))
+		d = append(d, m...)
+	}
+	return d
+}
+
+// turns a data datagram into an open one carrying cookie
+func withCookie(d []byte, cookie []byte) []byte {
+	open := append([]byte{dgram.KindOpen}, d[1:dgram.HeaderSize]...)
+	open = append(open, cookie...)
+	return append(open, d[dgram.HeaderSize:]...)
+}
+
+// reads a datagram from the server, nil if none comes within timeout
+func readDatagram(t *testing.T, conn *net.UDPConn, timeout time.Duration) []byte {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 2*dgram.MaxSize)
+	n, err := conn.Read(buf)
+	if isTimeout(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf[:n]
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// sends the first datagram of a session and returns the cookie the server answers with
+func requestCookie(t *testing.T, conn *net.UDPConn, session uint32) []byte {
+	t.Helper()
+	d := readDatagramAfter(t, conn, dataDatagram(session, 0, hello()))
+	if len(d) != dgram.HeaderSize+dgram.CookieSize || d[0] != dgram.KindCookie || binary.LittleEndian.Uint32(d[1:]) != session {
+		t.Fatalf("expected a cookie, got %v", d)
+	}
+	return d[dgram.HeaderSize:]
+}
+
+func readDatagramAfter(t *testing.T, conn *net.UDPConn, d []byte) []byte {
+	t.Helper()
+	conn.Write(d)
+	return readDatagram(t, conn, 5*time.Second)
+}
+
+func dialDatagramServer(t *testing.T, addr *net.UDPAddr) *net.UDPConn {
+	t.Helper()
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestDatagramDuplicates(t *testing.T) {
+	addr, sink := startDatagramServer(t)
+	conn := dialDatagramServer(t, addr)
+	first := withCookie(dataDatagram(7, 0, hello(), keyMsg(down(30))), requestCookie(t, conn, 7))
+	conn.Write(first)
+	conn.Write(first)
+	// the press again along with the release, then the release once more
+	conn.Write(dataDatagram(7, 1, keyMsg(down(30)), keyMsg(up(30))))
+	conn.Write(dataDatagram(7, 2, keyMsg(up(30))))
+	sink.expect(t, down(30), up(30))
+}
+
+func TestDatagramOutOfOrder(t *testing.T) {
+	addr, sink := startDatagramServer(t)
+	conn := dialDatagramServer(t, addr)
+	conn.Write(withCookie(dataDatagram(7, 0, hello()), requestCookie(t, conn, 7)))
+	conn.Write(dataDatagram(7, 2, keyMsg(up(30))))
+	time.Sleep(50 * time.Millisecond)
+	if got := sink.recorded(); len(got) != 0 {
+		t.Fatalf("sink got %v before the missing message", got)
+	}
+	conn.Write(dataDatagram(7, 1, keyMsg(down(30))))
+	sink.expect(t, down(30), up(30))
+}
+
+func TestDatagramUnknownSession(t *testing.T) {
+	addr, _ := startDatagramServer(t)
+	conn := dialDatagramServer(t, addr)
+	// a session the server doesn't know about, eg. one it forgot after restarting
+	d := readDatagramAfter(t, conn, dataDatagram(7, 5, keyMsg(down(30))))
+	if len(d) != dgram.HeaderSize || d[0] != dgram.KindReset || binary.LittleEndian.Uint32(d[1:]) != 7 {
+		t.Fatalf("expected a reset of the session, got %v", d)
+	}
+}
+
+func TestDatagramCookie(t *testing.T) {
+	addr, sink := startDatagramServer(t)
+	conn := dialDatagramServer(t, addr)
+	// the first datagram gets a cookie no bigger than itself and nothing else:
+	// no session is started, so nothing is sent again
+	first := dataDatagram(7, 0, hello(), keyMsg(down(30)))
+	cookie := readDatagramAfter(t, conn, first)
+	if len(cookie) > len(first) || cookie[0] != dgram.KindCookie {
+		t.Fatalf("expected a cookie, got %v", cookie)
+	}
+	cookie = cookie[dgram.HeaderSize:]
+	if d := readDatagram(t, conn, 200*time.Millisecond); d != nil {
+		t.Fatalf("the server sent %v before it got the cookie back", d)
+	}
+	// a wrong cookie, one for another session and one sent from another address are ignored
+	wrong := append([]byte(nil), cookie...)
+	wrong[0]++
+	other := dialDatagramServer(t, addr)
+	conn.Write(withCookie(first, wrong))
+	conn.Write(withCookie(dataDatagram(8, 0, hello()), cookie))
+	other.Write(withCookie(first, cookie))
+	if d := readDatagram(t, conn, 200*time.Millisecond); d != nil {
+		t.Fatalf("the server answered %v to a wrong cookie", d)
+	}
+	if d := readDatagram(t, other, 10*time.Millisecond); d != nil {
+		t.Fatalf("the server answered %v to a cookie from another address", d)
+	}
+	if got := sink.recorded(); len(got) != 0 {
+		t.Fatalf("sink got %v without a valid cookie", got)
+	}
+	// the session starts once the cookie comes back
+	if d := readDatagramAfter(t, conn, withCookie(first, cookie)); d == nil || d[0] != dgram.KindData {
+		t.Fatalf("expected the server to acknowledge the session, got %v", d)
+	}
+	sink.expect(t, down(30))
+}
@@ -0,0 +1,768 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// End to end tests: the server runs in the test process with a sink recording the
+// keys, the client is built from ../client and runs headless, reading the keys
+// from its stdin. Every change of the protocol is supposed to keep them passing.
+
+// A key event as it got to a sink
+type sinkEvent struct {
+	key  int
+	down bool
+}
+
+func (e sinkEvent) String() string {
+	if e.down {
+		return fmt.Sprintf("%d down", e.key)
+	}
+	return fmt.Sprintf("%d up", e.key)
+}
+
+// An inputSink that remembers every key injected into it. Its LEDs are set
+// by the tests.
+type recordingSink struct {
+	ledBroadcaster
+	mu     sync.Mutex
+	events []sinkEvent
+	added  chan struct{}
+}
+
+func newRecordingSink() *recordingSink {
+	return &recordingSink{added: make(chan struct{}, 1)}
+}
+
+func (s *recordingSink) KeyDown(key int) error {
+	s.record(sinkEvent{key: key, down: true})
+	return nil
+}
+
+func (s *recordingSink) KeyUp(key int) error {
+	s.record(sinkEvent{key: key, down: false})
+	return nil
+}
+
+func (s *recordingSink) record(e sinkEvent) {
+	s.mu.Lock()
+	s.events = append(s.events, e)
+	s.mu.Unlock()
+	select {
+	case s.added <- struct{}{}:
+	default:
+	}
+}
+
+func (s *recordingSink) recorded() []sinkEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]sinkEvent(nil), s.events...)
+}
+
+// waits until the sink gets n events and checks they're the expected ones. It also
+// waits a moment longer to catch events that aren't expected at all.
+func (s *recordingSink) expect(t *testing.T, expected ...sinkEvent) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for len(s.recorded()) < len(expected) {
+		select {
+		case <-s.added:
+		case <-timeout:
+			t.Fatalf("timed out, sink got %v, expected %v", s.recorded(), expected)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := s.recorded(); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("sink got %v, expected %v", got, expected)
+	}
+}
+
+func down(key int) sinkEvent { return sinkEvent{key: key, down: true} }
+func up(key int) sinkEvent   { return sinkEvent{key: key, down: false} }
+
+// encodes a key event the way a client sends it
+func keyMsg(e sinkEvent) []byte {
+	state := byte(0)
+	if e.down {
+		state = 1
+	}
+	return []byte{frameKey, keyMsgSize, 0, byte(e.key), byte(e.key >> 8), state}
+}
+
+// what a client sends right after connecting
+func hello() []byte {
+	return helloWithName("")
+}
+
+func helloWithName(name string) []byte {
+	return append(append([]byte(protocolMagic), protocolVersion, byte(len(name))), name...)
+}
+
+// starts a server on an ephemeral loopback port with the default config
+func startServer(t *testing.T) (string, *recordingSink) {
+	t.Helper()
+	return startServerWithConfig(t, config{})
+}
+
+func startServerWithConfig(t *testing.T, cfg config) (string, *recordingSink) {
+	t.Helper()
+	policies, err := newPolicySet(cfg.Policies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mode, err := parseArbitrationMode(cfg.Arbitration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := newRecordingSink()
+	srv := &server{arbiter: newArbiter(mode), devices: sharedDevices(sink), policies: policies}
+	go srv.serve(context.Background(), ln)
+	t.Cleanup(func() { ln.Close() })
+	return ln.Addr().String(), sink
+}
+
+var clientBin string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "virt-kbd-e2e")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	clientBin = filepath.Join(dir, "client")
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+var buildClientOnce sync.Once
+var buildClientErr error
+
+// builds the client the first time it's needed. Tests that need it are skipped
+// in short mode or if there's no go toolchain around.
+func buildClient(t *testing.T) {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("builds the client, skipped in short mode")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("no go toolchain to build the client with")
+	}
+	buildClientOnce.Do(func() {
+		cmd := exec.Command("go", "build", "-o", clientBin, ".")
+		cmd.Dir = filepath.Join("..", "client")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			buildClientErr = fmt.Errorf("%v\n%s", err, out)
+		}
+	})
+	if buildClientErr != nil {
+		t.Fatalf("couldn't build the client: %v", buildClientErr)
+	}
+}
+
+// runs a headless client sending the events to the server at addr
+func runClient(t *testing.T, addr string, events ...sinkEvent) {
+	t.Helper()
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runClientWithArgs(t, []string{host, port}, events...)
+}
+
+// runs the client headless with args telling where the server is
+func runClientWithArgs(t *testing.T, args []string, events ...sinkEvent) {
+	t.Helper()
+	buildClient(t)
+	lines := make([]string, 0, len(events))
+	for _, e := range events {
+		lines = append(lines, e.String())
+	}
+	cmd := exec.Command(clientBin, append([]string{"-headless"}, args...)...)
+	cmd.Stdin = strings.NewReader(strings.Join(lines, "\n") + "\n")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("client failed: %v\n%s", err, out)
+	}
+}
+
+func dialServer(t *testing.T, addr string) *net.TCPConn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn.(*net.TCPConn)
+}
+
+func TestE2EKeySequence(t *testing.T) {
+	addr, sink := startServer(t)
+	events := []sinkEvent{down(42), down(30), up(30), up(42), down(29), down(46), up(46), up(29)}
+	runClient(t, addr, events...)
+	sink.expect(t, events...)
+}
+
+func TestE2ERecordReplay(t *testing.T) {
+	addr, sink := startServer(t)
+	host, port, _ := net.SplitHostPort(addr)
+	session := filepath.Join(t.TempDir(), "session")
+	events := []sinkEvent{down(42), down(20), up(20), up(42), down(28), up(28)}
+	runClientWithArgs(t, []string{"-record", session, host, port}, events...)
+	sink.expect(t, events...)
+
+	addr, sink = startServer(t)
+	host, port, _ = net.SplitHostPort(addr)
+	runClientWithArgs(t, []string{"-replay", session, "-speed", "0", host, port})
+	sink.expect(t, events...)
+}
+
+func TestE2EFragmentation(t *testing.T) {
+	addr, sink := startServer(t)
+	conn := dialServer(t, addr)
+	conn.SetNoDelay(true)
+	events := []sinkEvent{down(30), up(30), down(31), up(31), down(32), up(32)}
+	stream := hello()
+	for _, e := range events {
+		stream = append(stream, keyMsg(e)...)
+	}
+	// byte by byte, then in chunks cutting the hello and frames in the middle
+	for _, chunk := range [][]byte{stream[:1], stream[1:2], stream[2:3], stream[3:7], stream[7:12], stream[12:13], stream[13:20], stream[20:]} {
+		if _, err := conn.Write(chunk); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	sink.expect(t, events...)
+}
+
+// A client run after another one is done gets its keys through the same way.
+// The client doesn't reconnect by itself when the server drops it, so that
+// isn't tested.
+func TestE2ESuccessiveClients(t *testing.T) {
+	addr, sink := startServer(t)
+	first := []sinkEvent{down(30), up(30)}
+	second := []sinkEvent{down(48), up(48)}
+	runClient(t, addr, first...)
+	sink.expect(t, first...)
+	runClient(t, addr, second...)
+	sink.expect(t, append(first, second...)...)
+}
+
+func TestE2EDisconnect(t *testing.T) {
+	addr, sink := startServer(t)
+	conn := dialServer(t, addr)
+	// a complete frame followed by half of the next one
+	if _, err := conn.Write(append(append(hello(), keyMsg(down(30))...), keyMsg(up(30))[:4]...)); err != nil {
+		t.Fatal(err)
+	}
+	sink.expect(t, down(30))
+	conn.Close()
+	// the half frame is dropped and the server keeps serving other clients
+	conn = dialServer(t, addr)
+	if _, err := conn.Write(append(hello(), keyMsg(up(30))...)); err != nil {
+		t.Fatal(err)
+	}
+	sink.expect(t, down(30), up(30))
+}
+
+func TestE2EPolicy(t *testing.T) {
+	addr, sink := startServer(t)
+	// sysrq and ctrl+alt+delete are blocked by default, their releases are dropped too
+	runClient(t, addr, down(99), up(99), down(29), down(56), down(111), up(111), up(56), up(29), down(111), up(111))
+	sink.expect(t, down(29), down(56), up(56), up(29), down(111), up(111))
+
+	addr, sink = startServerWithConfig(t, config{Policies: map[string]policyConfig{
+		"127.0.0.1": {Allow: []string{"a", "b"}},
+		"*":         {},
+	}})
+	runClient(t, addr, down(30), up(30), down(31), up(31), down(48), up(48))
+	sink.expect(t, down(30), up(30), down(48), up(48))
+}
+
+func TestE2EPowerKeys(t *testing.T) {
+	// volume keys go through, power keys only if the policy allows them
+	addr, sink := startServer(t)
+	runClient(t, addr, down(115), up(115), down(116), up(116), down(142), up(142))
+	sink.expect(t, down(115), up(115))
+
+	addr, sink = startServerWithConfig(t, config{Policies: map[string]policyConfig{"*": {AllowPower: true}}})
+	runClient(t, addr, down(115), up(115), down(116), up(116), down(142), up(142))
+	sink.expect(t, down(115), up(115), down(116), up(116), down(142), up(142))
+}
+
+func TestE2EKeysAbove255(t *testing.T) {
+	addr, sink := startServer(t)
+	// KEY_PLAYPAUSE, KEY_FN, KEY_BRIGHTNESS_MENU, KEY_MACRO1
+	events := []sinkEvent{down(164), up(164), down(0x1d0), up(0x1d0), down(0x289), up(0x289), down(0x290), up(0x290)}
+	runClient(t, addr, events...)
+	sink.expect(t, events...)
+}
+
+func TestE2EHandshake(t *testing.T) {
+	addr, sink := startServer(t)
+	// a client speaking another protocol is disconnected before anything gets injected
+	for _, greeting := range [][]byte{{30, 1}, []byte("VKBD\x02"), []byte("HTTP/"), []byte("VKBD\x03\x01\n")} {
+		conn := dialServer(t, addr)
+		if _, err := conn.Write(append(greeting, keyMsg(down(30))...)); err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Read(make([]byte, 1)); err == nil {
+			t.Errorf("server sent something after %q", greeting)
+		} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			t.Errorf("server didn't close the connection after %q", greeting)
+		}
+	}
+	runClient(t, addr, down(30), up(30))
+	sink.expect(t, down(30), up(30))
+}
+
+// connects to the server like a client does, returning whether it has control
+func connectClient(t *testing.T, addr string) (*net.TCPConn, bool) {
+	t.Helper()
+	conn := dialServer(t, addr)
+	if _, err := conn.Write(hello()); err != nil {
+		t.Fatal(err)
+	}
+	return conn, readControl(t, conn)
+}
+
+// reads a control notification sent by the server
+func readControl(t *testing.T, conn *net.TCPConn) bool {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	frame := make([]byte, frameHeaderSize+1)
+	if _, err := io.ReadFull(conn, frame); err != nil {
+		t.Fatalf("reading a control notification: %v", err)
+	}
+	if frame[0] != frameControl || frame[1] != 1 || frame[2] != 0 {
+		t.Fatalf("expected a control notification, got %v", frame)
+	}
+	return frame[frameHeaderSize] == 1
+}
+
+func sendKeys(t *testing.T, conn *net.TCPConn, events ...sinkEvent) {
+	t.Helper()
+	for _, e := range events {
+		if _, err := conn.Write(keyMsg(e)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestE2EArbitrationExclusive(t *testing.T) {
+	addr, sink := startServerWithConfig(t, config{Arbitration: "exclusive"})
+	first, control := connectClient(t, addr)
+	if !control {
+		t.Fatal("the first client doesn't have control")
+	}
+	second, control := connectClient(t, addr)
+	if control {
+		t.Fatal("the second client has control")
+	}
+	sendKeys(t, second, down(30), up(30))
+	sendKeys(t, first, down(31))
+	sink.expect(t, down(31))
+	// keys held by a client are released when it disconnects, then the next one gets control
+	first.Close()
+	sink.expect(t, down(31), up(31))
+	if !readControl(t, second) {
+		t.Fatal("the second client didn't get control")
+	}
+	sendKeys(t, second, down(32), up(32))
+	sink.expect(t, down(31), up(31), down(32), up(32))
+}
+
+func TestE2EArbitrationTakeover(t *testing.T) {
+	addr, sink := startServerWithConfig(t, config{Arbitration: "takeover"})
+	first, _ := connectClient(t, addr)
+	second, _ := connectClient(t, addr)
+	sendKeys(t, first, down(42))
+	if !readControl(t, first) {
+		t.Fatal("the first client didn't take control")
+	}
+	sink.expect(t, down(42))
+	// the second client takes over, the shift held by the first one is released
+	sendKeys(t, second, down(30), up(30))
+	if readControl(t, first) {
+		t.Fatal("the first client didn't lose control")
+	}
+	if !readControl(t, second) {
+		t.Fatal("the second client didn't take control")
+	}
+	sendKeys(t, first, up(42))
+	sink.expect(t, down(42), up(42), down(30), up(30))
+}
+
+func TestE2EArbitrationMerge(t *testing.T) {
+	addr, sink := startServerWithConfig(t, config{Arbitration: "merge"})
+	first, control := connectClient(t, addr)
+	if !control {
+		t.Fatal("the first client doesn't have control")
+	}
+	second, control := connectClient(t, addr)
+	if !control {
+		t.Fatal("the second client doesn't have control")
+	}
+	sendKeys(t, first, down(42))
+	sink.expect(t, down(42))
+	sendKeys(t, second, down(30), up(30))
+	sink.expect(t, down(42), down(30), up(30))
+	// shift is held by both clients, it's released once neither of them holds it
+	sendKeys(t, second, down(42), down(29))
+	sink.expect(t, down(42), down(30), up(30), down(42), down(29))
+	first.Close()
+	sendKeys(t, second, down(31))
+	sink.expect(t, down(42), down(30), up(30), down(42), down(29), down(31))
+	sendKeys(t, second, up(42))
+	sink.expect(t, down(42), down(30), up(30), down(42), down(29), down(31), up(42))
+	second.Close()
+	sink.expect(t, down(42), down(30), up(30), down(42), down(29), down(31), up(42), up(29), up(31))
+}
+
+// A recording sink failing to press a key the first time
+type flakySink struct {
+	*recordingSink
+	mu      sync.Mutex
+	failing int
+}
+
+func (s *flakySink) KeyDown(key int) error {
+	s.mu.Lock()
+	failing := key == s.failing
+	if failing {
+		s.failing = 0
+	}
+	s.mu.Unlock()
+	if failing {
+		return errors.New("no such device")
+	}
+	return s.recordingSink.KeyDown(key)
+}
+
+func TestE2EMergeFailedPress(t *testing.T) {
+	policies, err := newPolicySet(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := newRecordingSink()
+	srv := &server{arbiter: newArbiter(arbitrationMerge), devices: sharedDevices(&flakySink{recordingSink: sink, failing: 31}), policies: policies}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go srv.serve(context.Background(), ln)
+
+	first, _ := connectClient(t, ln.Addr().String())
+	second, _ := connectClient(t, ln.Addr().String())
+	sendKeys(t, first, down(31), down(30))
+	sink.expect(t, down(30))
+	// the first client's press didn't go through, so it doesn't hold the key
+	sendKeys(t, second, down(31), up(31))
+	sink.expect(t, down(30), down(31), up(31))
+}
+
+// A deviceProvider giving each client a recording sink of its own
+type recordingDevices struct {
+	mu       sync.Mutex
+	sinks    map[string]*recordingSink
+	released map[string]bool
+}
+
+func (d *recordingDevices) provide(clientName string) (inputSink, func(), error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	sink := newRecordingSink()
+	d.sinks[clientName] = sink
+	return sink, func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.released[clientName] = true
+	}, nil
+}
+
+func (d *recordingDevices) sink(t *testing.T, clientName string) *recordingSink {
+	t.Helper()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	sink, ok := d.sinks[clientName]
+	if !ok {
+		t.Fatalf("no devices for %s", clientName)
+	}
+	return sink
+}
+
+func TestE2EPerClientDevices(t *testing.T) {
+	policies, err := newPolicySet(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices := &recordingDevices{sinks: make(map[string]*recordingSink), released: make(map[string]bool)}
+	srv := &server{arbiter: newArbiter(arbitrationMerge), devices: devices.provide, policies: policies}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go srv.serve(context.Background(), ln)
+
+	alice := dialServer(t, ln.Addr().String())
+	alice.Write(helloWithName("alice-laptop"))
+	readControl(t, alice)
+	bob := dialServer(t, ln.Addr().String())
+	bob.Write(helloWithName("bob-desktop"))
+	readControl(t, bob)
+	sendKeys(t, alice, down(42), down(30), up(30))
+	sendKeys(t, bob, down(48), up(48))
+	devices.sink(t, "alice-laptop").expect(t, down(42), down(30), up(30))
+	devices.sink(t, "bob-desktop").expect(t, down(48), up(48))
+
+	// the keys held by alice are released on her devices before they're destroyed
+	alice.Close()
+	devices.sink(t, "alice-laptop").expect(t, down(42), down(30), up(30), up(42))
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		devices.mu.Lock()
+		released := devices.released["alice-laptop"] && !devices.released["bob-desktop"]
+		devices.mu.Unlock()
+		if released {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("devices of alice-laptop weren't released")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// a client without a name gets devices named after its host
+	anonymous := dialServer(t, ln.Addr().String())
+	anonymous.Write(hello())
+	readControl(t, anonymous)
+	sendKeys(t, anonymous, down(30), up(30))
+	devices.sink(t, "127.0.0.1").expect(t, down(30), up(30))
+}
+
+// reads a LED notification sent by the server
+func readLeds(t *testing.T, conn *net.TCPConn) ledState {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	frame := make([]byte, frameHeaderSize+1)
+	if _, err := io.ReadFull(conn, frame); err != nil {
+		t.Fatalf("reading a LED notification: %v", err)
+	}
+	if frame[0] != frameLeds || frame[1] != 1 || frame[2] != 0 {
+		t.Fatalf("expected a LED notification, got %v", frame)
+	}
+	return ledState(frame[frameHeaderSize])
+}
+
+func TestE2ELeds(t *testing.T) {
+	const numLock, capsLock ledState = 1 << 0, 1 << 1
+	addr, sink := startServerWithConfig(t, config{Arbitration: "merge"})
+	// no LED is lit, so the client isn't told about them
+	first, _ := connectClient(t, addr)
+	sink.set(capsLock)
+	if leds := readLeds(t, first); leds != capsLock {
+		t.Fatalf("first client got LEDs %08b, expected %08b", leds, capsLock)
+	}
+	// a client connecting later gets the LEDs lit so far
+	second, _ := connectClient(t, addr)
+	if leds := readLeds(t, second); leds != capsLock {
+		t.Fatalf("second client got LEDs %08b, expected %08b", leds, capsLock)
+	}
+	sink.set(capsLock | numLock)
+	for _, conn := range []*net.TCPConn{first, second} {
+		if leds := readLeds(t, conn); leds != capsLock|numLock {
+			t.Fatalf("got LEDs %08b, expected %08b", leds, capsLock|numLock)
+		}
+	}
+	sink.set(0)
+	if leds := readLeds(t, first); leds != 0 {
+		t.Fatalf("got LEDs %08b after they went off", leds)
+	}
+}
+
+// reads a frame of the given type sent by the server and returns its payload
+func readServerFrame(t *testing.T, conn *net.TCPConn, frameType byte) []byte {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	header := make([]byte, frameHeaderSize)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		t.Fatalf("reading a frame of type %d: %v", frameType, err)
+	}
+	payload := make([]byte, int(header[1])|int(header[2])<<8)
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		t.Fatalf("reading a frame of type %d: %v", frameType, err)
+	}
+	if header[0] != frameType {
+		t.Fatalf("expected a frame of type %d, got %d with %q", frameType, header[0], payload)
+	}
+	return payload
+}
+
+func TestE2EErrors(t *testing.T) {
+	addr, sink := startServer(t)
+	conn, _ := connectClient(t, addr)
+	// sysrq is rejected by the default policy
+	sendKeys(t, conn, down(99), up(99))
+	if msg := string(readServerFrame(t, conn, frameError)); !strings.Contains(msg, "denylist") {
+		t.Errorf("error %q doesn't tell why the key was rejected", msg)
+	} else if strings.Contains(msg, "SYSRQ") || strings.Contains(msg, "99") {
+		t.Errorf("error %q names the rejected key", msg)
+	}
+	conn.Write([]byte{frameKey, keyMsgSize, 0, 30, 0, 2})
+	if msg := string(readServerFrame(t, conn, frameError)); !strings.Contains(msg, "invalid key state") {
+		t.Errorf("error %q doesn't tell what's wrong with the frame", msg)
+	}
+	// BTN_MISC, a code the devices don't have
+	sendKeys(t, conn, down(0x100))
+	if msg := string(readServerFrame(t, conn, frameError)); !strings.Contains(msg, "no key has code 256") {
+		t.Errorf("error %q doesn't tell the code isn't a key", msg)
+	}
+	sendKeys(t, conn, down(30))
+	readServerFrame(t, conn, frameControl)
+	sink.expect(t, down(30))
+}
+
+func TestE2EControlKeyFrames(t *testing.T) {
+	// keys are injected whichever of the two key frames they come in
+	addr, sink := startServer(t)
+	conn, _ := connectClient(t, addr)
+	conn.Write([]byte{frameControlKey, keyMsgSize, 0, 115, 0, 1, frameControlKey, keyMsgSize, 0, 115, 0, 0})
+	conn.Write([]byte{frameControlKey, keyMsgSize, 0, 30, 0, 1, frameControlKey, keyMsgSize, 0, 30, 0, 0})
+	sendKeys(t, conn, down(115), up(115))
+	sink.expect(t, down(115), up(115), down(30), up(30), down(115), up(115))
+}
+
+func TestE2EOrderlyShutdown(t *testing.T) {
+	addr, sink := startServerWithConfig(t, config{Arbitration: "exclusive"})
+	first, _ := connectClient(t, addr)
+	second, _ := connectClient(t, addr)
+	sendKeys(t, first, down(30))
+	sink.expect(t, down(30))
+	// the first client is done sending, the server releases its keys, hands
+	// control over and closes the connection
+	if err := first.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	first.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if n, err := first.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("read %d bytes, %v, expected the server to close the connection", n, err)
+	}
+	sink.expect(t, down(30), up(30))
+	if !readControl(t, second) {
+		t.Fatal("the second client didn't get control")
+	}
+}
+
+func TestE2EShutdown(t *testing.T) {
+	policies, err := newPolicySet(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := newRecordingSink()
+	srv := &server{arbiter: newArbiter(arbitrationMerge), devices: sharedDevices(sink), policies: policies}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		srv.serve(ctx, ln)
+		close(stopped)
+	}()
+
+	first, _ := connectClient(t, ln.Addr().String())
+	second, _ := connectClient(t, ln.Addr().String())
+	// a client that hasn't introduced itself yet
+	silent := dialServer(t, ln.Addr().String())
+	sendKeys(t, first, down(42), down(30))
+	sink.expect(t, down(42), down(30))
+	sendKeys(t, second, down(31))
+	sink.expect(t, down(42), down(30), down(31))
+
+	cancel()
+	for _, conn := range []*net.TCPConn{first, second} {
+		if msg := string(readServerFrame(t, conn, frameError)); !strings.Contains(msg, "shutting down") {
+			t.Errorf("got error %q, expected the server to tell it's shutting down", msg)
+		}
+	}
+	for _, conn := range []*net.TCPConn{first, second, silent} {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if n, err := conn.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatalf("read %d bytes, %v, expected the server to close the connection", n, err)
+		}
+	}
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the server didn't stop")
+	}
+	// all the keys are released before serve returns
+	got := sink.recorded()
+	if len(got) != 6 || !reflect.DeepEqual(got[:3], []sinkEvent{down(42), down(30), down(31)}) {
+		t.Fatalf("sink got %v, expected the keys pressed and then released", got)
+	}
+	for _, e := range []sinkEvent{up(30), up(31), up(42)} {
+		if !slices.Contains(got[3:], e) {
+			t.Errorf("%v missing, sink got %v", e, got)
+		}
+	}
+	if _, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+		t.Error("the server still accepts connections")
+	}
+}
+
+// A listener failing the first accepts
+type flakyListener struct {
+	net.Listener
+	failures int
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if l.failures > 0 {
+		l.failures--
+		return nil, syscall.EMFILE
+	}
+	return l.Listener.Accept()
+}
+
+func TestServeRetriesAccept(t *testing.T) {
+	policies, err := newPolicySet(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	sink := newRecordingSink()
+	srv := &server{arbiter: newArbiter(arbitrationMerge), devices: sharedDevices(sink), policies: policies}
+	go srv.serve(context.Background(), &flakyListener{Listener: ln, failures: 3})
+
+	conn, _ := connectClient(t, ln.Addr().String())
+	sendKeys(t, conn, down(30), up(30))
+	sink.expect(t, down(30), up(30))
+}
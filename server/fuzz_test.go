@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"maps"
+	"net"
+	"slices"
+	"testing"
+
+	"virt-kbd/dnsmsg"
+)
+
+// keys of a recorded session, as the client sent them
+var sessionKeys = []sinkEvent{up(28), down(42), down(35), up(35), up(42), down(18), up(18), down(38), up(38), down(115), up(115)}
+
+func FuzzDecodeKeyMsg(f *testing.F) {
+	for _, e := range sessionKeys {
+		frame := keyMsg(e)
+		f.Add(frame[frameHeaderSize:])
+	}
+	f.Add([]byte{30, 0, 2})
+	f.Add([]byte{0xff, 0xff, 1})
+	f.Add([]byte{30, 0})
+	f.Add([]byte{115, 0, 1})
+	f.Fuzz(func(t *testing.T, msg []byte) {
+		scancode, pressed, err := decodeKeyMsg(msg)
+		if err != nil {
+			return
+		}
+		if len(msg) != keyMsgSize || scancode != int(msg[0])|int(msg[1])<<8 || keyNames[scancode] == "" || pressed != (msg[2] == 1) {
+			t.Errorf("decoded %d, %v from %v", scancode, pressed, msg)
+		}
+	})
+}
+
+// Sends a stream of bytes to a connection handler and checks that every complete,
+// valid key frame and nothing else gets to the sink
+func FuzzHandleConnection(f *testing.F) {
+	stream := hello()
+	for _, e := range sessionKeys {
+		stream = append(stream, keyMsg(e)...)
+	}
+	f.Add(stream)
+	f.Add(stream[:len(stream)-1])
+	f.Add(append(append(hello(), 7, 2, 0, 30, 0), stream[helloSize:]...)) // a frame of an unknown type
+	f.Add(append([]byte{30, 1}, stream...))
+	f.Add(append(helloWithName("alice-laptop"), stream[helloSize:]...))
+	f.Fuzz(func(t *testing.T, stream []byte) {
+		sink := newRecordingSink()
+		policies, err := newPolicySet(map[string]policyConfig{"*": {AllowPower: true}}) // allows everything
+		if err != nil {
+			t.Fatal(err)
+		}
+		client, serverEnd := net.Pipe()
+		handled := make(chan struct{})
+		go func() {
+			srv := &server{arbiter: newArbiter(arbitrationMerge), devices: sharedDevices(sink), policies: policies}
+			srv.handleConnection(serverEnd)
+			close(handled)
+		}()
+		go io.Copy(io.Discard, client) // control notifications
+		client.Write(stream)
+		client.Close()
+		<-handled
+		// keys are released only if they're held and all of them are released
+		// when the connection is closed
+		expected := make([]sinkEvent, 0)
+		held := make(map[int]bool)
+		r := bytes.NewReader(stream)
+		if _, err := readHello(r); err == nil {
+			for rest := stream[len(stream)-r.Len():]; len(rest) >= frameHeaderSize; {
+				size := frameHeaderSize + int(binary.LittleEndian.Uint16(rest[1:]))
+				if len(rest) < size {
+					break
+				}
+				if rest[0] == frameKey || rest[0] == frameControlKey {
+					if scancode, pressed, err := decodeKeyMsg(rest[frameHeaderSize:size]); err == nil && (pressed || held[scancode]) {
+						expected = append(expected, sinkEvent{key: scancode, down: pressed})
+						held[scancode] = pressed
+					}
+				}
+				rest = rest[size:]
+			}
+		}
+		for _, key := range slices.Sorted(maps.Keys(held)) {
+			if held[key] {
+				expected = append(expected, up(key))
+			}
+		}
+		got := sink.recorded()
+		if len(got) != len(expected) {
+			t.Fatalf("sink got %v, expected %v", got, expected)
+		}
+		for i := range got {
+			if got[i] != expected[i] {
+				t.Fatalf("sink got %v, expected %v", got, expected)
+			}
+		}
+	})
+}
+
+func FuzzParseDNSMessage(f *testing.F) {
+	ad := &advertisement{instance: "kbd-target", port: 3001, txt: []string{"v=3", "fingerprint=0123456789abcdef"}}
+	answers, additional := ad.answer(dnsmsg.Question{Name: mdnsService, Type: dnsmsg.TypePTR})
+	resp, err := (&dnsmsg.Message{Response: true, Answers: answers, Additional: additional}).Encode()
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(resp)
+	query, _ := (&dnsmsg.Message{Questions: []dnsmsg.Question{{Name: mdnsService, Type: dnsmsg.TypePTR, Unicast: true}}}).Encode()
+	f.Add(query)
+	// a name pointing at itself
+	f.Add([]byte{0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0xc0, 12, 0, 12, 0, 1})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m, err := dnsmsg.Parse(data)
+		if err != nil {
+			return
+		}
+		// what's parsed can be told to the ad, which mustn't choke on it
+		for _, q := range m.Questions {
+			ad.answer(q)
+		}
+	})
+}
@@ -2,4 +2,11 @@ module server
 
 go 1.23.7
 
-require github.com/bendahl/uinput v1.7.0 // indirect
+require (
+	virt-kbd/dgram v0.0.0
+	virt-kbd/dnsmsg v0.0.0
+)
+
+replace virt-kbd/dgram => ../dgram
+
+replace virt-kbd/dnsmsg => ../dnsmsg
@@ -0,0 +1,79 @@
+package main
+
+// What kind of device a key belongs to
+type keyClass int
+
+const (
+	classKeyboard keyClass = iota // plain keyboard keys
+	classConsumer                 // media, volume, brightness and application launch keys
+	classSystem                   // power, sleep and wake up keys
+)
+
+var consumerKeys = map[int]bool{
+	113: true, // KEY_MUTE
+	114: true, // KEY_VOLUMEDOWN
+	115: true, // KEY_VOLUMEUP
+	248: true, // KEY_MICMUTE
+	161: true, // KEY_EJECTCD
+	162: true, // KEY_EJECTCLOSECD
+	163: true, // KEY_NEXTSONG
+	164: true, // KEY_PLAYPAUSE
+	165: true, // KEY_PREVIOUSSONG
+	166: true, // KEY_STOPCD
+	167: true, // KEY_RECORD
+	168: true, // KEY_REWIND
+	200: true, // KEY_PLAYCD
+	201: true, // KEY_PAUSECD
+	207: true, // KEY_PLAY
+	208: true, // KEY_FASTFORWARD
+	226: true, // KEY_MEDIA
+	439: true, // KEY_MEDIA_REPEAT
+	224: true, // KEY_BRIGHTNESSDOWN
+	225: true, // KEY_BRIGHTNESSUP
+	227: true, // KEY_SWITCHVIDEOMODE
+	243: true, // KEY_BRIGHTNESS_CYCLE
+	244: true, // KEY_BRIGHTNESS_AUTO
+	592: true, // KEY_BRIGHTNESS_MIN
+	593: true, // KEY_BRIGHTNESS_MAX
+	228: true, // KEY_KBDILLUMTOGGLE
+	229: true, // KEY_KBDILLUMDOWN
+	230: true, // KEY_KBDILLUMUP
+	140: true, // KEY_CALC
+	150: true, // KEY_WWW
+	155: true, // KEY_MAIL
+	156: true, // KEY_BOOKMARKS
+	157: true, // KEY_COMPUTER
+	158: true, // KEY_BACK
+	159: true, // KEY_FORWARD
+	172: true, // KEY_HOMEPAGE
+	173: true, // KEY_REFRESH
+	217: true, // KEY_SEARCH
+}
+
+var systemKeys = map[int]bool{
+	116: true, // KEY_POWER
+	142: true, // KEY_SLEEP
+	143: true, // KEY_WAKEUP
+	205: true, // KEY_SUSPEND
+	356: true, // KEY_POWER2
+}
+
+func classifyKey(code int) keyClass {
+	switch {
+	case systemKeys[code]:
+		return classSystem
+	case consumerKeys[code]:
+		return classConsumer
+	}
+	return classKeyboard
+}
+
+func (c keyClass) String() string {
+	switch c {
+	case classConsumer:
+		return "consumer control"
+	case classSystem:
+		return "system control"
+	}
+	return "keyboard"
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"sync"
+)
+
+// LEDs of a keyboard, bit n set when the LED with code n from
+// linux/input-event-codes.h (LED_NUML, LED_CAPSL, ...) is lit
+type ledState byte
+
+// LEDs a virtual keyboard has: num lock, caps lock, scroll lock, compose and kana
+var keyboardLeds = []int{0, 1, 2, 3, 4}
+
+// A sink whose LEDs can be watched. fn is called with the state of the LEDs every
+// time it changes and right away if any of them is lit. It's called until cancel is.
+type ledSource interface {
+	subscribeLeds(fn func(leds ledState)) (cancel func())
+}
+
+// Passes the state of the LEDs of a device to everyone watching them
+type ledBroadcaster struct {
+	mu sync.Mutex
+	// held while the subscribers are called, so they see the changes in order
+	notifyMu    sync.Mutex
+	leds        ledState
+	subscribers map[int]func(ledState)
+	next        int
+}
+
+func (b *ledBroadcaster) subscribeLeds(fn func(leds ledState)) func() {
+	b.notifyMu.Lock()
+	defer b.notifyMu.Unlock()
+	b.mu.Lock()
+	if b.subscribers == nil {
+		b.subscribers = make(map[int]func(ledState))
+	}
+	id := b.next
+	b.next++
+	b.subscribers[id] = fn
+	leds := b.leds
+	b.mu.Unlock()
+	// the clients assume all the LEDs are off until they're told otherwise
+	if leds != 0 {
+		fn(leds)
+	}
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers, id)
+	}
+}
+
+func (b *ledBroadcaster) set(leds ledState) {
+	b.notifyMu.Lock()
+	defer b.notifyMu.Unlock()
+	b.mu.Lock()
+	if leds == b.leds {
+		b.mu.Unlock()
+		return
+	}
+	b.leds = leds
+	subscribers := make([]func(ledState), 0, len(b.subscribers))
+	for _, fn := range b.subscribers {
+		subscribers = append(subscribers, fn)
+	}
+	b.mu.Unlock()
+	for _, fn := range subscribers {
+		fn(leds)
+	}
+}
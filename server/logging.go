@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+)
+
+// How the server logs, eg.
+//
+//	"log": {"level": "debug", "format": "json"}
+type logConfig struct {
+	Level  string `json:"level"`  // debug, info (the default), warn or error
+	Format string `json:"format"` // text (the default) or json
+	// whether keys are logged, for debugging. Keys can make up passwords, so
+	// they're redacted unless it's true.
+	LogKeys bool `json:"log_keys"`
+}
+
+// whether key codes are logged rather than redacted
+var logKeys atomic.Bool
+
+// A key in the logs, redacted unless logKeys is set
+type loggedKey int
+
+func (k loggedKey) LogValue() slog.Value {
+	if !logKeys.Load() {
+		return slog.StringValue("redacted")
+	}
+	return slog.StringValue(keyName(int(k)))
+}
+
+// the attribute a key is logged with
+func keyAttr(key int) slog.Attr {
+	return slog.Any("key", loggedKey(key))
+}
+
+func errAttr(err error) slog.Attr {
+	return slog.String("error", err.Error())
+}
+
+// Returns a handler writing to w as cfg says
+func newLogHandler(w io.Writer, cfg logConfig) (slog.Handler, error) {
+	var level slog.Level
+	if cfg.Level != "" {
+		if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+			return nil, fmt.Errorf("invalid log level %q, expected debug, info, warn or error", cfg.Level)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	switch strings.ToLower(cfg.Format) {
+	case "", "text":
+		return slog.NewTextHandler(w, opts), nil
+	case "json":
+		return slog.NewJSONHandler(w, opts), nil
+	}
+	return nil, fmt.Errorf("invalid log format %q, expected text or json", cfg.Format)
+}
+
+// Makes the default logger log to w as cfg says
+func setupLogging(w io.Writer, cfg logConfig) error {
+	handler, err := newLogHandler(w, cfg)
+	if err != nil {
+		return err
+	}
+	logKeys.Store(cfg.LogKeys)
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
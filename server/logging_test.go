@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// A writer the tests can read the logs from while the server writes them
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// returns the json records logged so far
+func (b *syncBuffer) records(t *testing.T) []map[string]any {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	records := make([]map[string]any, 0)
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		record := make(map[string]any)
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid json log line %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// logs to a buffer as cfg says for the rest of the test
+func captureLogs(t *testing.T, cfg logConfig) *syncBuffer {
+	t.Helper()
+	previous := slog.Default()
+	buf := &syncBuffer{}
+	if err := setupLogging(buf, cfg); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		slog.SetDefault(previous)
+		logKeys.Store(false)
+	})
+	return buf
+}
+
+// returns the first record with the message
+func findLog(t *testing.T, records []map[string]any, msg string) map[string]any {
+	t.Helper()
+	for _, record := range records {
+		if record["msg"] == msg {
+			return record
+		}
+	}
+	t.Fatalf("nothing logged %q", msg)
+	return nil
+}
+
+func TestLogging(t *testing.T) {
+	for _, logKeys := range []bool{false, true} {
+		buf := captureLogs(t, logConfig{Level: "debug", Format: "json", LogKeys: logKeys})
+		addr, sink := startServerWithConfig(t, config{Policies: map[string]policyConfig{"*": {Deny: []string{"b"}}}})
+		conn := dialServer(t, addr)
+		conn.Write(helloWithName("alice-laptop"))
+		readControl(t, conn)
+		sendKeys(t, conn, down(48), down(30), up(30))
+		sink.expect(t, down(30), up(30))
+
+		rejected := findLog(t, buf.records(t), "rejected a key")
+		expectedKey := "redacted"
+		if logKeys {
+			expectedKey = "KEY_B"
+		}
+		if rejected["key"] != expectedKey || rejected["level"] != "WARN" {
+			t.Errorf("unexpected record %v, expected the key to be %s", rejected, expectedKey)
+		}
+		// the records of a connection tell which one it is
+		for _, attr := range []string{"conn", "remote", "transport", "client", "identity"} {
+			if _, ok := rejected[attr]; !ok {
+				t.Errorf("record %v doesn't have %s", rejected, attr)
+			}
+		}
+		if rejected["client"] != "alice-laptop" || rejected["identity"] != "127.0.0.1" {
+			t.Errorf("unexpected client in %v", rejected)
+		}
+		accepted := findLog(t, buf.records(t), "accepted a connection")
+		if accepted["conn"] != rejected["conn"] {
+			t.Errorf("the records of a connection have different ids: %v, %v", accepted, rejected)
+		}
+	}
+}
+
+func TestLogConfig(t *testing.T) {
+	for _, cfg := range []logConfig{{Level: "verbose"}, {Format: "xml"}} {
+		if _, err := newLogHandler(&bytes.Buffer{}, cfg); err == nil {
+			t.Errorf("%+v is accepted", cfg)
+		}
+	}
+	var buf bytes.Buffer
+	handler, err := newLogHandler(&buf, logConfig{Level: "warn"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := slog.New(handler)
+	log.Info("not logged")
+	log.Warn("logged", keyAttr(30))
+	if out := buf.String(); strings.Contains(out, "not logged") || !strings.Contains(out, "msg=logged key=redacted") {
+		t.Errorf("unexpected logs %q", out)
+	}
+}
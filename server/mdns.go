@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"virt-kbd/dnsmsg"
+)
+
+// Advertising the server on the local network with multicast DNS, so that clients
+// can find it without being given its address
+
+// the multicast DNS group, RFC 6762
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+const (
+	mdnsService = "_virt-kbd._tcp.local."
+	// the name DNS-SD browsers ask for to list the services, RFC 6763 9
+	mdnsServices   = "_services._dns-sd._udp.local."
+	mdnsHostTTL    = 120
+	mdnsServiceTTL = 4500
+	// the most a record sent to a resolver that isn't a mDNS one is cached, RFC 6762 6.7
+	mdnsLegacyTTL = 10
+)
+
+// What the server advertises: an instance of the service, named after the host
+type advertisement struct {
+	instance string
+	port     int
+	txt      []string
+	ifi      *net.Interface // the interface whose addresses are advertised, nil for all
+}
+
+func (a *advertisement) instanceName() string { return a.instance + "." + mdnsService }
+func (a *advertisement) hostName() string     { return a.instance + ".local." }
+
+// The records of the advertisement. Addresses are looked up every time, they may change.
+func (a *advertisement) records() []dnsmsg.Record {
+	records := []dnsmsg.Record{
+		{Name: mdnsServices, Type: dnsmsg.TypePTR, TTL: mdnsServiceTTL, Target: mdnsService},
+		{Name: mdnsService, Type: dnsmsg.TypePTR, TTL: mdnsServiceTTL, Target: a.instanceName()},
+		{Name: a.instanceName(), Type: dnsmsg.TypeSRV, Flush: true, TTL: mdnsHostTTL, Port: uint16(a.port), Target: a.hostName()},
+		{Name: a.instanceName(), Type: dnsmsg.TypeTXT, Flush: true, TTL: mdnsServiceTTL, TXT: a.txt},
+	}
+	for _, ip := range advertisedAddrs(a.ifi) {
+		records = append(records, dnsmsg.Record{Name: a.hostName(), Type: dnsmsg.TypeA, Flush: true, TTL: mdnsHostTTL, IP: ip})
+	}
+	return records
+}
+
+// IPv4 addresses of ifi, or of all the interfaces but loopback if it's nil
+func advertisedAddrs(ifi *net.Interface) []net.IP {
+	var addrs []net.Addr
+	var err error
+	if ifi != nil {
+		addrs, err = ifi.Addrs()
+	} else {
+		addrs, err = net.InterfaceAddrs()
+	}
+	if err != nil {
+		slog.Error("couldn't get the addresses to advertise", errAttr(err))
+		return nil
+	}
+	ips := make([]net.IP, 0)
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.To4() == nil || (ifi == nil && ipNet.IP.IsLoopback()) {
+			continue
+		}
+		ips = append(ips, ipNet.IP.To4())
+	}
+	return ips
+}
+
+// Returns the records answering q, and the additional ones a client is going to
+// need next: the SRV, TXT and A records of the instance it's told about
+func (a *advertisement) answer(q dnsmsg.Question) ([]dnsmsg.Record, []dnsmsg.Record) {
+	records := a.records()
+	answers := make([]dnsmsg.Record, 0)
+	for _, r := range records {
+		if strings.EqualFold(r.Name, q.Name) && (q.Type == r.Type || q.Type == dnsmsg.TypeANY) {
+			answers = append(answers, r)
+		}
+	}
+	additional := make([]dnsmsg.Record, 0)
+	for _, r := range records {
+		wanted := false
+		for _, answer := range answers {
+			switch {
+			case answer.Type == dnsmsg.TypePTR && answer.Target == a.instanceName():
+				wanted = r.Name == a.instanceName() || r.Name == a.hostName()
+			case answer.Type == dnsmsg.TypeSRV:
+				wanted = r.Name == a.hostName()
+			}
+			if wanted {
+				break
+			}
+		}
+		if wanted && !containsRecord(answers, r) {
+			additional = append(additional, r)
+		}
+	}
+	return answers, additional
+}
+
+func containsRecord(records []dnsmsg.Record, r dnsmsg.Record) bool {
+	for _, other := range records {
+		if other.Name == r.Name && other.Type == r.Type && other.IP.Equal(r.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// Answers queries about the service on a multicast DNS group
+type mdnsResponder struct {
+	ad    *advertisement
+	conn  *net.UDPConn
+	group *net.UDPAddr
+	done  chan struct{}
+}
+
+// Joins group on ifi (the system's default interface if it's nil), announces
+// the service and answers queries about it until closed
+func newMdnsResponder(ad *advertisement, ifi *net.Interface, group *net.UDPAddr) (*mdnsResponder, error) {
+	conn, err := net.ListenMulticastUDP("udp4", ifi, group)
+	if err != nil {
+		return nil, errors.New("couldn't join the multicast DNS group: " + err.Error())
+	}
+	if ifi != nil {
+		if err := setMulticastInterface(conn, ifi); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	r := &mdnsResponder{ad: ad, conn: conn, group: group, done: make(chan struct{})}
+	r.announce(false)
+	go r.serve()
+	return r, nil
+}
+
+// makes multicast datagrams leave through ifi
+func setMulticastInterface(conn *net.UDPConn, ifi *net.Interface) error {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var setErr error
+	if err := rc.Control(func(fd uintptr) {
+		setErr = syscall.SetsockoptIPMreqn(int(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_IF, &syscall.IPMreqn{Ifindex: int32(ifi.Index)})
+	}); err != nil {
+		return err
+	}
+	if setErr != nil {
+		return errors.New("couldn't send multicast through " + ifi.Name + ": " + setErr.Error())
+	}
+	return nil
+}
+
+func (r *mdnsResponder) serve() {
+	defer close(r.done)
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := r.conn.ReadFromUDP(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			continue
+		}
+		query, err := dnsmsg.Parse(buf[:n])
+		if err != nil || query.Response {
+			continue
+		}
+		r.respond(query, from)
+	}
+}
+
+// Responds to a query on the group, or straight to the one who asked if it
+// wants so. A query from a port other than the one of the group comes from a
+// resolver that isn't a mDNS one and gets a response as a unicast DNS server
+// would give it.
+func (r *mdnsResponder) respond(query *dnsmsg.Message, from *net.UDPAddr) {
+	legacy := from.Port != r.group.Port
+	resp := &dnsmsg.Message{Response: true}
+	unicast := legacy
+	for _, q := range query.Questions {
+		answers, additional := r.ad.answer(q)
+		resp.Answers = append(resp.Answers, answers...)
+		resp.Additional = append(resp.Additional, additional...)
+		unicast = unicast || (q.Unicast && len(answers) > 0)
+	}
+	if len(resp.Answers) == 0 {
+		return
+	}
+	if legacy {
+		resp.ID = query.ID
+		resp.Questions = query.Questions
+		for _, records := range [][]dnsmsg.Record{resp.Answers, resp.Additional} {
+			for i := range records {
+				records[i].Flush = false
+				records[i].TTL = min(records[i].TTL, mdnsLegacyTTL)
+			}
+		}
+	}
+	to := r.group
+	if unicast {
+		to = from
+	}
+	r.send(resp, to)
+}
+
+// Sends the records of the instance unasked, with a ttl of 0 telling that it's
+// going away if goodbye is true
+func (r *mdnsResponder) announce(goodbye bool) {
+	resp := &dnsmsg.Message{Response: true}
+	for _, record := range r.ad.records() {
+		if record.Name == mdnsServices {
+			continue
+		}
+		if goodbye {
+			record.TTL = 0
+		}
+		resp.Answers = append(resp.Answers, record)
+	}
+	r.send(resp, r.group)
+}
+
+func (r *mdnsResponder) send(m *dnsmsg.Message, to *net.UDPAddr) {
+	data, err := m.Encode()
+	if err != nil {
+		slog.Error("couldn't encode a multicast DNS response", errAttr(err))
+		return
+	}
+	if _, err := r.conn.WriteToUDP(data, to); err != nil {
+		slog.Debug("couldn't send a multicast DNS response", "to", to.String(), errAttr(err))
+	}
+}
+
+// Says goodbye and stops answering queries
+func (r *mdnsResponder) Close() error {
+	r.announce(true)
+	err := r.conn.Close()
+	<-r.done
+	return err
+}
+
+// Returns what tells the server apart from others even when its name or address
+// changes: a hash of the machine id, or of the hostname where there's none
+func serverFingerprint() string {
+	id, err := os.ReadFile("/etc/machine-id")
+	if id = bytes.TrimSpace(id); err != nil || len(id) == 0 {
+		hostname, _ := os.Hostname()
+		id = []byte(hostname)
+	}
+	sum := sha256.Sum256(append([]byte("virt-kbd:"), id...))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Advertises the server listening on listeners as configured in cfg
+func advertiseServer(cfg *config, listeners []net.Listener) (*mdnsResponder, error) {
+	ad := &advertisement{txt: []string{"v=" + strconv.Itoa(protocolVersion), "fingerprint=" + serverFingerprint()}}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, errors.New("couldn't get the hostname to advertise: " + err.Error())
+	}
+	ad.instance, _, _ = strings.Cut(hostname, ".")
+	for _, ln := range listeners {
+		switch addr := ln.Addr().(type) {
+		case *net.TCPAddr:
+			if ad.port == 0 {
+				ad.port = addr.Port
+			}
+		case *net.UDPAddr:
+			ad.txt = append(ad.txt, "udp=1")
+		}
+	}
+	if ad.port == 0 {
+		return nil, errors.New("there's no tcp port to advertise")
+	}
+	if cfg.MDNSInterface != "" {
+		ad.ifi, err = net.InterfaceByName(cfg.MDNSInterface)
+		if err != nil {
+			return nil, errors.New("couldn't find the interface to advertise on: " + err.Error())
+		}
+	}
+	slog.Info("advertising the server on the local network", "instance", ad.instanceName(), "fingerprint", serverFingerprint())
+	return newMdnsResponder(ad, ad.ifi, mdnsGroup)
+}
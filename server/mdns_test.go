@@ -0,0 +1,181 @@
+package main
+
+import (
+	"net"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"virt-kbd/dnsmsg"
+)
+
+// Returns a multicast group on a port nobody uses, so the tests don't meet a
+// real mDNS responder, together with the loopback interface
+func loopbackGroup(t *testing.T) (*net.UDPAddr, *net.Interface) {
+	t.Helper()
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skip("no loopback interface")
+	}
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	conn.Close()
+	return &net.UDPAddr{IP: mdnsGroup.IP, Port: port}, lo
+}
+
+func startResponder(t *testing.T, ad *advertisement, group *net.UDPAddr, lo *net.Interface) {
+	t.Helper()
+	r, err := newMdnsResponder(ad, lo, group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+}
+
+// sends a query from a port of its own and returns the response
+func queryGroup(t *testing.T, group *net.UDPAddr, lo *net.Interface, q dnsmsg.Question) *dnsmsg.Message {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := setMulticastInterface(conn, lo); err != nil {
+		t.Fatal(err)
+	}
+	data, err := (&dnsmsg.Message{ID: 4242, Questions: []dnsmsg.Question{q}}).Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.WriteToUDP(data, group); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 9000)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := dnsmsg.Parse(buf[:n])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func findRecord(records []dnsmsg.Record, rtype uint16) *dnsmsg.Record {
+	for i, r := range records {
+		if r.Type == rtype {
+			return &records[i]
+		}
+	}
+	return nil
+}
+
+func TestMdnsResponder(t *testing.T) {
+	group, lo := loopbackGroup(t)
+	ad := &advertisement{instance: "kbd-target", port: 3001, txt: []string{"v=3", "fingerprint=0123456789abcdef"}, ifi: lo}
+	startResponder(t, ad, group, lo)
+
+	resp := queryGroup(t, group, lo, dnsmsg.Question{Name: "_VIRT-KBD._tcp.local.", Type: dnsmsg.TypePTR})
+	if !resp.Response || resp.ID != 4242 || len(resp.Questions) != 1 {
+		t.Fatalf("unexpected response header: %+v", resp)
+	}
+	ptr := findRecord(resp.Answers, dnsmsg.TypePTR)
+	if ptr == nil || ptr.Target != "kbd-target._virt-kbd._tcp.local." {
+		t.Fatalf("no PTR record of the instance in %+v", resp.Answers)
+	}
+	// a resolver that isn't a mDNS one gets the records it needs next with short ttls
+	srv := findRecord(resp.Additional, dnsmsg.TypeSRV)
+	txt := findRecord(resp.Additional, dnsmsg.TypeTXT)
+	a := findRecord(resp.Additional, dnsmsg.TypeA)
+	if srv == nil || srv.Port != 3001 || srv.Target != "kbd-target.local." {
+		t.Errorf("unexpected SRV record %+v", srv)
+	}
+	if txt == nil || !slices.Contains(txt.TXT, "fingerprint=0123456789abcdef") {
+		t.Errorf("unexpected TXT record %+v", txt)
+	}
+	if a == nil || !a.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("unexpected A record %+v", a)
+	}
+	for _, r := range append(resp.Answers, resp.Additional...) {
+		if r.TTL > mdnsLegacyTTL || r.Flush {
+			t.Errorf("record %+v has a ttl above %d or the cache flush bit", r, mdnsLegacyTTL)
+		}
+	}
+
+	resp = queryGroup(t, group, lo, dnsmsg.Question{Name: "kbd-target.local.", Type: dnsmsg.TypeA})
+	if len(resp.Answers) != 1 || resp.Answers[0].Type != dnsmsg.TypeA {
+		t.Errorf("unexpected answers to a query for the address: %+v", resp.Answers)
+	}
+}
+
+func TestMdnsAnnouncement(t *testing.T) {
+	group, lo := loopbackGroup(t)
+	conn, err := net.ListenMulticastUDP("udp4", lo, group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r, err := newMdnsResponder(&advertisement{instance: "kbd-target", port: 3001, ifi: lo}, lo, group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readAnnouncement := func() *dnsmsg.Record {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, 9000)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := dnsmsg.Parse(buf[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		ptr := findRecord(m.Answers, dnsmsg.TypePTR)
+		if !m.Response || ptr == nil {
+			t.Fatalf("expected an announcement, got %+v", m)
+		}
+		return ptr
+	}
+	if ptr := readAnnouncement(); ptr.TTL != mdnsServiceTTL {
+		t.Errorf("the service is announced with a ttl of %d", ptr.TTL)
+	}
+	r.Close()
+	if ptr := readAnnouncement(); ptr.TTL != 0 {
+		t.Errorf("the goodbye has a ttl of %d", ptr.TTL)
+	}
+}
+
+func TestE2EDiscover(t *testing.T) {
+	if testing.Short() {
+		t.Skip("end to end test")
+	}
+	group, lo := loopbackGroup(t)
+	addr, sink := startServer(t)
+	_, port, _ := net.SplitHostPort(addr)
+	portNum, _ := strconv.Atoi(port)
+	startResponder(t, &advertisement{instance: "kbd-target", port: portNum, txt: []string{"fingerprint=0123456789abcdef"}, ifi: lo}, group, lo)
+	startResponder(t, &advertisement{instance: "other-target", port: 1, txt: []string{"fingerprint=fedcba9876543210"}, ifi: lo}, group, lo)
+
+	buildClient(t)
+	where := []string{"-mdns-interface", "lo", "-mdns-group", group.String()}
+	out, err := exec.Command(clientBin, append([]string{"discover"}, where...)...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("discover failed: %v\n%s", err, out)
+	}
+	for _, expected := range []string{"kbd-target", "127.0.0.1:" + port, "0123456789abcdef", "other-target"} {
+		if !strings.Contains(string(out), expected) {
+			t.Errorf("discover didn't print %q:\n%s", expected, out)
+		}
+	}
+	runClientWithArgs(t, append(where, "-target", "012345"), down(30), up(30))
+	sink.expect(t, down(30), up(30))
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The protocol spoken between a client and the server.
+//
+// A client starts with a hello: the magic bytes "VKBD", a version of the
+// protocol (a single byte) and a name of the client (its length in a single
+// byte followed by the name, an empty name is allowed). Then both sides send frames, each made of
+// a type (a single byte), a length of the payload (2 bytes, little endian) and
+// the payload. Frames of unknown types are skipped.
+const (
+	protocolMagic   = "VKBD"
+	protocolVersion = 3
+	helloSize       = len(protocolMagic) + 2 // without the name
+	frameHeaderSize = 3
+)
+
+// types of frames
+const (
+	// a key pressed or released: a key code (2 bytes, little endian) followed by
+	// 1 if the key was pressed or 0 if it was released
+	frameKey byte = 1
+	// the same as frameKey. Older clients sent consumer and system control keys,
+	// eg. volume up or power, in frames of this type; keys go to the device for
+	// their code whichever of the two frames they come in.
+	frameControlKey byte = 2
+	// sent by the server when a client gains or loses control over the target:
+	// 1 if the client's keys are injected, 0 if they aren't
+	frameControl byte = 3
+	// sent by the server when the LEDs of the keyboard the client's keys go to
+	// change: a single byte with bit n set if the LED with code n (LED_NUML,
+	// LED_CAPSL, ...) is lit. Until the first one comes all the LEDs are off.
+	frameLeds byte = 4
+	// sent by the server when it can't do what the client asked for, eg. a key
+	// was rejected by the client's policy. The payload is a message for the user.
+	frameError byte = 5
+)
+
+// size of the payload of a key frame
+const keyMsgSize = 3
+
+// highest key code, KEY_MAX from linux/input-event-codes.h
+const keyMax = 0x2ff
+
+// Reads a hello, checks the client speaks the same protocol and returns its name
+func readHello(r io.Reader) (string, error) {
+	hello := make([]byte, helloSize)
+	if _, err := io.ReadFull(r, hello); err != nil {
+		return "", err
+	}
+	if string(hello[:len(protocolMagic)]) != protocolMagic {
+		return "", errors.New("not a virt-kbd client")
+	}
+	if hello[len(protocolMagic)] != protocolVersion {
+		return "", fmt.Errorf("unsupported protocol version %d", hello[len(protocolMagic)])
+	}
+	name := make([]byte, hello[len(protocolMagic)+1])
+	if _, err := io.ReadFull(r, name); err != nil {
+		return "", err
+	}
+	for _, c := range name {
+		if c < 0x20 || c == 0x7f {
+			return "", fmt.Errorf("client name %q has control characters", name)
+		}
+	}
+	return string(name), nil
+}
+
+// Reads a single frame. Returns io.EOF if the connection was closed between frames.
+func readFrame(r *bufio.Reader) (byte, []byte, error) {
+	header := make([]byte, frameHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, binary.LittleEndian.Uint16(header[1:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+func appendFrame(b []byte, frameType byte, payload []byte) []byte {
+	b = append(b, frameType)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(payload)))
+	return append(b, payload...)
+}
+
+func encodeControlMsg(control bool) []byte {
+	if control {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// Decodes the payload of a key frame
+func decodeKeyMsg(msg []byte) (int, bool, error) {
+	if len(msg) != keyMsgSize {
+		return 0, false, fmt.Errorf("key message has %d bytes, expected %d", len(msg), keyMsgSize)
+	}
+	code := int(binary.LittleEndian.Uint16(msg))
+	if code == 0 || code > keyMax {
+		return 0, false, fmt.Errorf("invalid key code %d", code)
+	}
+	// the devices only have the keys with a name, uinput would drop the others
+	// without telling
+	if _, ok := keyNames[code]; !ok {
+		return 0, false, fmt.Errorf("no key has code %d", code)
+	}
+	switch msg[2] {
+	case 0:
+		return code, false, nil
+	case 1:
+		return code, true, nil
+	}
+	return 0, false, fmt.Errorf("invalid key state %d", msg[2])
+}
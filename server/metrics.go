@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"virt-kbd/dgram"
+)
+
+// Metrics of the server, exposed in the Prometheus text format. They're kept by
+// hand, so the server doesn't need a client library. All of them work from
+// their zero values.
+type metrics struct {
+	accepted     counterVec // connections accepted, by transport
+	injected     counterVec // events injected, by key class and action
+	authFailures atomic.Uint64
+	decodeErrors atomic.Uint64
+	uinputErrors atomic.Uint64
+	dropped      atomic.Uint64 // events of clients without control or releasing keys they don't hold
+	// from reading a key frame to the key being injected
+	latency histogram
+}
+
+// Counters told apart by the values of their labels
+type counterVec struct {
+	mu     sync.Mutex
+	counts map[string]uint64 // by the label values joined with \x00
+}
+
+func (v *counterVec) inc(labelValues ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.counts == nil {
+		v.counts = make(map[string]uint64)
+	}
+	v.counts[strings.Join(labelValues, "\x00")]++
+}
+
+func (v *counterVec) snapshot() map[string]uint64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return maps.Clone(v.counts)
+}
+
+// upper bounds of the latency buckets, in seconds
+var latencyBuckets = [...]float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1}
+
+type histogram struct {
+	mu     sync.Mutex
+	counts [len(latencyBuckets) + 1]uint64 // one for each bucket and the last one for the rest
+	sum    float64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	s := d.Seconds()
+	i, _ := slices.BinarySearch(latencyBuckets[:], s)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[i]++
+	h.sum += s
+}
+
+var keyClassNames = map[keyClass]string{classKeyboard: "keyboard", classConsumer: "consumer", classSystem: "system"}
+
+// A sink counting the events that get injected and the ones that fail
+type instrumentedSink struct {
+	inputSink
+	metrics *metrics
+}
+
+func (s *instrumentedSink) KeyDown(key int) error {
+	return s.count(key, "press", s.inputSink.KeyDown(key))
+}
+
+func (s *instrumentedSink) KeyUp(key int) error {
+	return s.count(key, "release", s.inputSink.KeyUp(key))
+}
+
+func (s *instrumentedSink) count(key int, action string, err error) error {
+	if err != nil {
+		s.metrics.uinputErrors.Add(1)
+	} else {
+		s.metrics.injected.inc(keyClassNames[classifyKey(key)], action)
+	}
+	return err
+}
+
+// Returns the transport a connection came over, as the label of the accepted connections
+func connTransport(conn net.Conn) string {
+	switch conn.(type) {
+	case *net.UnixConn:
+		return "unix"
+	case *dgram.Conn:
+		return "udp"
+	}
+	return "tcp"
+}
+
+// Writes all the metrics of the server in the Prometheus text format
+func (srv *server) writeMetrics(w io.Writer) {
+	m := &srv.metrics
+	srv.mu.Lock()
+	active := len(srv.conns)
+	srv.mu.Unlock()
+	writeCounterVec(w, "virt_kbd_connections_accepted_total", "Connections accepted, by transport.", []string{"transport"}, m.accepted.snapshot())
+	writeMetric(w, "virt_kbd_connections_active", "gauge", "Connections being handled.", active)
+	writeMetric(w, "virt_kbd_clients_connected", "gauge", "Clients past the handshake.", srv.arbiter.count())
+	writeCounterVec(w, "virt_kbd_events_injected_total", "Key events injected, by key class and action.", []string{"type", "action"}, m.injected.snapshot())
+	writeMetric(w, "virt_kbd_auth_failures_total", "counter", "Connections refused for the credentials of the peer.", m.authFailures.Load())
+	writeMetric(w, "virt_kbd_decode_errors_total", "counter", "Invalid handshakes and key frames.", m.decodeErrors.Load())
+	writeCounterVec(w, "virt_kbd_events_rejected_total", "Key events rejected by the policies, by client.", []string{"client"}, srv.policies.rejected.snapshot())
+	writeMetric(w, "virt_kbd_events_dropped_total", "counter", "Key events dropped as their client didn't have control or didn't hold the key released.", m.dropped.Load())
+	writeMetric(w, "virt_kbd_uinput_write_errors_total", "counter", "Key events that couldn't be written to the virtual devices.", m.uinputErrors.Load())
+
+	const latency = "virt_kbd_injection_latency_seconds"
+	fmt.Fprintf(w, "# HELP %s Time from reading a key frame to the key being injected.\n# TYPE %s histogram\n", latency, latency)
+	m.latency.mu.Lock()
+	counts, sum := m.latency.counts, m.latency.sum
+	m.latency.mu.Unlock()
+	var cumulative uint64
+	for i, bound := range latencyBuckets {
+		cumulative += counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", latency, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+	}
+	cumulative += counts[len(latencyBuckets)]
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n%s_sum %s\n%s_count %d\n", latency, cumulative, latency, strconv.FormatFloat(sum, 'g', -1, 64), latency, cumulative)
+}
+
+func writeMetric[T int | uint64](w io.Writer, name string, typ string, help string, value T) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, typ, name, value)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func writeCounterVec(w io.Writer, name string, help string, labels []string, counts map[string]uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	for _, key := range slices.Sorted(maps.Keys(counts)) {
+		pairs := make([]string, 0, len(labels))
+		for i, value := range strings.Split(key, "\x00") {
+			pairs = append(pairs, labels[i]+`="`+labelEscaper.Replace(value)+`"`)
+		}
+		fmt.Fprintf(w, "%s{%s} %d\n", name, strings.Join(pairs, ","), counts[key])
+	}
+}
+
+// Serves /metrics and /healthz. The server is healthy while it isn't shutting
+// down and its devices are usable.
+func (srv *server) metricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		srv.writeMetrics(w)
+	})
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		if err := srv.healthy(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, "ok\n")
+	})
+	return mux
+}
+
+func (srv *server) healthy() error {
+	if srv.isStopping() {
+		return errors.New("the server is shutting down")
+	}
+	if srv.checkDevices != nil {
+		return srv.checkDevices()
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// A recording sink failing to press one of the keys, the way a device that's
+// gone would
+type failingSink struct {
+	*recordingSink
+	failing int
+}
+
+func (s *failingSink) KeyDown(key int) error {
+	if key == s.failing {
+		return errors.New("no such device")
+	}
+	return s.recordingSink.KeyDown(key)
+}
+
+func getMetrics(t *testing.T, url string) string {
+	t.Helper()
+	resp, err := http.Get(url + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+var metricLine = regexp.MustCompile(`^[a-z_]+(\{[a-z_]+="[^"]*"(,[a-z_]+="[^"]*")*\})? [0-9.e+-]+$`)
+
+func TestMetrics(t *testing.T) {
+	policies, err := newPolicySet(map[string]policyConfig{"*": {Deny: []string{"b"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := newRecordingSink()
+	srv := &server{arbiter: newArbiter(arbitrationTakeover), devices: sharedDevices(&failingSink{sink, 31}), policies: policies}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go srv.serve(context.Background(), ln)
+	metricsServer := httptest.NewServer(srv.metricsHandler())
+	defer metricsServer.Close()
+
+	invalid := dialServer(t, ln.Addr().String())
+	invalid.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	io.ReadAll(invalid)
+
+	conn, _ := connectClient(t, ln.Addr().String())
+	// dropped, the client doesn't have control before it presses a key
+	sendKeys(t, conn, up(33))
+	sendKeys(t, conn, down(30), up(30), down(113), up(113), down(48), down(31))
+	conn.Write([]byte{frameKey, keyMsgSize, 0, 30, 0, 2}) // an invalid state
+	sendKeys(t, conn, down(32), up(32))
+	sink.expect(t, down(30), up(30), down(113), up(113), down(32), up(32))
+
+	body := getMetrics(t, metricsServer.URL)
+	for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+		if !strings.HasPrefix(line, "# ") && !metricLine.MatchString(line) {
+			t.Errorf("invalid line %q", line)
+		}
+	}
+	for _, expected := range []string{
+		`virt_kbd_connections_accepted_total{transport="tcp"} 2`,
+		`virt_kbd_clients_connected 1`,
+		`virt_kbd_events_injected_total{type="keyboard",action="press"} 2`,
+		`virt_kbd_events_injected_total{type="keyboard",action="release"} 2`,
+		`virt_kbd_events_injected_total{type="consumer",action="press"} 1`,
+		`virt_kbd_events_rejected_total{client="127.0.0.1"} 1`,
+		`virt_kbd_decode_errors_total 2`,
+		`virt_kbd_uinput_write_errors_total 1`,
+		`virt_kbd_events_dropped_total 1`,
+		`virt_kbd_auth_failures_total 0`,
+		`virt_kbd_injection_latency_seconds_bucket{le="+Inf"} 6`,
+		`virt_kbd_injection_latency_seconds_count 6`,
+	} {
+		if !strings.Contains(body, expected+"\n") {
+			t.Errorf("metrics don't contain %q:\n%s", expected, body)
+		}
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	var devicesErr error
+	srv := &server{arbiter: newArbiter(arbitrationTakeover), checkDevices: func() error { return devicesErr }}
+	metricsServer := httptest.NewServer(srv.metricsHandler())
+	defer metricsServer.Close()
+	check := func(expected int) {
+		t.Helper()
+		resp, err := http.Get(metricsServer.URL + "/healthz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != expected {
+			t.Errorf("got %d %q, expected %d", resp.StatusCode, body, expected)
+		}
+	}
+	check(http.StatusOK)
+	devicesErr = errors.New("virt-kbd is gone")
+	check(http.StatusServiceUnavailable)
+	devicesErr = nil
+	srv.shutdown()
+	check(http.StatusServiceUnavailable)
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// keys that stand for both their left and right variants in a policy
+var keyAliases = map[string][]int{
+	"ctrl":  {29, 97},   // KEY_LEFTCTRL, KEY_RIGHTCTRL
+	"alt":   {56, 100},  // KEY_LEFTALT, KEY_RIGHTALT
+	"shift": {42, 54},   // KEY_LEFTSHIFT, KEY_RIGHTSHIFT
+	"meta":  {125, 126}, // KEY_LEFTMETA, KEY_RIGHTMETA
+}
+
+// Used for clients that have no policy of their own when the config doesn't
+// have a default one either. Blocks the keys that can reboot, suspend or power
+// off the machine (power keys are blocked unless a policy allows them).
+var defaultPolicyConfig = policyConfig{
+	Deny: []string{"sysrq"},
+	DenyCombos: [][]string{
+		{"ctrl", "alt", "delete"},
+		{"ctrl", "alt", "backspace"},
+	},
+}
+
+// Returns a name of a key code, eg. KEY_A
+func keyName(code int) string {
+	if name, ok := keyNames[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("KEY_%d", code)
+}
+
+// Parses a key the way it's written in a config file: a key code or a name from
+// linux/input-event-codes.h, with or without the KEY_ prefix and in any case.
+// ctrl, alt, shift and meta stand for both left and right keys.
+func parseKey(s string) ([]int, error) {
+	if code, err := strconv.Atoi(s); err == nil {
+		return []int{code}, nil
+	}
+	name := strings.TrimPrefix(strings.ToUpper(s), "KEY_")
+	if codes, ok := keyAliases[strings.ToLower(name)]; ok {
+		return codes, nil
+	}
+	for code, n := range keyNames {
+		if n == "KEY_"+name {
+			return []int{code}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key %q", s)
+}
+
+// A set of keys that have to be held together. Each element lists the codes
+// that can stand for it, eg. left or right ctrl.
+type keyCombo [][]int
+
+// A keyPolicy decides which keys a client is allowed to inject
+type keyPolicy struct {
+	allow      map[int]bool // if not empty, only these keys are allowed
+	deny       map[int]bool
+	combos     []keyCombo
+	allowPower bool // whether system control keys (power, sleep...) are allowed
+}
+
+func newKeyPolicy(c policyConfig) (*keyPolicy, error) {
+	p := &keyPolicy{allow: make(map[int]bool), deny: make(map[int]bool), allowPower: c.AllowPower}
+	for _, s := range c.Allow {
+		codes, err := parseKey(s)
+		if err != nil {
+			return nil, err
+		}
+		for _, code := range codes {
+			p.allow[code] = true
+		}
+	}
+	for _, s := range c.Deny {
+		codes, err := parseKey(s)
+		if err != nil {
+			return nil, err
+		}
+		for _, code := range codes {
+			p.deny[code] = true
+		}
+	}
+	for _, keys := range c.DenyCombos {
+		if len(keys) < 2 {
+			return nil, fmt.Errorf("combo %v needs at least two keys", keys)
+		}
+		combo := make(keyCombo, 0, len(keys))
+		for _, s := range keys {
+			codes, err := parseKey(s)
+			if err != nil {
+				return nil, err
+			}
+			combo = append(combo, codes)
+		}
+		p.combos = append(p.combos, combo)
+	}
+	return p, nil
+}
+
+// Checks if a key can be pressed while the keys in held are pressed.
+// Returns a reason if it can't.
+func (p *keyPolicy) check(key int, held map[int]bool) (bool, string) {
+	if len(p.allow) > 0 && !p.allow[key] {
+		return false, "not on the allowlist"
+	}
+	if p.deny[key] {
+		return false, "on the denylist"
+	}
+	if classifyKey(key) == classSystem && !p.allowPower {
+		return false, "power keys aren't allowed"
+	}
+	for _, combo := range p.combos {
+		if combo.completedBy(key, held) {
+			return false, "completes a denied combo"
+		}
+	}
+	return true, ""
+}
+
+// Checks if pressing key while the keys in held are pressed makes the combo
+func (c keyCombo) completedBy(key int, held map[int]bool) bool {
+	usesKey := false
+	for _, codes := range c {
+		pressed := false
+		for _, code := range codes {
+			if code == key {
+				usesKey = true
+				pressed = true
+			} else if held[code] {
+				pressed = true
+			}
+		}
+		if !pressed {
+			return false
+		}
+	}
+	return usesKey
+}
+
+// Policies of all the clients
+type policySet struct {
+	clients  map[string]*keyPolicy // by client identity
+	fallback *keyPolicy            // for clients without a policy of their own
+	rejected *rejectCounter
+}
+
+// Compiles the policies from a config. A policy for "*" is used for clients
+// that aren't listed, the built in one if there's no such policy.
+func newPolicySet(policies map[string]policyConfig) (*policySet, error) {
+	s := &policySet{clients: make(map[string]*keyPolicy), rejected: newRejectCounter()}
+	fallback := defaultPolicyConfig
+	if c, ok := policies["*"]; ok {
+		fallback = c
+	}
+	var err error
+	if s.fallback, err = newKeyPolicy(fallback); err != nil {
+		return nil, fmt.Errorf("default policy: %v", err)
+	}
+	for identity, c := range policies {
+		if identity == "*" {
+			continue
+		}
+		if s.clients[identity], err = newKeyPolicy(c); err != nil {
+			return nil, fmt.Errorf("policy of %s: %v", identity, err)
+		}
+	}
+	return s, nil
+}
+
+// Returns a filter for a single connection of a client, logging rejected keys to log
+func (s *policySet) filter(identity string, log *slog.Logger) *keyFilter {
+	policy, ok := s.clients[identity]
+	if !ok {
+		policy = s.fallback
+	}
+	return &keyFilter{identity: identity, log: log, policy: policy, rejected: s.rejected, held: make(map[int]bool), dropped: make(map[int]bool)}
+}
+
+// A keyFilter applies a policy to the keys coming from a single connection.
+// It keeps track of the keys that are held, so combos can be detected, and of
+// the keys that were rejected, so their releases are dropped as well.
+type keyFilter struct {
+	identity string
+	log      *slog.Logger
+	policy   *keyPolicy
+	rejected *rejectCounter
+	held     map[int]bool
+	dropped  map[int]bool
+}
+
+// Checks if a key event can be injected. Returns an error telling why a key
+// press was rejected, releases of rejected keys are dropped without one.
+func (f *keyFilter) allow(key int, pressed bool) (bool, error) {
+	if !pressed {
+		if f.dropped[key] {
+			delete(f.dropped, key)
+			return false, nil
+		}
+		delete(f.held, key)
+		return true, nil
+	}
+	ok, reason := f.policy.check(key, f.held)
+	if !ok {
+		f.dropped[key] = true
+		n := f.rejected.add(f.identity)
+		f.log.Warn("rejected a key", keyAttr(key), "reason", reason, "rejected_so_far", n)
+		return false, &rejectedError{reason: reason}
+	}
+	f.held[key] = true
+	return true, nil
+}
+
+// Tells why a key was rejected. It goes to the client, so it doesn't name the
+// key: whoever reads the error frames shouldn't learn what was typed.
+type rejectedError struct {
+	reason string
+}
+
+func (e *rejectedError) Error() string {
+	return "a key was rejected: " + e.reason
+}
+
+// Counts the key events rejected for each client
+type rejectCounter struct {
+	mu     sync.Mutex
+	counts map[string]uint64
+}
+
+func newRejectCounter() *rejectCounter {
+	return &rejectCounter{counts: make(map[string]uint64)}
+}
+
+// counts an event rejected for a client and returns how many were rejected so far
+func (c *rejectCounter) add(identity string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[identity]++
+	return c.counts[identity]
+}
+
+// returns the counts of all the clients
+func (c *rejectCounter) snapshot() map[string]uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return maps.Clone(c.counts)
+}
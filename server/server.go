@@ -1,74 +1,477 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log/slog"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
 	"sync"
-
-	"github.com/bendahl/uinput"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
-func runServer(port int, kbd uinput.Keyboard) {
-	slog.Info(fmt.Sprintf("starting a virtual-keyboard service on port %d", port))
-	addr := fmt.Sprintf(":%d", port)
-	ln, err := net.Listen("tcp", addr)
+// Something key events are injected into. In production it's a uinput keyboard.
+type inputSink interface {
+	KeyDown(key int) error
+	KeyUp(key int) error
+}
+
+// Gives out the devices the keys of a client are injected into. release is
+// called once the client disconnects.
+type deviceProvider func(clientName string) (sink inputSink, release func(), err error)
+
+// A provider giving all the clients the same devices
+func sharedDevices(sink inputSink) deviceProvider {
+	return func(string) (inputSink, func(), error) {
+		return sink, func() {}, nil
+	}
+}
+
+// how long the clients have to get what's left for them once the server is
+// shutting down. The connections still open afterwards are closed.
+const shutdownTimeout = 5 * time.Second
+
+// the longest the server waits before accepting again after accepting failed
+const maxAcceptDelay = time.Second
+
+type server struct {
+	arbiter  *arbiter
+	devices  deviceProvider
+	policies *policySet
+	// uids of the users whose processes can connect over unix sockets, all of them if empty
+	allowUids []uint32
+
+	mu       sync.Mutex
+	conns    map[net.Conn]bool // connections being handled
+	stopping bool              // set once the server is shutting down
+	handlers sync.WaitGroup
+
+	metrics metrics
+	// tells if the devices are still usable, for the health check, may be nil
+	checkDevices func() error
+
+	nextConnID atomic.Uint64 // for telling connections apart in the logs
+	audit      *auditLog     // nil if no audit log is kept
+
+	statusMu sync.Mutex
+	status   func(clients int) // called when a client joins or leaves, may be nil
+}
+
+// Serves the clients until ctx is done, on the sockets passed by systemd or,
+// without them, on the port, over datagrams and on the unix socket from cfg
+func runServer(ctx context.Context, cfg *config, srv *server) error {
+	listeners, err := systemdListeners()
 	if err != nil {
-		slog.Error(fmt.Sprintf("unable to start a virtual-keyboard server. Address: %s. Error: %s", addr, err.Error()))
-		os.Exit(1)
+		return err
+	}
+	if len(listeners) > 0 {
+		slog.Info("starting a virtual-keyboard service on sockets passed by systemd", "sockets", len(listeners))
+		if cfg.UDP {
+			slog.Warn("udp is ignored with sockets passed by systemd, the datagram transport isn't available")
+		}
+		if cfg.UnixSocket.Path != "" {
+			slog.Warn("unix_socket is ignored with sockets passed by systemd, add a ListenStream for it to the socket unit", "path", cfg.UnixSocket.Path)
+		}
+	} else {
+		slog.Info("starting a virtual-keyboard service", "port", cfg.Port)
+		addr := fmt.Sprintf(":%d", cfg.Port)
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return fmt.Errorf("unable to start a virtual-keyboard server. Address: %s. Error: %s", addr, err.Error())
+		}
+		listeners = append(listeners, ln)
+		if cfg.UDP {
+			slog.Info("listening for datagrams", "port", cfg.Port)
+			ln, err := listenDatagram(addr)
+			if err != nil {
+				listeners[0].Close()
+				return errors.New("couldn't listen for datagrams: " + err.Error())
+			}
+			listeners = append(listeners, ln)
+		}
+		if cfg.UnixSocket.Path != "" {
+			slog.Info("listening on a unix socket", "path", cfg.UnixSocket.Path)
+			ln, err := listenUnix(cfg.UnixSocket)
+			if err != nil {
+				for _, ln := range listeners {
+					ln.Close()
+				}
+				return err
+			}
+			listeners = append(listeners, ln)
+		}
+	}
+	if cfg.Metrics != "" {
+		ln, err := net.Listen("tcp", cfg.Metrics)
+		if err != nil {
+			for _, ln := range listeners {
+				ln.Close()
+			}
+			return errors.New("couldn't listen for metrics: " + err.Error())
+		}
+		slog.Info("serving metrics", "addr", ln.Addr().String())
+		metricsServer := &http.Server{Handler: srv.metricsHandler(), ReadHeaderTimeout: 5 * time.Second}
+		go metricsServer.Serve(ln)
+		defer metricsServer.Close()
+	}
+	if cfg.MDNS {
+		responder, err := advertiseServer(cfg, listeners)
+		if err != nil {
+			slog.Error("couldn't advertise the server", errAttr(err))
+		} else {
+			defer responder.Close()
+		}
+	}
+	srv.run(ctx, listeners...)
+	return nil
+}
+
+// Serves the clients on listeners until ctx is done, keeping the service
+// manager informed
+func (srv *server) run(ctx context.Context, listeners ...net.Listener) {
+	srv.status = func(clients int) {
+		if err := sdNotify(fmt.Sprintf("STATUS=%d clients connected", clients)); err != nil {
+			slog.Error(err.Error())
+		}
+	}
+	if err := sdNotify("READY=1\nSTATUS=0 clients connected"); err != nil {
+		slog.Error(err.Error())
+	}
+	if interval := watchdogInterval(); interval > 0 {
+		go runWatchdog(ctx, interval, func() { srv.arbiter.count() })
+	}
+	srv.serve(ctx, listeners...)
+}
+
+// Accepts connections on listeners and passes the key events coming from them
+// to the arbiter, as long as the clients' policies allow them. When ctx is done
+// or all the listeners get closed the server shuts down: it stops accepting, tells
+// the clients, releases the keys they hold and closes their connections. Returns
+// once all of them are closed.
+func (srv *server) serve(ctx context.Context, listeners ...net.Listener) {
+	accepting := sync.WaitGroup{}
+	for _, ln := range listeners {
+		stop := context.AfterFunc(ctx, func() { ln.Close() })
+		defer stop()
+		accepting.Add(1)
+		go func() {
+			defer accepting.Done()
+			srv.accept(ctx, ln)
+		}()
+	}
+	accepting.Wait()
+	if err := sdNotify("STOPPING=1"); err != nil {
+		slog.Error(err.Error())
 	}
+	srv.shutdown()
+}
+
+// Accepts connections on ln until it's closed, retrying when accepting fails
+func (srv *server) accept(ctx context.Context, ln net.Listener) {
+	var delay time.Duration
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
-			slog.Error("error while accepting connection")
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				break
+			}
+			// eg. running out of file descriptors, which may get better in a moment
+			delay = min(max(2*delay, 5*time.Millisecond), maxAcceptDelay)
+			slog.Error("error while accepting a connection", errAttr(err), "retry_in", delay)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		delay = 0
+		if !srv.track(conn) {
+			conn.Close()
 			break
 		}
-		slog.Info("accepted connection from: " + conn.RemoteAddr().String())
-		go handleConnection(conn, kbd)
+		go func() {
+			defer srv.untrack(conn)
+			srv.handleConnection(conn)
+		}()
+	}
+}
+
+// Registers a connection being handled. Returns false if the server is shutting down.
+func (srv *server) track(conn net.Conn) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.stopping {
+		return false
+	}
+	if srv.conns == nil {
+		srv.conns = make(map[net.Conn]bool)
+	}
+	srv.conns[conn] = true
+	srv.handlers.Add(1)
+	return true
+}
+
+func (srv *server) untrack(conn net.Conn) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	delete(srv.conns, conn)
+	srv.handlers.Done()
+}
+
+// Reports how many clients are connected now
+func (srv *server) clientsChanged() {
+	if srv.status == nil {
+		return
+	}
+	// keeps the reports in order, so the last one tells how many clients are left
+	srv.statusMu.Lock()
+	defer srv.statusMu.Unlock()
+	srv.status(srv.arbiter.count())
+}
+
+func (srv *server) isStopping() bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.stopping
+}
+
+// Stops reading from all the connections, which makes their handlers finish, and
+// waits for them. Connections that take longer than shutdownTimeout are closed.
+func (srv *server) shutdown() {
+	srv.mu.Lock()
+	srv.stopping = true
+	slog.Info("shutting down", "connections", len(srv.conns))
+	for conn := range srv.conns {
+		conn.SetReadDeadline(time.Now())
+	}
+	srv.mu.Unlock()
+	finished := make(chan struct{})
+	go func() {
+		srv.handlers.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return
+	case <-time.After(shutdownTimeout):
 	}
+	srv.mu.Lock()
+	slog.Warn("closing connections that didn't finish in time", "connections", len(srv.conns))
+	for conn := range srv.conns {
+		conn.Close()
+	}
+	srv.mu.Unlock()
+	<-finished
 }
 
-func handleConnection(conn net.Conn, kbd uinput.Keyboard) {
+func (srv *server) handleConnection(conn net.Conn) {
+	audit := auditRecord{Conn: srv.nextConnID.Add(1), Identity: clientIdentity(conn), Remote: connAddr(conn), Transport: connTransport(conn)}
+	log := slog.With("conn", audit.Conn, "remote", audit.Remote, "transport", audit.Transport)
+	log.Info("accepted a connection")
 	defer func() {
-		slog.Info("closing connection with " + conn.RemoteAddr().String())
+		log.Info("closing the connection")
 		conn.Close()
 	}()
-	msg := make([]byte, 2)
+	srv.metrics.accepted.inc(audit.Transport)
+	if err := checkPeer(conn, srv.allowUids); err != nil {
+		srv.metrics.authFailures.Add(1)
+		log.Warn("refused the connection", errAttr(err))
+		audit.Reason = err.Error()
+		srv.audit.record("refuse", audit)
+		return
+	}
+	r := bufio.NewReader(conn)
+	name, err := readHello(r)
+	if err != nil {
+		if srv.isStopping() {
+			return
+		}
+		srv.metrics.decodeErrors.Add(1)
+		log.Error("handshake failed", errAttr(err))
+		return
+	}
+	client := newClientConn(conn, audit.Identity, name, log)
+	log = client.log
+	audit.Client = name
+	srv.audit.record("connect", audit)
+	session := &auditSession{Start: time.Now()}
+	defer func() {
+		session.Duration = time.Since(session.Start).Seconds()
+		audit.Session = session
+		srv.audit.record("disconnect", audit)
+	}()
+	go client.writeLoop()
+	// everything queued for the client, eg. the control changes made when it
+	// leaves, is written before the connection is closed
+	defer client.closeWriter()
+	filter := srv.policies.filter(client.identity, log)
+	sink, release, err := srv.devices(client.displayName())
+	if err != nil {
+		log.Error("couldn't create devices", errAttr(err))
+		return
+	}
+	defer release()
+	client.sink, client.devices = &instrumentedSink{inputSink: sink, metrics: &srv.metrics}, sink
+	srv.arbiter.join(client)
+	srv.clientsChanged()
+	defer srv.clientsChanged()
+	defer srv.arbiter.leave(client)
+	if leds, ok := sink.(ledSource); ok {
+		cancel := leds.subscribeLeds(func(leds ledState) {
+			if err := client.send(frameLeds, []byte{byte(leds)}); err != nil && !errors.Is(err, net.ErrClosed) {
+				log.Error("couldn't send LEDs", errAttr(err))
+			}
+		})
+		defer cancel()
+	}
 	for {
-		_, err := conn.Read(msg)
+		// a frame can come split into several segments, readFrame reads until it's complete
+		frameType, payload, err := readFrame(r)
+		read := time.Now()
+		if err != nil && srv.isStopping() {
+			log.Info("disconnecting the client, the server is shutting down")
+			client.reportError(errors.New("the server is shutting down"))
+			return
+		}
 		if err == io.EOF {
-			slog.Info("connection " + conn.RemoteAddr().String() + " closed by client")
+			log.Info("connection closed by the client")
+			return
+		}
+		if err == io.ErrUnexpectedEOF {
+			log.Info("connection closed by the client in the middle of a frame")
 			return
 		}
 		if err != nil {
-			slog.Error(fmt.Sprintf("couldn't read from connection. error: %s", err.Error()))
+			log.Error("couldn't read from the connection", errAttr(err))
 			return
 		}
-		var scancode int = int(msg[0])
-		if msg[1] == 0 {
-			kbd.KeyUp(scancode)
-		} else {
-			kbd.KeyDown(scancode)
+		if frameType != frameKey && frameType != frameControlKey {
+			log.Debug("skipping a frame of an unknown type", "type", frameType)
+			continue
+		}
+		scancode, pressed, err := decodeKeyMsg(payload)
+		if err != nil {
+			srv.metrics.decodeErrors.Add(1)
+			log.Error("invalid message", errAttr(err))
+			client.reportError(err)
+			continue
 		}
+		session.Events++
+		if ok, err := filter.allow(scancode, pressed); !ok {
+			var rejected *rejectedError
+			if errors.As(err, &rejected) {
+				session.Denied++
+				denial := audit
+				denial.Reason = rejected.reason
+				srv.audit.record("deny", denial)
+			}
+			if err != nil {
+				client.reportError(err)
+			}
+			continue
+		}
+		injected, err := srv.arbiter.inject(client, scancode, pressed)
+		if err != nil {
+			log.Error("couldn't inject a key", keyAttr(scancode), errAttr(err))
+			continue
+		}
+		if !injected {
+			srv.metrics.dropped.Add(1)
+			continue
+		}
+		srv.metrics.latency.observe(time.Since(read))
 	}
 }
 
+// Returns what identifies a client in the config: the host it connects from or,
+// for unix sockets, the user running it
+func clientIdentity(conn net.Conn) string {
+	if unixConn, ok := conn.(*net.UnixConn); ok {
+		return unixIdentity(unixConn)
+	}
+	addr := connAddr(conn)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
 func main() {
-	//create uinput device
-	kbd, err := uinput.CreateKeyboard("/dev/uinput", []byte("virt-kbd"))
+	if len(os.Args) > 1 && os.Args[1] == "audit" {
+		os.Exit(runAudit(os.Args[2:]))
+	}
+	os.Exit(run())
+}
+
+// Runs the server until it gets SIGINT or SIGTERM. Returns the exit code.
+func run() int {
+	configPath := flag.String("config", "", "path to a json config file")
+	flag.Parse()
+	cfg, err := loadConfig(*configPath)
 	if err != nil {
-		slog.Error("couldn't create uinput device. Exiting")
-		os.Exit(1)
+		slog.Error(err.Error())
+		return 1
 	}
-	defer kbd.Close()
-	// run the server
-	wg := sync.WaitGroup{}
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		runServer(3001, kbd)
-	}()
-	wg.Wait()
+	if err := setupLogging(os.Stderr, cfg.Log); err != nil {
+		slog.Error(err.Error())
+		return 1
+	}
+	policies, err := newPolicySet(cfg.Policies)
+	if err != nil {
+		slog.Error("invalid key policy: " + err.Error())
+		return 1
+	}
+	mode, err := parseArbitrationMode(cfg.Arbitration)
+	if err != nil {
+		slog.Error(err.Error())
+		return 1
+	}
+	allowUids, err := lookupUids(cfg.UnixSocket.AllowUsers)
+	if err != nil {
+		slog.Error("invalid allow_users of the unix socket: " + err.Error())
+		return 1
+	}
+	srv := &server{arbiter: newArbiter(mode), policies: policies, allowUids: allowUids}
+	if cfg.Audit.Path != "" {
+		if srv.audit, err = openAuditLog(cfg.Audit); err != nil {
+			slog.Error(err.Error())
+			return 1
+		}
+		defer srv.audit.Close()
+	}
+	switch cfg.Devices {
+	case "", "shared":
+		//create uinput devices
+		kbd, err := createVirtualDevices("/dev/uinput", "virt-kbd")
+		if err != nil {
+			slog.Error(err.Error())
+			return 1
+		}
+		// destroyed once all the clients are gone and their keys released
+		defer kbd.Close()
+		srv.devices = sharedDevices(kbd)
+		srv.checkDevices = kbd.check
+	case "per_client":
+		srv.devices = perClientDevices("/dev/uinput")
+		srv.checkDevices = func() error { return checkUinput("/dev/uinput") }
+	default:
+		slog.Error(fmt.Sprintf("unknown devices option %q, expected shared or per_client", cfg.Devices))
+		return 1
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := runServer(ctx, cfg, srv); err != nil {
+		slog.Error(err.Error())
+		return 1
+	}
+	slog.Info("server stopped")
+	return 0
 }
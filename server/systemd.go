@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// the first file descriptor systemd passes, SD_LISTEN_FDS_START
+const listenFdsStart = 3
+
+// Returns the listening sockets passed by systemd when the server is started
+// by a .socket unit, or nothing if it's started some other way
+func systemdListeners() ([]net.Listener, error) {
+	return activationListeners(listenFdsStart)
+}
+
+// Returns the sockets passed the way sd_listen_fds(3) describes, starting at
+// firstFd. The environment variables are unset, so child processes don't take
+// the sockets for theirs.
+func activationListeners(firstFd int) ([]net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", os.Getenv("LISTEN_FDS"))
+	}
+	listeners := make([]net.Listener, 0, n)
+	for fd := firstFd; fd < firstFd+n; fd++ {
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, ln := range listeners {
+				ln.Close()
+			}
+			return nil, fmt.Errorf("socket %d passed by systemd: %v", fd, err)
+		}
+		listeners = append(listeners, ln)
+	}
+	return listeners, nil
+}
+
+// Sends a state to the service manager, eg. "READY=1", as sd_notify(3) does.
+// Does nothing if the server isn't run by systemd.
+func sdNotify(state string) error {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return nil
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return errors.New("couldn't connect to the service manager: " + err.Error())
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// Returns how often the service manager expects the server to tell it's alive,
+// or 0 if it doesn't
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// Pings the watchdog twice as often as it expects until ctx is done. Each ping
+// is sent once alive returns, so a server that got stuck isn't pinging.
+func runWatchdog(ctx context.Context, interval time.Duration, alive func()) {
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			alive()
+			if err := sdNotify("WATCHDOG=1"); err != nil {
+				slog.Error("couldn't ping the watchdog", errAttr(err))
+			}
+		}
+	}
+}
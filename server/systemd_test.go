@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSocketActivation(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	// a copy of the socket, passed as if it was the only one and started at its fd
+	f, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd, err := syscall.Dup(int(f.Fd()))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "1")
+	listeners, err := activationListeners(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listeners) != 1 {
+		t.Fatalf("got %d listeners, expected 1", len(listeners))
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Error("LISTEN_FDS is still set")
+	}
+	policies, err := newPolicySet(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := newRecordingSink()
+	srv := &server{arbiter: newArbiter(arbitrationMerge), devices: sharedDevices(sink), policies: policies}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go srv.serve(ctx, listeners...)
+	conn, _ := connectClient(t, ln.Addr().String())
+	sendKeys(t, conn, down(30), up(30))
+	sink.expect(t, down(30), up(30))
+}
+
+func TestSocketActivationForAnotherProcess(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	listeners, err := activationListeners(listenFdsStart)
+	if err != nil || len(listeners) != 0 {
+		t.Fatalf("got %d listeners, %v, expected none", len(listeners), err)
+	}
+}
+
+// Listens on a socket the way the service manager does. Returns a channel the
+// states sent to it come to.
+func fakeServiceManager(t *testing.T) chan string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+	states := make(chan string, 100)
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				close(states)
+				return
+			}
+			states <- string(buf[:n])
+		}
+	}()
+	return states
+}
+
+// waits for the service manager to get a state, skipping the other ones
+func expectState(t *testing.T, states chan string, expected string) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case state := <-states:
+			if state == expected {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("the service manager didn't get %q", expected)
+		}
+	}
+}
+
+func TestSdNotify(t *testing.T) {
+	states := fakeServiceManager(t)
+	t.Setenv("WATCHDOG_USEC", "20000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policies, err := newPolicySet(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &server{arbiter: newArbiter(arbitrationMerge), devices: sharedDevices(newRecordingSink()), policies: policies}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		srv.run(ctx, ln)
+		close(stopped)
+	}()
+
+	expectState(t, states, "READY=1\nSTATUS=0 clients connected")
+	first, _ := connectClient(t, ln.Addr().String())
+	expectState(t, states, "STATUS=1 clients connected")
+	connectClient(t, ln.Addr().String())
+	expectState(t, states, "STATUS=2 clients connected")
+	first.Close()
+	expectState(t, states, "STATUS=1 clients connected")
+	expectState(t, states, "WATCHDOG=1")
+	cancel()
+	expectState(t, states, "STOPPING=1")
+	<-stopped
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", "")
+	if interval := watchdogInterval(); interval != 30*time.Second {
+		t.Errorf("interval %v, expected 30s", interval)
+	}
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	if interval := watchdogInterval(); interval != 0 {
+		t.Errorf("interval %v for a watchdog of another process", interval)
+	}
+}
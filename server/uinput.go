@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// ioctls and constants from linux/uinput.h and linux/input-event-codes.h
+const (
+	uiDevCreate  = 0x5501
+	uiDevDestroy = 0x5502
+	uiDevSetup   = 0x405c5503 // _IOW('U', 3, struct uinput_setup)
+	uiSetEvBit   = 0x40045564
+	uiSetKeyBit  = 0x40045565
+	uiSetLedBit  = 0x40045569
+	uiGetSysname = 0x8040552c // UI_GET_SYSNAME(64)
+
+	uinputMaxNameSize = 80
+	busVirtual        = 0x06
+
+	evSyn     = 0x00
+	evKey     = 0x01
+	evLed     = 0x11
+	synReport = 0
+)
+
+// size of struct input_event: a timeval followed by type, code and value
+var inputEventSize = int(unsafe.Sizeof(syscall.Timeval{})) + 8
+
+// A virtual input device created through uinput
+type uinputDevice struct {
+	f    *os.File
+	name string
+	leds *ledBroadcaster // nil if the device has no LEDs
+}
+
+// Returns the codes of all the keys of the given classes, skipping buttons of
+// mice, joysticks and such. A device with buttons enabled would be taken for one of them.
+func keysOfClass(classes ...keyClass) []int {
+	keys := make([]int, 0, len(keyNames))
+	for code := range keyNames {
+		if slices.Contains(classes, classifyKey(code)) {
+			keys = append(keys, code)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// Creates a device that can press keys and has the given LEDs. path is the path
+// of the uinput device, usually /dev/uinput.
+func createKeyboard(path string, name string, keys []int, leds []int) (*uinputDevice, error) {
+	if len(name) >= uinputMaxNameSize {
+		return nil, fmt.Errorf("device name %q is too long", name)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, errors.New("couldn't open uinput: " + err.Error())
+	}
+	d := &uinputDevice{f: f, name: name}
+	if err := d.setup(keys, leds); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("couldn't create %s: %v", name, err)
+	}
+	if len(leds) > 0 {
+		d.leds = &ledBroadcaster{}
+		go d.readLeds()
+	}
+	return d, nil
+}
+
+func (d *uinputDevice) setup(keys []int, leds []int) error {
+	evs := []uintptr{evKey, evSyn}
+	if len(leds) > 0 {
+		evs = append(evs, evLed)
+	}
+	for _, ev := range evs {
+		if err := d.ioctl(uiSetEvBit, ev); err != nil {
+			return err
+		}
+	}
+	for _, key := range keys {
+		if err := d.ioctl(uiSetKeyBit, uintptr(key)); err != nil {
+			return fmt.Errorf("key %d: %v", key, err)
+		}
+	}
+	for _, led := range leds {
+		if err := d.ioctl(uiSetLedBit, uintptr(led)); err != nil {
+			return fmt.Errorf("led %d: %v", led, err)
+		}
+	}
+	// struct uinput_setup: struct input_id, name and ff_effects_max
+	setup := make([]byte, 8+uinputMaxNameSize+4)
+	binary.NativeEndian.PutUint16(setup[0:], busVirtual)
+	copy(setup[8:], d.name)
+	if err := d.ioctlPtr(uiDevSetup, unsafe.Pointer(&setup[0])); err != nil {
+		return err
+	}
+	return d.ioctl(uiDevCreate, 0)
+}
+
+// ioctls go through the raw connection rather than Fd, which would put the file
+// into blocking mode and make readLeds block Close
+func (d *uinputDevice) ioctl(req uintptr, arg uintptr) error {
+	return d.control(func(fd uintptr) syscall.Errno {
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, arg)
+		return errno
+	})
+}
+
+func (d *uinputDevice) ioctlPtr(req uintptr, arg unsafe.Pointer) error {
+	return d.control(func(fd uintptr) syscall.Errno {
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg))
+		return errno
+	})
+}
+
+func (d *uinputDevice) control(fn func(fd uintptr) syscall.Errno) error {
+	rc, err := d.f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	if err := rc.Control(func(fd uintptr) { errno = fn(fd) }); err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Writes an event followed by a report, so it's delivered right away
+func (d *uinputDevice) emit(typ uint16, code uint16, value int32) error {
+	events := make([]byte, 2*inputEventSize)
+	putInputEvent(events, typ, code, value)
+	putInputEvent(events[inputEventSize:], evSyn, synReport, 0)
+	_, err := d.f.Write(events)
+	return err
+}
+
+// encodes struct input_event, leaving the time to the kernel
+func putInputEvent(b []byte, typ uint16, code uint16, value int32) {
+	off := inputEventSize - 8
+	binary.NativeEndian.PutUint16(b[off:], typ)
+	binary.NativeEndian.PutUint16(b[off+2:], code)
+	binary.NativeEndian.PutUint32(b[off+4:], uint32(value))
+}
+
+// decodes struct input_event
+func parseInputEvent(b []byte) (typ uint16, code uint16, value int32) {
+	off := inputEventSize - 8
+	return binary.NativeEndian.Uint16(b[off:]), binary.NativeEndian.Uint16(b[off+2:]), int32(binary.NativeEndian.Uint32(b[off+4:]))
+}
+
+// Reads the events the kernel sends to the device until it's closed. The only
+// ones of interest are EV_LED, sent when something on the target, eg. a display
+// server after caps lock is pressed, sets the LEDs of the keyboard.
+func (d *uinputDevice) readLeds() {
+	event := make([]byte, inputEventSize)
+	var leds ledState
+	for {
+		if _, err := io.ReadFull(d.f, event); err != nil {
+			if !errors.Is(err, os.ErrClosed) && !errors.Is(err, syscall.ENODEV) {
+				slog.Error("couldn't read LEDs", "device", d.name, errAttr(err))
+			}
+			return
+		}
+		typ, code, value := parseInputEvent(event)
+		if typ != evLed || code >= 8 {
+			continue
+		}
+		if value != 0 {
+			leds |= 1 << code
+		} else {
+			leds &^= 1 << code
+		}
+		d.leds.set(leds)
+	}
+}
+
+func (d *uinputDevice) KeyDown(key int) error {
+	if key <= 0 || key > keyMax {
+		return fmt.Errorf("key code %d out of range", key)
+	}
+	return d.emit(evKey, uint16(key), 1)
+}
+
+func (d *uinputDevice) KeyUp(key int) error {
+	if key <= 0 || key > keyMax {
+		return fmt.Errorf("key code %d out of range", key)
+	}
+	return d.emit(evKey, uint16(key), 0)
+}
+
+func (d *uinputDevice) Close() error {
+	d.ioctl(uiDevDestroy, 0)
+	return d.f.Close()
+}
+
+// Tells if the device still exists: the kernel knows its name in sysfs and it's
+// there
+func (d *uinputDevice) check() error {
+	buf := make([]byte, 64)
+	if err := d.ioctlPtr(uiGetSysname, unsafe.Pointer(&buf[0])); err != nil {
+		return fmt.Errorf("%s isn't usable: %v", d.name, err)
+	}
+	sysname, _, _ := strings.Cut(string(buf), "\x00")
+	if _, err := os.Stat("/sys/devices/virtual/input/" + sysname); err != nil {
+		return fmt.Errorf("%s is gone: %v", d.name, err)
+	}
+	return nil
+}
+
+// Tells if new devices can be created at path
+func checkUinput(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return errors.New("uinput isn't usable: " + err.Error())
+	}
+	return f.Close()
+}
+
+// The devices keys are injected into. Plain keys go to a keyboard, consumer and
+// system control keys to a device of their own, the way real keyboards with media
+// keys present themselves.
+type virtualDevices struct {
+	keyboard *uinputDevice
+	control  *uinputDevice
+}
+
+// Creates the devices named name and "name control"
+func createVirtualDevices(path string, name string) (*virtualDevices, error) {
+	keyboard, err := createKeyboard(path, name, keysOfClass(classKeyboard), keyboardLeds)
+	if err != nil {
+		return nil, err
+	}
+	control, err := createKeyboard(path, name+" control", keysOfClass(classConsumer, classSystem), nil)
+	if err != nil {
+		keyboard.Close()
+		return nil, err
+	}
+	return &virtualDevices{keyboard: keyboard, control: control}, nil
+}
+
+func (d *virtualDevices) device(key int) *uinputDevice {
+	if classifyKey(key) == classKeyboard {
+		return d.keyboard
+	}
+	return d.control
+}
+
+func (d *virtualDevices) KeyDown(key int) error {
+	return d.device(key).KeyDown(key)
+}
+
+func (d *virtualDevices) KeyUp(key int) error {
+	return d.device(key).KeyUp(key)
+}
+
+// The LEDs are the ones of the keyboard. With devices shared by all the clients
+// they're shared as well.
+func (d *virtualDevices) subscribeLeds(fn func(leds ledState)) func() {
+	return d.keyboard.leds.subscribeLeds(fn)
+}
+
+func (d *virtualDevices) check() error {
+	return errors.Join(d.keyboard.check(), d.control.check())
+}
+
+func (d *virtualDevices) Close() error {
+	d.control.Close()
+	return d.keyboard.Close()
+}
+
+// A provider creating devices for each client, named "virt-kbd: <client name>",
+// and destroying them when the client disconnects
+func perClientDevices(path string) deviceProvider {
+	return func(clientName string) (inputSink, func(), error) {
+		name := "virt-kbd: " + clientName
+		// leave room for the name of the control device
+		if max := uinputMaxNameSize - 1 - len(" control"); len(name) > max {
+			name = strings.ToValidUTF8(name[:max], "")
+		}
+		devices, err := createVirtualDevices(path, name)
+		if err != nil {
+			return nil, nil, err
+		}
+		return devices, func() { devices.Close() }, nil
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"syscall"
+)
+
+// Configuration of a unix socket the server listens on besides the tcp port, eg.
+//
+//	"unix_socket": {"path": "/run/virt-kbd.sock", "mode": "0660", "group": "virt-kbd", "allow_users": ["alice"]}
+type unixSocketConfig struct {
+	Path  string `json:"path"`
+	Mode  string `json:"mode"`  // permissions of the socket in octal, 0660 by default
+	Group string `json:"group"` // group owning the socket, a name or a gid
+	// if not empty, only processes of these users (names or uids) can connect
+	AllowUsers []string `json:"allow_users"`
+}
+
+const defaultUnixSocketMode = 0o660
+
+// Listens on a unix socket with the permissions and the group from cfg. A socket
+// left by a server that's gone is replaced, one a server still listens on or
+// anything else at the path isn't.
+func listenUnix(cfg unixSocketConfig) (net.Listener, error) {
+	mode := os.FileMode(defaultUnixSocketMode)
+	if cfg.Mode != "" {
+		m, err := strconv.ParseUint(cfg.Mode, 8, 32)
+		if err != nil || m > 0o777 {
+			return nil, fmt.Errorf("invalid mode %q of the unix socket", cfg.Mode)
+		}
+		mode = os.FileMode(m)
+	}
+	gid := -1
+	if cfg.Group != "" {
+		g, err := lookupGroup(cfg.Group)
+		if err != nil {
+			return nil, err
+		}
+		gid = g
+	}
+	if err := checkSocketPath(cfg.Path); err != nil {
+		return nil, err
+	}
+	// the socket is created in a directory only the server can enter, so nobody
+	// can connect before its permissions are set, and moved in place after that
+	dir, err := os.MkdirTemp(filepath.Dir(cfg.Path), ".virt-kbd-")
+	if err != nil {
+		return nil, errors.New("couldn't create a directory for the unix socket: " + err.Error())
+	}
+	defer os.RemoveAll(dir)
+	tmpPath := filepath.Join(dir, "sock")
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmpPath, Net: "unix"})
+	if err != nil {
+		return nil, errors.New("couldn't listen on a unix socket: " + err.Error())
+	}
+	ln.SetUnlinkOnClose(false)
+	if err := os.Chown(tmpPath, -1, gid); err != nil {
+		ln.Close()
+		return nil, errors.New("couldn't change the group of the unix socket: " + err.Error())
+	}
+	if err := os.Chmod(tmpPath, mode); err != nil {
+		ln.Close()
+		return nil, errors.New("couldn't change the permissions of the unix socket: " + err.Error())
+	}
+	// checked again right before it's replaced, something may have been put
+	// there meanwhile
+	if err := checkSocketPath(cfg.Path); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmpPath, cfg.Path); err != nil {
+		ln.Close()
+		return nil, errors.New("couldn't move the unix socket in place: " + err.Error())
+	}
+	return &unixListener{UnixListener: ln, path: cfg.Path}, nil
+}
+
+// Checks that the socket can be moved to path: there's nothing there or a socket
+// left by a server that's gone. Anything else, a regular file or a socket
+// something still listens on, isn't replaced.
+func checkSocketPath(path string) error {
+	fi, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s is there already and isn't a socket", path)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("another server listens on %s", path)
+	}
+	return nil
+}
+
+// A listener on a unix socket that was moved after it was bound. It tells the
+// path the socket was moved to and removes the socket there when it's closed.
+type unixListener struct {
+	*net.UnixListener
+	path string
+}
+
+func (l *unixListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: l.path, Net: "unix"}
+}
+
+func (l *unixListener) Close() error {
+	err := l.UnixListener.Close()
+	if err == nil {
+		os.Remove(l.path)
+	}
+	return err
+}
+
+func lookupGroup(name string) (int, error) {
+	if gid, err := strconv.Atoi(name); err == nil {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(g.Gid)
+}
+
+// Turns user names and uids into uids
+func lookupUids(users []string) ([]uint32, error) {
+	uids := make([]uint32, 0, len(users))
+	for _, name := range users {
+		if uid, err := strconv.ParseUint(name, 10, 32); err == nil {
+			uids = append(uids, uint32(uid))
+			continue
+		}
+		u, err := user.Lookup(name)
+		if err != nil {
+			return nil, err
+		}
+		uid, err := strconv.ParseUint(u.Uid, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		uids = append(uids, uint32(uid))
+	}
+	return uids, nil
+}
+
+// Returns the credentials of the process on the other side of a unix socket,
+// as they were when it connected
+func peerCredentials(conn *net.UnixConn) (*syscall.Ucred, error) {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	if err := rc.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return nil, err
+	}
+	return cred, credErr
+}
+
+// Checks if the process on the other side of a unix socket is allowed to connect.
+// Connections over tcp are always allowed, their policy decides what they can do.
+func checkPeer(conn net.Conn, allowUids []uint32) error {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok || len(allowUids) == 0 {
+		return nil
+	}
+	cred, err := peerCredentials(unixConn)
+	if err != nil {
+		return errors.New("couldn't get credentials of the peer: " + err.Error())
+	}
+	if !slices.Contains(allowUids, cred.Uid) {
+		return fmt.Errorf("uid %d isn't allowed to connect", cred.Uid)
+	}
+	return nil
+}
+
+// Returns what identifies a process connected over a unix socket in the config:
+// "unix:" followed by the name of its user or its uid
+func unixIdentity(conn *net.UnixConn) string {
+	cred, err := peerCredentials(conn)
+	if err != nil {
+		return "unix:?"
+	}
+	uid := strconv.FormatUint(uint64(cred.Uid), 10)
+	if u, err := user.LookupId(uid); err == nil {
+		return "unix:" + u.Username
+	}
+	return "unix:" + uid
+}
+
+// Describes where a connection comes from: the address for tcp, the process
+// for unix sockets
+func connAddr(conn net.Conn) string {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return conn.RemoteAddr().String()
+	}
+	cred, err := peerCredentials(unixConn)
+	if err != nil {
+		return "unix socket"
+	}
+	return fmt.Sprintf("pid %d (uid %d) on unix socket", cred.Pid, cred.Uid)
+}
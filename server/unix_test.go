@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// starts a server listening on a unix socket only
+func startUnixServer(t *testing.T, socket unixSocketConfig, policies map[string]policyConfig) *recordingSink {
+	t.Helper()
+	policySet, err := newPolicySet(policies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowUids, err := lookupUids(socket.AllowUsers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := listenUnix(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := newRecordingSink()
+	srv := &server{arbiter: newArbiter(arbitrationMerge), devices: sharedDevices(sink), policies: policySet, allowUids: allowUids}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		srv.serve(ctx, ln)
+		close(stopped)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+	return sink
+}
+
+func TestE2EUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "virt-kbd.sock")
+	me, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the identity of a process connected over a unix socket is its user
+	sink := startUnixServer(t, unixSocketConfig{Path: path, Mode: "0600"}, map[string]policyConfig{
+		"unix:" + me.Username: {Deny: []string{"a"}},
+	})
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o600 {
+		t.Errorf("socket has permissions %v, expected 0600", fi.Mode().Perm())
+	}
+	runClientWithArgs(t, []string{"-unix", path}, down(30), up(30), down(31), up(31))
+	sink.expect(t, down(31), up(31))
+}
+
+func TestUnixSocketAllowUsers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "virt-kbd.sock")
+	other := strconv.Itoa(os.Getuid() + 1)
+	startUnixServer(t, unixSocketConfig{Path: path, AllowUsers: []string{other}}, nil)
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write(hello())
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	// the connection is closed without a control notification, reset if the
+	// server didn't read the hello
+	n, err := conn.Read(make([]byte, 1))
+	if n > 0 || err == nil || os.IsTimeout(err) {
+		t.Fatalf("read %d bytes, %v, expected the server to refuse the connection", n, err)
+	}
+}
+
+func TestUnixSocketInUse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "virt-kbd.sock")
+	startUnixServer(t, unixSocketConfig{Path: path}, nil)
+	if ln, err := listenUnix(unixSocketConfig{Path: path}); err == nil {
+		ln.Close()
+		t.Fatal("a second server took over the socket")
+	}
+}
+
+func TestUnixSocketNotASocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "virt-kbd.sock")
+	if err := os.WriteFile(path, []byte("data"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if ln, err := listenUnix(unixSocketConfig{Path: path}); err == nil {
+		ln.Close()
+		t.Fatal("a regular file was replaced by the socket")
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "data" {
+		t.Errorf("the file has %q (%v)", data, err)
+	}
+	// nor is the directory the socket was created in left behind
+	if entries, err := os.ReadDir(filepath.Dir(path)); err != nil || len(entries) != 1 {
+		t.Errorf("left behind %v (%v)", entries, err)
+	}
+}
+
+func TestUnixSocketLeftBehind(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "virt-kbd.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a server that crashed doesn't remove its socket
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+	ln, err = listenUnix(unixSocketConfig{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr := ln.Addr().String(); addr != path {
+		t.Errorf("listening on %s, expected %s", addr, path)
+	}
+	ln.Close()
+	// neither the socket nor the directory it was created in are left
+	if entries, err := os.ReadDir(filepath.Dir(path)); err != nil || len(entries) != 0 {
+		t.Errorf("left behind %v (%v)", entries, err)
+	}
+}